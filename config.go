@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DEFAULT_CONFIGS are read, in order, when --config is not given.  Later
+// files override earlier ones.
+var DEFAULT_CONFIGS = []string{"~/.jparrc", "jpar.yaml"}
+
+// withConfig inserts the options read from config files ahead of the
+// command line's, so that flags override them, and after any subcommand,
+// so that it is still recognised.  The options of the profile named by
+// --profile follow those outside profiles.  It also returns the
+// profile's command, which is run when none is given.
+func withConfig(argv []string) ([]string, []string, error) {
+	paths, explicit := configPaths(argv)
+	profiles := flagValues(argv, "--profile")
+	profile := ""
+	if len(profiles) > 0 {
+		profile = profiles[len(profiles)-1]
+	}
+	c := &config{profile: profile}
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) && !explicit {
+			continue
+		}
+		if err := c.read(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	if profile != "" && !c.found {
+		return nil, nil, fmt.Errorf("unknown profile %s", profile)
+	}
+	opts := append(c.argv, c.profileArgv...)
+	head := 1
+	if len(argv) > 1 && slices.Contains(SUBCOMMANDS, argv[1]) {
+		head = 2
+	}
+	withOpts := append(append([]string{}, argv[:head]...), opts...)
+	return append(withOpts, argv[head:]...), c.command, nil
+}
+
+// config accumulates the options read from config files.
+type config struct {
+	profile string
+	found bool
+	argv []string
+	profileArgv []string
+	command []string
+}
+
+// flagValues returns the values given for a flag.
+func flagValues(argv []string, flag string) []string {
+	values := []string{}
+	for i := 1; i < len(argv)-1; i++ {
+		if argv[i] == flag {
+			values = append(values, argv[i+1])
+			i = i + 1
+		}
+	}
+	return values
+}
+
+// configPaths returns the config files named with --config, or the
+// default config files when there are none.
+func configPaths(argv []string) ([]string, bool) {
+	paths := flagValues(argv, "--config")
+	if len(paths) > 0 {
+		return paths, true
+	}
+	for _, p := range DEFAULT_CONFIGS {
+		if strings.HasPrefix(p, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				continue
+			}
+			p = filepath.Join(home, p[2:])
+		}
+		paths = append(paths, p)
+	}
+	return paths, false
+}
+
+// read reads a YAML config file mapping option names, without their
+// leading dashes, to values.  Named profiles are kept under profiles,
+// and may also give a command.
+func (c *config) read(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config: %s", err)
+	}
+	var options map[string]interface{}
+	if err := yaml.Unmarshal(b, &options); err != nil {
+		return fmt.Errorf("cannot parse config %s: %s", path, err)
+	}
+	profiles, ok := options["profiles"].(map[string]interface{})
+	if _, present := options["profiles"]; present && !ok {
+		return fmt.Errorf("profiles in config %s must be a map", path)
+	}
+	delete(options, "profiles")
+	argv, err := configArgs(path, options)
+	if err != nil {
+		return err
+	}
+	c.argv = append(c.argv, argv...)
+	if c.profile == "" {
+		return nil
+	}
+	p, ok := profiles[c.profile]
+	if !ok {
+		return nil
+	}
+	options, ok = p.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("profile %s in config %s must be a map", c.profile, path)
+	}
+	c.found = true
+	if cmd, ok := options["command"]; ok {
+		delete(options, "command")
+		command, err := configCommand(cmd)
+		if err != nil {
+			return fmt.Errorf("profile %s in config %s: %s", c.profile, path, err)
+		}
+		c.command = command
+	}
+	argv, err = configArgs(path, options)
+	if err != nil {
+		return err
+	}
+	c.profileArgv = append(c.profileArgv, argv...)
+	return nil
+}
+
+// configArgs converts config options to flags.
+func configArgs(path string, options map[string]interface{}) ([]string, error) {
+	names := []string{}
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	argv := []string{}
+	for _, name := range names {
+		if !configOption(name) {
+			return nil, fmt.Errorf("unknown option %s in config %s", name, path)
+		}
+		flags, err := configFlags("--"+name, options[name])
+		if err != nil {
+			return nil, fmt.Errorf("option %s in config %s: %s", name, path, err)
+		}
+		argv = append(argv, flags...)
+	}
+	return argv, nil
+}
+
+// configCommand reads a profile's command, which is a list of templates
+// or a single template.
+func configCommand(v interface{}) ([]string, error) {
+	words, ok := v.([]interface{})
+	if !ok {
+		words = []interface{}{v}
+	}
+	command := []string{}
+	for _, w := range words {
+		s, err := configScalar(w)
+		if err != nil {
+			return nil, err
+		}
+		command = append(command, s)
+	}
+	return command, nil
+}
+
+// configFlags converts a config value to flags.  True switches the
+// option on, false leaves it off, lists repeat the option, and maps give
+// one KEY=VALUE option per entry.
+func configFlags(flag string, v interface{}) ([]string, error) {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return []string{flag}, nil
+		}
+		return nil, nil
+	case []interface{}:
+		argv := []string{}
+		for _, e := range x {
+			s, err := configScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, flag, s)
+		}
+		return argv, nil
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		argv := []string{}
+		for _, k := range keys {
+			s, err := configScalar(x[k])
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, flag, k+"="+s)
+		}
+		return argv, nil
+	}
+	s, err := configScalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{flag, s}, nil
+}
+
+// configScalar formats a single config value as a flag argument.
+func configScalar(v interface{}) (string, error) {
+	switch v.(type) {
+	case string, int, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+var usageOption = regexp.MustCompile(`(?m)^  (?:-\w, )?--([\w-]+)`)
+
+// configOption reports whether a config file may set an option.  These
+// are the options listed in the usage message, except those which do
+// not configure a run.
+func configOption(name string) bool {
+	switch name {
+	case "config", "profile", "help", "version":
+		return false
+	}
+	for _, m := range usageOption.FindAllStringSubmatch(USAGE, -1) {
+		if m[1] == name {
+			return true
+		}
+	}
+	return false
+}
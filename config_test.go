@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jpar.yaml")
+	yml := `
+parallelism: 4
+timeout: 30s
+keep-order: true
+emit-skipped: false
+header:
+  - "Accept: application/json"
+env:
+  TOKEN: "{{_env.TOKEN}}"
+`
+	if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &config{}
+	if err := c.read(path); err != nil {
+		t.Fatal(err)
+	}
+	argv := c.argv
+	want := "--env TOKEN={{_env.TOKEN}} --header Accept: application/json --keep-order --parallelism 4 --timeout 30s"
+	if got := strings.Join(argv, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadConfigUnknownOption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jpar.yaml")
+	for _, yml := range []string{"parallelizm: 4", "version: true", "timeout: {a: {b: 1}}"} {
+		if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&config{}).read(path); err == nil {
+			t.Errorf("expected %q to be rejected", yml)
+		}
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jpar.yaml")
+	if err := os.WriteFile(path, []byte("parallelism: 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	argv, _, err := withConfig([]string{"jpar", "--config", path, "-p", "2", "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(argv, " "); got != "jpar --parallelism 4 --config "+path+" -p 2 echo" {
+		t.Errorf("unexpected argv %q", got)
+	}
+	argv, _, err = withConfig([]string{"jpar", "check", "--config", path, "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(argv, " "); got != "jpar check --parallelism 4 --config "+path+" echo" {
+		t.Errorf("expected the config after the subcommand, got %q", got)
+	}
+	if _, _, err := withConfig([]string{"jpar", "--config", path + ".missing", "echo"}); err == nil {
+		t.Errorf("expected a missing config to be an error")
+	}
+}
+
+func TestWithConfigProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jpar.yaml")
+	yml := `
+parallelism: 4
+profiles:
+  backfill:
+    parallelism: 32
+    rate: 10/s
+    command: [./backfill, "{{day}}"]
+`
+	if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	argv, command, err := withConfig([]string{"jpar", "--config", path, "--profile", "backfill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "jpar --parallelism 4 --parallelism 32 --rate 10/s --config " + path + " --profile backfill"
+	if got := strings.Join(argv, " "); got != want {
+		t.Errorf("got argv %q, want %q", got, want)
+	}
+	if got := strings.Join(command, " "); got != "./backfill {{day}}" {
+		t.Errorf("unexpected command %q", got)
+	}
+	if _, _, err := withConfig([]string{"jpar", "--config", path, "--profile", "nope"}); err == nil {
+		t.Errorf("expected an unknown profile to be an error")
+	}
+}
+
+func TestCheckWithConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("jpar.yaml", []byte("timeout: 5s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withStdin(t, `{"a":"x"}`)
+	out := filepath.Join(dir, "out.json")
+	// Run as a command, check would fail to start and write a result.
+	if err := NewApp().Run([]string{"jpar", "check", "--output", out, "echo", "{{a}}"}); err != nil {
+		t.Errorf("expected the templates to check out, got %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("expected check not to run the command")
+	}
+	withStdin(t, `{"a":"x"}`)
+	if err := NewApp().Run([]string{"jpar", "check", "echo", "{{b}}"}); err == nil {
+		t.Error("expected check to report the undefined variable")
+	}
+}
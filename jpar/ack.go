@@ -0,0 +1,77 @@
+package jpar
+
+import (
+	"sync"
+)
+
+// recordAck acknowledges a record read from a queue once every result
+// made from it has been produced.  The record succeeds when none of its
+// results failed.  A record may also be answered with each of its
+// results as they are produced.  Its methods do nothing on a nil
+// recordAck, which stands for a record which needs no acknowledgement.
+type recordAck struct {
+	mu sync.Mutex
+	ack func(ok bool)
+	reply func(v interface{})
+	pending int
+	failed bool
+	sealed bool
+}
+
+func newRecordAck(ack func(ok bool), reply func(v interface{})) *recordAck {
+	if ack == nil && reply == nil {
+		return nil
+	}
+	return &recordAck{ack: ack, reply: reply}
+}
+
+// respond answers the record with one of its results.
+func (a *recordAck) respond(v interface{}) {
+	if a == nil || a.reply == nil {
+		return
+	}
+	a.reply(v)
+}
+
+// add counts a result which the record waits for.
+func (a *recordAck) add() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = a.pending + 1
+}
+
+// done records a result of the record.
+func (a *recordAck) done(failed bool) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = a.pending - 1
+	a.failed = a.failed || failed
+	a.settle()
+}
+
+// seal marks the record as fully read, so it is acknowledged once its
+// pending results are produced.
+func (a *recordAck) seal() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sealed = true
+	a.settle()
+}
+
+func (a *recordAck) settle() {
+	if !a.sealed || a.pending > 0 || a.ack == nil {
+		return
+	}
+	ack := a.ack
+	a.ack = nil
+	ack(!a.failed)
+}
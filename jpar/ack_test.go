@@ -0,0 +1,56 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestRecordAck(t *testing.T) {
+	cases := []struct {
+		results []bool
+		want string
+	}{
+		{nil, "ack"},
+		{[]bool{false, false}, "ack"},
+		{[]bool{false, true}, "nack"},
+	}
+	for _, c := range cases {
+		got := ""
+		a := newRecordAck(func(ok bool) {
+			if got != "" {
+				t.Error("acknowledged twice")
+			}
+			got = map[bool]string{true: "ack", false: "nack"}[ok]
+		}, nil)
+		for range c.results {
+			a.add()
+		}
+		for _, failed := range c.results {
+			a.done(failed)
+			if got != "" {
+				t.Errorf("%v: acknowledged before the record was sealed", c.results)
+			}
+		}
+		a.seal()
+		if got != c.want {
+			t.Errorf("%v: got %q, want %q", c.results, got, c.want)
+		}
+	}
+	// A nil recordAck stands for a record which needs no acknowledgement.
+	var none *recordAck
+	none.add()
+	none.done(true)
+	none.seal()
+	none.respond("x")
+	if newRecordAck(nil, nil) != nil {
+		t.Error("expected no recordAck for a record which needs no acknowledgement")
+	}
+	replies := []interface{}{}
+	a := newRecordAck(nil, func(v interface{}) { replies = append(replies, v) })
+	a.add()
+	a.respond("r")
+	a.done(false)
+	a.seal()
+	if len(replies) != 1 || replies[0] != "r" {
+		t.Errorf("got replies %v", replies)
+	}
+}
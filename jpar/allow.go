@@ -0,0 +1,106 @@
+package jpar
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var shellAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// programWord returns the word of a command's first argument which
+// names the program.  In shell mode the argument is a script, whose
+// first word after any variable assignments is the program.
+func programWord(o *Options, src string) string {
+	if !o.Shell {
+		return src
+	}
+	words, err := splitWords(src)
+	if err != nil {
+		return src
+	}
+	for _, w := range words {
+		if !shellAssignment.MatchString(w) {
+			return w
+		}
+	}
+	return ""
+}
+
+// parseProgram sets the program of a command from its first argument,
+// along with the literal text of the program outside of its template
+// tags.  A program which is not one of those pieces was chosen by the
+// record rather than written in the template.
+func parseProgram(o *Options, cmd *CommandTemplate, src string) error {
+	word := programWord(o, src)
+	if cmd.Raw != nil {
+		cmd.Programs = []string{}
+		for _, p := range strings.Split(word, o.Replace) {
+			if p != "" {
+				cmd.Programs = append(cmd.Programs, p)
+			}
+		}
+		return nil
+	}
+	t, err := parseMustache(word)
+	if err != nil {
+		return fmt.Errorf("cannot parse command template %s: %s", src, err)
+	}
+	cmd.Program = t
+	cmd.Programs = []string{}
+	for _, p := range mustacheTag.Split(word, -1) {
+		if p != "" {
+			cmd.Programs = append(cmd.Programs, resolveMarkers(p))
+		}
+	}
+	return nil
+}
+
+// renderProgram expands the program of a command, without the shell
+// quoting which the rest of the command may be given.
+func renderProgram(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) string {
+	switch {
+	case cmd.Literal != nil:
+		return cmd.Literal[0]
+	case cmd.Raw != nil:
+		return strings.Replace(programWord(o, cmd.Raw[0]), o.Replace, replaceValue(o, job), -1)
+	}
+	return render(cmd.Program, job, meta)
+}
+
+// checkProgram refuses to run a program which is not one of the allowed
+// commands, or without them, one which a record has swapped in through a
+// template.
+func checkProgram(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) error {
+	if cmd.Program == nil && cmd.Raw == nil && cmd.Literal == nil {
+		return nil
+	}
+	prog := renderProgram(o, cmd, job, meta)
+	if len(o.AllowCmds) > 0 {
+		if !allowedProgram(o.AllowCmds, prog) {
+			return fmt.Errorf("command %s is not allowed", prog)
+		}
+		return nil
+	}
+	if cmd.Literal == nil && !slices.Contains(cmd.Programs, prog) {
+		return fmt.Errorf("command %s was chosen by the record; use --allow-cmd to allow it", prog)
+	}
+	return nil
+}
+
+// allowedProgram reports whether a program is one of the allowed
+// commands.  Names match programs found on the path and paths match the
+// same path, so an allowed name cannot run a file elsewhere.
+func allowedProgram(allowed []string, prog string) bool {
+	for _, a := range allowed {
+		if a == prog {
+			return true
+		}
+		if strings.Contains(a, "/") && strings.Contains(prog, "/") && filepath.Clean(a) == filepath.Clean(prog) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package jpar
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckProgram(t *testing.T) {
+	cases := []struct {
+		o *Options
+		args []string
+		job interface{}
+		ok bool
+	}{
+		{&Options{}, []string{"echo", "{{x}}"}, map[string]interface{}{"x": "rm"}, true},
+		{&Options{}, []string{"{{x}}", "-rf"}, map[string]interface{}{"x": "rm"}, false},
+		{&Options{}, []string{"./bin/{{x}}"}, map[string]interface{}{"x": "tool"}, false},
+		{&Options{}, []string{"{{#fast}}gzip{{/fast}}{{^fast}}xz{{/fast}}"}, map[string]interface{}{"fast": true}, true},
+		{&Options{}, []string{"{{#fast}}gzip{{/fast}}{{^fast}}xz{{/fast}}"}, map[string]interface{}{"fast": false}, true},
+		{&Options{AllowCmds: []string{"gzip", "xz"}}, []string{"{{x}}"}, map[string]interface{}{"x": "xz"}, true},
+		{&Options{AllowCmds: []string{"gzip", "xz"}}, []string{"{{x}}"}, map[string]interface{}{"x": "/tmp/xz"}, false},
+		{&Options{AllowCmds: []string{"gzip"}}, []string{"echo"}, nil, false},
+		{&Options{AllowCmds: []string{"/usr/bin/gzip"}}, []string{"/usr//bin/gzip"}, nil, true},
+		{&Options{Shell: true}, []string{"LANG=C {{x}} | wc -l"}, map[string]interface{}{"x": "rm"}, false},
+		{&Options{Shell: true}, []string{"LANG=C sort {{x}} | wc -l"}, map[string]interface{}{"x": "rm"}, true},
+		{&Options{Replace: "{}"}, []string{"{}", "-v"}, "rm", false},
+		{&Options{Replace: "{}"}, []string{"gzip", "{}"}, "rm", true},
+	}
+	for _, c := range cases {
+		c.o.Args = c.args
+		cmd, err := parseCommandTemplate(c.o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkProgram(c.o, cmd, c.job, nil)
+		if (err == nil) != c.ok {
+			t.Errorf("checkProgram(%v, %v) = %v, want allowed %v", c.args, c.job, err, c.ok)
+		}
+	}
+}
+
+func TestRunJobRefusesProgram(t *testing.T) {
+	o := &Options{Args: []string{"{{prog}}", "hello"}, Then: []string{"{{prog}} again"}}
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := runJob(context.Background(), o, cmd, map[string]interface{}{"prog": "echo"}, nil)
+	if r["outcome"] != OUTCOME_FAILURE || !strings.Contains(r["error"].(string), "chosen by the record") {
+		t.Errorf("expected the command to be refused, got %v", r)
+	}
+	o.AllowCmds = []string{"echo"}
+	r = runJob(context.Background(), o, cmd.Then[0], map[string]interface{}{"prog": "echo"}, nil)
+	if r["stdout"] != "again\n" {
+		t.Errorf("expected the allowed step to run, got %v", r)
+	}
+}
@@ -0,0 +1,105 @@
+package jpar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmyounker/mustache"
+)
+
+// outputCapture collects one of a child's output streams, either in
+// memory or in a file whose path is rendered from the record.  Output
+// held in memory is truncated after max bytes when max is positive.
+type outputCapture struct {
+	name string
+	buf bytes.Buffer
+	max int64
+	truncated bool
+	file *os.File
+	bytes int64
+	err error
+	// lines receive each line of output when it is streamed or passed
+	// through.
+	lines []*lineEvents
+	// idle is restarted by every write when an inactivity timeout is
+	// set.
+	idle *inactivityTimer
+}
+
+func newOutputCapture(name string, path *mustache.Template, max int64, job interface{}, meta map[string]interface{}) (*outputCapture, error) {
+	oc := &outputCapture{name: name, max: max}
+	if path == nil {
+		return oc, nil
+	}
+	p := render(path, job, meta)
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s file: %s", name, err)
+	}
+	oc.file = f
+	return oc, nil
+}
+
+// collect copies the stream in the background.  The returned channel
+// is closed once the stream is exhausted.
+func (oc *outputCapture) collect(rdr io.Reader) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		var w io.Writer = oc
+		if oc.file != nil {
+			w = oc.file
+		}
+		for _, l := range oc.lines {
+			w = io.MultiWriter(w, l)
+		}
+		if oc.idle != nil {
+			w = io.MultiWriter(w, oc.idle)
+		}
+		oc.bytes, oc.err = io.Copy(w, rdr)
+		for _, l := range oc.lines {
+			l.flush()
+		}
+		close(done)
+	}()
+	return done
+}
+
+// record stores the captured output in a result.  Output written to a
+// file is reported by path and size instead of by content.
+func (oc *outputCapture) record(r map[string]interface{}) {
+	if oc.file == nil {
+		r[oc.name] = oc.buf.String()
+		if oc.truncated {
+			r[oc.name+"_truncated"] = true
+		}
+		return
+	}
+	delete(r, oc.name)
+	r[oc.name+"_file"] = oc.file.Name()
+	r[oc.name+"_bytes"] = oc.bytes
+}
+
+// Write buffers output up to the size limit.  Anything beyond it is
+// discarded, but the stream is still drained so the child never blocks.
+func (oc *outputCapture) Write(p []byte) (int, error) {
+	if oc.max > 0 {
+		room := oc.max - int64(oc.buf.Len())
+		if int64(len(p)) > room {
+			if room > 0 {
+				oc.buf.Write(p[:room])
+			}
+			oc.truncated = true
+			return len(p), nil
+		}
+	}
+	return oc.buf.Write(p)
+}
+
+func (oc *outputCapture) Close() error {
+	if oc.file == nil {
+		return nil
+	}
+	return oc.file.Close()
+}
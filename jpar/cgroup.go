@@ -0,0 +1,45 @@
+package jpar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const CGROUP_CPU_PERIOD = 100000
+
+// parseCgroupMem parses a memory limit written as a number of bytes with
+// an optional K, M, G, or T suffix, returning it as written to
+// memory.max.
+func parseCgroupMem(s string) (string, error) {
+	n := strings.TrimSuffix(strings.ToUpper(s), "B")
+	scale := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(n, suffix) {
+			n = strings.TrimSuffix(n, suffix)
+			scale = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v <= 0 {
+		return "", fmt.Errorf("memory limit %s must be a positive size such as 512M or 2G", s)
+	}
+	return strconv.FormatInt(v*scale, 10), nil
+}
+
+// parseCgroupCpu parses a CPU limit written as a number of CPUs, which
+// may be fractional, returning it as written to cpu.max.
+func parseCgroupCpu(s string) (string, error) {
+	cpus, err := strconv.ParseFloat(s, 64)
+	if err != nil || cpus <= 0 {
+		return "", fmt.Errorf("cpu limit %s must be a positive number of CPUs such as 0.5 or 2", s)
+	}
+	quota := max(int64(cpus*CGROUP_CPU_PERIOD), 1000)
+	return fmt.Sprintf("%d %d", quota, CGROUP_CPU_PERIOD), nil
+}
+
+// usesCgroup reports whether commands are confined to cgroups.
+func usesCgroup(o *Options) bool {
+	return o.CgroupMem != "" || o.CgroupCpu != ""
+}
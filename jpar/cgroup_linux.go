@@ -0,0 +1,265 @@
+package jpar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// jobCgroup is the transient cgroup holding one command and its
+// descendants.
+type jobCgroup struct {
+	dir string
+	fd *os.File
+	memMax string
+}
+
+var cgroupParent struct {
+	once sync.Once
+	dir string
+	err error
+}
+
+// openJobCgroup creates a cgroup for a job beneath jpar's own cgroup,
+// with the memory and CPU limits set.  It returns nil when commands are
+// not confined.
+func openJobCgroup(o *Options, meta map[string]interface{}) (*jobCgroup, error) {
+	if !usesCgroup(o) {
+		return nil, nil
+	}
+	cgroupParent.once.Do(func() {
+		cgroupParent.dir, cgroupParent.err = delegateCgroup(o)
+	})
+	if cgroupParent.err != nil {
+		return nil, cgroupParent.err
+	}
+	name := fmt.Sprintf("jpar-%d-job-%v", os.Getpid(), meta["_seq"])
+	return createJobCgroup(o, filepath.Join(cgroupParent.dir, name))
+}
+
+// createJobCgroup makes the cgroup directory and writes its limits.
+func createJobCgroup(o *Options, dir string) (*jobCgroup, error) {
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("cannot create cgroup: %s", err)
+	}
+	g := &jobCgroup{dir: dir}
+	if o.CgroupMem != "" {
+		mem, err := parseCgroupMem(o.CgroupMem)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.memMax = mem
+		if err := g.write("memory.max", mem); err != nil {
+			g.Close()
+			return nil, err
+		}
+		// Swapping would let a command exceed its memory limit.
+		g.write("memory.swap.max", "0")
+	}
+	if o.CgroupCpu != "" {
+		cpu, err := parseCgroupCpu(o.CgroupCpu)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		if err := g.write("cpu.max", cpu); err != nil {
+			g.Close()
+			return nil, err
+		}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		g.Close()
+		return nil, fmt.Errorf("cannot open cgroup: %s", err)
+	}
+	g.fd = f
+	return g, nil
+}
+
+func (g *jobCgroup) write(name, value string) error {
+	if err := os.WriteFile(filepath.Join(g.dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("cannot set cgroup %s: %s", name, err)
+	}
+	return nil
+}
+
+// attach starts a command directly in the cgroup.
+func (g *jobCgroup) attach(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if g == nil {
+		return attr
+	}
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(g.fd.Fd())
+	return attr
+}
+
+// finish reports in a result the peak memory of the command and its
+// descendants, and whether the kernel killed any of them for exceeding
+// the memory limit.
+func (g *jobCgroup) finish(r map[string]interface{}) {
+	if g == nil {
+		return
+	}
+	// Unlike rusage, the cgroup does not count the memory jpar had before
+	// the command started.  memory.peak is missing before Linux 5.19.
+	if b, err := os.ReadFile(filepath.Join(g.dir, "memory.peak")); err == nil {
+		if peak, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+			r["max_rss_bytes"] = peak
+		}
+	}
+	if cgroupEvent(filepath.Join(g.dir, "memory.events"), "oom_kill") > 0 {
+		r["outcome"] = OUTCOME_FAILURE
+		r["reason"] = "oom"
+		addError(r, fmt.Sprintf("killed for exceeding the memory limit of %s bytes", g.memMax))
+	}
+}
+
+// Close kills anything left in the cgroup and removes it.
+func (g *jobCgroup) Close() error {
+	if g == nil {
+		return nil
+	}
+	if g.fd != nil {
+		g.fd.Close()
+	}
+	// cgroup.kill is missing before Linux 5.14.
+	os.WriteFile(filepath.Join(g.dir, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	for i := 0; i < 50; i++ {
+		// Killed processes leave the cgroup asynchronously.
+		if err = syscall.Rmdir(g.dir); err != syscall.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("cannot remove cgroup %s: %s", g.dir, err)
+	}
+	return nil
+}
+
+// cgroupEvent reads a counter from a cgroup's events file, returning
+// zero when it cannot.
+func cgroupEvent(path, name string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// delegateCgroup finds jpar's own cgroup and enables the controllers
+// which job cgroups beneath it need.  A cgroup holding processes cannot
+// pass controllers to its children, so when enabling them fails jpar
+// moves itself into a child cgroup and tries again.  That only works
+// when jpar is alone in its cgroup, as it is under systemd-run --scope.
+func delegateCgroup(o *Options) (string, error) {
+	dir, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("cannot read cgroup controllers: %s", err)
+	}
+	available := strings.Fields(string(data))
+	needed := []string{}
+	if o.CgroupMem != "" {
+		needed = append(needed, "memory")
+	}
+	if o.CgroupCpu != "" {
+		needed = append(needed, "cpu")
+	}
+	controllers := []string{}
+	for _, c := range needed {
+		if !slices.Contains(available, c) {
+			return "", fmt.Errorf("the %s controller is not available in cgroup %s", c, dir)
+		}
+		controllers = append(controllers, "+"+c)
+	}
+	control := filepath.Join(dir, "cgroup.subtree_control")
+	enable := []byte(strings.Join(controllers, " "))
+	if os.WriteFile(control, enable, 0644) == nil {
+		return dir, nil
+	}
+	self := filepath.Join(dir, fmt.Sprintf("jpar-%d", os.Getpid()))
+	if err := os.Mkdir(self, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("cannot create cgroup: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(self, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return "", fmt.Errorf("cannot move jpar into cgroup %s: %s", self, err)
+	}
+	if err := os.WriteFile(control, enable, 0644); err != nil {
+		return "", fmt.Errorf("cannot enable cgroup controllers in %s: %s (run jpar in a delegated cgroup, such as with systemd-run --scope -p Delegate=yes)", dir, err)
+	}
+	return dir, nil
+}
+
+// ownCgroup returns the directory of jpar's cgroup in the cgroup v2
+// hierarchy.
+func ownCgroup() (string, error) {
+	mount, err := cgroupMount("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("cannot read cgroup: %s", err)
+	}
+	path, err := unifiedCgroup(string(data))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mount, path), nil
+}
+
+// cgroupMount finds where the cgroup v2 hierarchy is mounted.
+func cgroupMount(mountinfo string) (string, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return "", fmt.Errorf("cannot read mounts: %s", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// The filesystem type follows the separator after the optional
+		// fields.
+		parts := strings.SplitN(s.Text(), " - ", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) >= 5 && strings.HasPrefix(parts[1], "cgroup2 ") {
+			return fields[4], nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// unifiedCgroup returns the cgroup v2 path from /proc/self/cgroup.
+func unifiedCgroup(data string) (string, error) {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", errors.New("jpar is not in a cgroup v2 hierarchy")
+}
@@ -0,0 +1,71 @@
+package jpar
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateJobCgroup(t *testing.T) {
+	// A plain directory stands in for the cgroup filesystem.
+	dir := filepath.Join(t.TempDir(), "job")
+	o := &Options{CgroupMem: "64M", CgroupCpu: "0.5"}
+	g, err := createJobCgroup(o, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.fd.Close()
+	for name, want := range map[string]string{"memory.max": "67108864", "memory.swap.max": "0", "cpu.max": "50000 100000"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("expected %s to be %s, got %q: %v", name, want, got, err)
+		}
+	}
+	if attr := g.attach(nil); !attr.UseCgroupFD || attr.CgroupFD != int(g.fd.Fd()) {
+		t.Errorf("expected the command to start in the cgroup, got %+v", attr)
+	}
+	r := map[string]interface{}{"outcome": OUTCOME_SUCCESS}
+	g.finish(r)
+	if r["outcome"] != OUTCOME_SUCCESS {
+		t.Errorf("expected no OOM kill without memory events, got %v", r)
+	}
+	os.WriteFile(filepath.Join(dir, "memory.peak"), []byte("1351680\n"), 0644)
+	r = map[string]interface{}{"outcome": OUTCOME_SUCCESS, "max_rss_bytes": int64(35651584)}
+	g.finish(r)
+	if r["max_rss_bytes"] != int64(1351680) {
+		t.Errorf("expected the peak memory of the cgroup, got %v", r["max_rss_bytes"])
+	}
+	os.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644)
+	r = map[string]interface{}{"outcome": OUTCOME_FAILURE, "error": "killed by SIGKILL"}
+	g.finish(r)
+	if r["reason"] != "oom" || !strings.Contains(r["error"].(string), "memory limit of 67108864 bytes") {
+		t.Errorf("expected an OOM kill, got %v", r)
+	}
+}
+
+func TestCgroupMount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"22 1 0:21 / /proc rw,nosuid shared:12 - proc proc rw",
+		"30 24 0:26 / /sys/fs/cgroup rw,nosuid shared:9 - cgroup2 cgroup2 rw,nsdelegate",
+	}, "\n")), 0644)
+	mount, err := cgroupMount(path)
+	if err != nil || mount != "/sys/fs/cgroup" {
+		t.Errorf("expected /sys/fs/cgroup, got %s: %v", mount, err)
+	}
+	os.WriteFile(path, []byte("22 1 0:21 / /proc rw,nosuid shared:12 - proc proc rw\n"), 0644)
+	if _, err := cgroupMount(path); err == nil {
+		t.Error("expected an error without a cgroup2 mount")
+	}
+}
+
+func TestUnifiedCgroup(t *testing.T) {
+	path, err := unifiedCgroup("4:memory:/legacy\n0::/user.slice/run-1.scope\n")
+	if err != nil || path != "/user.slice/run-1.scope" {
+		t.Errorf("expected the unified path, got %s: %v", path, err)
+	}
+	if _, err := unifiedCgroup("4:memory:/legacy\n"); err == nil {
+		t.Error("expected an error without a unified hierarchy")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package jpar
+
+import (
+	"syscall"
+)
+
+// jobCgroup is never created, since cgroups are only supported on
+// Linux.
+type jobCgroup struct{}
+
+func openJobCgroup(o *Options, meta map[string]interface{}) (*jobCgroup, error) {
+	return nil, nil
+}
+
+func (g *jobCgroup) attach(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return attr
+}
+
+func (g *jobCgroup) finish(r map[string]interface{}) {}
+
+func (g *jobCgroup) Close() error {
+	return nil
+}
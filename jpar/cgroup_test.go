@@ -0,0 +1,52 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestParseCgroupMem(t *testing.T) {
+	cases := []struct {
+		in string
+		want string
+	}{
+		{"1048576", "1048576"},
+		{"512K", "524288"},
+		{"512M", "536870912"},
+		{"2g", "2147483648"},
+		{"1GB", "1073741824"},
+	}
+	for _, c := range cases {
+		got, err := parseCgroupMem(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("parseCgroupMem(%s) = %s, %v, want %s", c.in, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"", "0", "-1M", "lots", "1.5G"} {
+		if _, err := parseCgroupMem(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestParseCgroupCpu(t *testing.T) {
+	cases := []struct {
+		in string
+		want string
+	}{
+		{"1", "100000 100000"},
+		{"0.5", "50000 100000"},
+		{"2", "200000 100000"},
+		{"0.001", "1000 100000"},
+	}
+	for _, c := range cases {
+		got, err := parseCgroupCpu(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("parseCgroupCpu(%s) = %s, %v, want %s", c.in, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"", "0", "-1", "half"} {
+		if _, err := parseCgroupCpu(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
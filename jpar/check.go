@@ -0,0 +1,280 @@
+package jpar
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateSource is a template as it was given, with a description of
+// where it came from.
+type templateSource struct {
+	What string
+	Src string
+}
+
+// templateSources lists every template in the options.
+func templateSources(o *Options) []templateSource {
+	// The templates are parsed before they are checked, so they can be
+	// translated.
+	if t, err := withDelimiters(o); err == nil {
+		o = t
+	}
+	sources := []templateSource{}
+	// Commands given with --replace are not templates.
+	if o.Replace == "" {
+		for i, arg := range o.Args {
+			sources = append(sources, templateSource{fmt.Sprintf("argument %d", i+1), arg})
+		}
+		for i, step := range o.Then {
+			sources = append(sources, templateSource{fmt.Sprintf("step %d", i+2), step})
+		}
+	}
+	for _, e := range o.Env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			sources = append(sources, templateSource{"environment variable " + parts[0], parts[1]})
+		}
+	}
+	for _, h := range o.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) == 2 {
+			sources = append(sources, templateSource{"header " + strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+		}
+	}
+	for _, l := range o.K8sLabels {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) == 2 {
+			sources = append(sources, templateSource{"label " + parts[0], parts[1]})
+		}
+	}
+	for _, tag := range o.Tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 2 {
+			sources = append(sources, templateSource{"tag " + parts[0], parts[1]})
+		}
+	}
+	for _, v := range o.DockerVolumes {
+		sources = append(sources, templateSource{"docker volume", v})
+	}
+	optional := []templateSource{
+		{"working directory", o.Cwd},
+		{"stdin file", o.StdinFile},
+		{"stdout file", o.StdoutFile},
+		{"stderr file", o.StderrFile},
+		{"key", o.Key},
+		{"dedupe key", o.DedupeKey},
+		{"group", o.GroupBy},
+		{"docker image", o.DockerImage},
+		{"docker network", o.DockerNetwork},
+		{"kubernetes image", o.K8sImage},
+		{"kubernetes namespace", o.K8sNamespace},
+		{"kubernetes cpu", o.K8sCpu},
+		{"kubernetes memory", o.K8sMemory},
+		{"body", o.Body},
+		{"render path", o.RenderTo},
+	}
+	for _, s := range optional {
+		if s.Src != "" {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// Check parses every template in the options, returning an error if any
+// of them cannot be parsed.  When input holds a record, the first one is
+// used as a sample, and each variable which it does not define is
+// reported as a problem.
+func Check(o *Options, input io.Reader) ([]string, error) {
+	if _, err := parseCommandTemplate(o); err != nil {
+		return nil, err
+	}
+	if _, err := compileCondition(o.When); err != nil {
+		return nil, err
+	}
+	if _, err := compileOutputFilter(o.OutputFilter); err != nil {
+		return nil, err
+	}
+	if input == nil {
+		return nil, nil
+	}
+	filter, err := compileFilter(o.Filter)
+	if err != nil {
+		return nil, err
+	}
+	j, err := readInput(o, input)
+	if err != nil {
+		return nil, err
+	}
+	x, ok := <-j
+	if !ok {
+		return nil, nil
+	}
+	if x.Err != nil {
+		return nil, fmt.Errorf("cannot parse sample record: %s", x.Err)
+	}
+	sample := x.Value
+	if filter != nil {
+		values, err := applyFilter(filter, sample)
+		if err != nil {
+			return nil, fmt.Errorf("filter error: %s", err)
+		}
+		if len(values) == 0 {
+			return []string{"the sample record was removed by the filter"}, nil
+		}
+		sample = values[0]
+	}
+	if o.Batch > 0 {
+		sample = map[string]interface{}{"items": []interface{}{sample}}
+	}
+	meta := jobMeta(0, 0)
+	if len(o.Secrets) > 0 {
+		// Secrets are not read to check templates, only named.
+		names := map[string]interface{}{}
+		for _, spec := range o.Secrets {
+			names[strings.SplitN(spec, "=", 2)[0]] = ""
+		}
+		meta["_secrets"] = names
+	}
+	problems := []string{}
+	for _, s := range templateSources(o) {
+		for _, name := range undefinedVariables(s.Src, sample, meta) {
+			problems = append(problems, fmt.Sprintf("%s: %s is not defined by the sample record", s.What, name))
+		}
+	}
+	return problems, nil
+}
+
+// missingVariables lists the variables used by the templates which a
+// record does not define.
+func missingVariables(o *Options, record interface{}, meta map[string]interface{}) []string {
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, s := range templateSources(o) {
+		for _, name := range undefinedVariables(s.Src, record, meta) {
+			if !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+		}
+	}
+	return missing
+}
+
+// missingResult is the result for a record which does not define the
+// variables its templates use, under the error or skip policy.
+func missingResult(policy string, record interface{}, missing []string) map[string]interface{} {
+	if policy == MISSING_VAR_SKIP {
+		return skippedResult(record, "undefined variables: "+strings.Join(missing, ", "))
+	}
+	r := skippedResult(record, "")
+	r["outcome"] = OUTCOME_FAILURE
+	r["error"] = "undefined variables: " + strings.Join(missing, ", ")
+	return r
+}
+
+var mustacheTag = regexp.MustCompile(`\{\{\{\s*(.*?)\s*\}\}\}|\{\{\s*([#^/&!=]?)\s*(.*?)\s*\}\}`)
+
+// undefinedVariables returns the variables used by a template which are
+// not defined when it is expanded for a record.  Sections over lists are
+// checked against their first element, and sections over empty lists are
+// not checked at all.
+func undefinedVariables(src string, record interface{}, meta map[string]interface{}) []string {
+	// A nil entry on the stack marks a section whose contents cannot be
+	// checked.
+	stack := []interface{}{meta, record}
+	undefined := []string{}
+	seen := map[string]bool{}
+	for _, m := range mustacheTag.FindAllStringSubmatch(src, -1) {
+		kind, name := m[2], m[3]
+		if m[1] != "" {
+			kind, name = "&", m[1]
+		}
+		if _, ok := templateHelpers[name]; ok && (kind == "#" || kind == "/") {
+			continue
+		}
+		switch kind {
+		case "!":
+			continue
+		case "=":
+			// Other delimiters are not understood here.
+			return undefined
+		case "/":
+			if len(stack) > 2 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if strings.Contains(name, "|") {
+			// Variables with defaults are always defined.
+			continue
+		}
+		top := stack[len(stack)-1]
+		v, found := lookupVariable(stack, name)
+		if top != nil && !found && !seen[name] {
+			seen[name] = true
+			undefined = append(undefined, name)
+		}
+		switch kind {
+		case "#":
+			switch x := v.(type) {
+			case []interface{}:
+				if len(x) == 0 {
+					top = nil
+				} else {
+					top = x[0]
+				}
+			case map[string]interface{}:
+				top = x
+			}
+			if !found {
+				top = nil
+			}
+			stack = append(stack, top)
+		case "^":
+			stack = append(stack, top)
+		}
+	}
+	return undefined
+}
+
+// lookupVariable finds a dotted name in the innermost context which
+// defines its first part, as mustache does.
+func lookupVariable(stack []interface{}, name string) (interface{}, bool) {
+	if name == "." {
+		return stack[len(stack)-1], true
+	}
+	parts := strings.Split(name, ".")
+	for i := len(stack) - 1; i >= 0; i-- {
+		v, ok := lookupField(stack[i], parts[0])
+		if !ok {
+			continue
+		}
+		for _, p := range parts[1:] {
+			v, ok = lookupField(v, p)
+			if !ok {
+				return nil, false
+			}
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+func lookupField(v interface{}, name string) (interface{}, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		e, ok := x[name]
+		return e, ok
+	case []interface{}:
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= len(x) {
+			return nil, false
+		}
+		return x[i], true
+	}
+	return nil, false
+}
@@ -0,0 +1,52 @@
+package jpar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUndefinedVariables(t *testing.T) {
+	record := map[string]interface{}{
+		"name": "x",
+		"tags": []interface{}{map[string]interface{}{"k": "a"}},
+		"none": []interface{}{},
+		"obj": map[string]interface{}{"a": 1},
+	}
+	meta := map[string]interface{}{"_seq": 0}
+	cases := map[string]string{
+		"{{name}}-{{_seq}}": "",
+		"{{nmae}} {{{nmae}}}": "nmae",
+		"{{#tags}}{{k}} {{name}} {{v}}{{/tags}}": "v",
+		"{{#none}}{{anything}}{{/none}}": "",
+		"{{#missing}}{{anything}}{{/missing}}": "missing",
+		"{{obj.a}} {{obj.b}}": "obj.b",
+		"{{#obj}}{{a}}{{/obj}}{{a}}": "a",
+		"{{#json}}{{name}}{{/json}} {{! comment }}": "",
+		"{{^name}}{{other}}{{/name}}": "other",
+		"{{tags.0.k}} {{tags.1.k}}": "tags.1.k",
+		"{{user|default:nobody}} {{user}}": "user",
+	}
+	for src, want := range cases {
+		got := strings.Join(undefinedVariables(src, record, meta), ",")
+		if got != want {
+			t.Errorf("undefinedVariables(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{name}}", "{{#bad}}"}
+	if _, err := Check(o, nil); err == nil {
+		t.Errorf("expected a parse error")
+	}
+	o.Args = []string{"echo", "{{name}}"}
+	o.Env = []string{"ID={{id}}"}
+	problems, err := Check(o, strings.NewReader(`{"name":"a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0] != "environment variable ID: id is not defined by the sample record" {
+		t.Errorf("unexpected problems %q", problems)
+	}
+}
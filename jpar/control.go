@@ -0,0 +1,374 @@
+package jpar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runControl steers a run while it goes.  Signals and commands sent to
+// the control socket pause it, holding jobs back from the workers while
+// running jobs finish, and resume it.  They also change the number of
+// workers: more are started at once, and workers beyond the new number
+// retire once they are idle.  Commands also cancel jobs by key, and the
+// dashboard kills jobs it lists.
+type runControl struct {
+	gate pauseGate
+	mu sync.Mutex
+	// keyed is set when jobs have keys, so they can be cancelled.
+	keyed bool
+	// running holds the running jobs by sequence number.  cancelled
+	// holds the sequence numbers of the jobs cancelled, and dropped the
+	// keys whose jobs are cancelled before they start.
+	running map[int]*runningJob
+	cancelled map[int]bool
+	dropped map[string]bool
+	// target is the number of workers wanted, and live the number
+	// running, which is above target until enough workers retire.
+	target int
+	live int
+	// start launches a worker with the given id, and nextId is the id
+	// of the next one.  start is nil until the workers are launched.
+	start func(id int)
+	nextId int
+	// shrunk is closed to wake idle workers when target falls.
+	shrunk chan struct{}
+	// stopped is set once shutdown begins, after which the number of
+	// workers no longer changes.
+	stopped bool
+}
+
+func newRunControl(parallelism int) *runControl {
+	return &runControl{
+		target: parallelism,
+		shrunk: make(chan struct{}),
+		running: map[int]*runningJob{},
+		cancelled: map[int]bool{},
+		dropped: map[string]bool{},
+	}
+}
+
+// hold blocks a worker while the run is paused.  It returns false if ctx
+// is cancelled first.
+func (c *runControl) hold(ctx context.Context) bool {
+	if c == nil {
+		return true
+	}
+	return c.gate.wait(ctx)
+}
+
+// launch starts the workers, using start to launch each one.
+func (c *runControl) launch(start func(id int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start = start
+	c.grow()
+}
+
+// grow starts workers until there are as many as wanted.
+func (c *runControl) grow() {
+	if c.start == nil {
+		return
+	}
+	for c.live < c.target {
+		c.start(c.nextId)
+		c.nextId = c.nextId + 1
+		c.live = c.live + 1
+	}
+}
+
+// resize changes the number of workers wanted.
+func (c *runControl) resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("parallelism %d must be at least 1", n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return errors.New("the run is shutting down")
+	}
+	if n < c.target {
+		close(c.shrunk)
+		c.shrunk = make(chan struct{})
+	}
+	c.target = n
+	c.grow()
+	return nil
+}
+
+// adjust changes the number of workers wanted by delta, keeping at least
+// one.
+func (c *runControl) adjust(delta int) (int, error) {
+	c.mu.Lock()
+	n := c.target + delta
+	c.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	return n, c.resize(n)
+}
+
+// shrinking returns a channel which is closed when the number of workers
+// wanted falls.  It is nil, and never closed, without a runControl.
+func (c *runControl) shrinking() chan struct{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shrunk
+}
+
+// retire reports whether an idle worker should stop because there are
+// more workers than wanted, counting it as stopped if so.
+func (c *runControl) retire() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped || c.live <= c.target {
+		return false
+	}
+	c.live = c.live - 1
+	return true
+}
+
+// stop fixes the number of workers for shutdown, returning how many are
+// running.
+func (c *runControl) stop() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	return c.live
+}
+
+// runningJob is a job which a worker is running.
+type runningJob struct {
+	Job Job
+	Started time.Time
+	cancel context.CancelFunc
+}
+
+// track lets a running job be cancelled.  It returns the context to run
+// the job in, and a function to call once the job ends, which reports
+// whether the job was cancelled.
+func (c *runControl) track(ctx context.Context, job Job) (context.Context, func() bool) {
+	if c == nil {
+		return ctx, func() bool { return false }
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running[job.Seq] = &runningJob{Job: job, Started: time.Now(), cancel: cancel}
+	return ctx, func() bool {
+		cancel()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.running, job.Seq)
+		cancelled := c.cancelled[job.Seq]
+		delete(c.cancelled, job.Seq)
+		return cancelled
+	}
+}
+
+// cancel cancels the running jobs with a key, returning how many there
+// were.
+func (c *runControl) cancel(key string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.keyed {
+		return 0, errors.New("jobs have no keys to cancel them by, use --key")
+	}
+	n := 0
+	for seq, j := range c.running {
+		if j.Job.Key == key {
+			c.cancelled[seq] = true
+			j.cancel()
+			n = n + 1
+		}
+	}
+	return n, nil
+}
+
+// kill cancels a running job by its sequence number, reporting whether
+// it was running.
+func (c *runControl) kill(seq int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	j, ok := c.running[seq]
+	if ok {
+		c.cancelled[seq] = true
+		j.cancel()
+	}
+	return ok
+}
+
+// runningJobs lists the running jobs in the order they were read.
+func (c *runControl) runningJobs() []runningJob {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	jobs := []runningJob{}
+	for _, j := range c.running {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Job.Seq < jobs[k].Job.Seq })
+	return jobs
+}
+
+// drop cancels the jobs with a key which have not yet started.
+func (c *runControl) drop(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.keyed {
+		return errors.New("jobs have no keys to cancel them by, use --key")
+	}
+	c.dropped[key] = true
+	return nil
+}
+
+// isDropped reports whether a job was cancelled before it started.
+func (c *runControl) isDropped(job Job) bool {
+	if c == nil || job.Key == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped[job.Key]
+}
+
+// cancelledResult is the result for a record whose job was cancelled
+// before it started.
+func cancelledResult(record interface{}) map[string]interface{} {
+	r := skippedResult(record, "cancelled before it started")
+	r["outcome"] = OUTCOME_CANCELLED
+	return r
+}
+
+// status returns the state of the run as a record.
+func (c *runControl) status() map[string]interface{} {
+	c.mu.Lock()
+	workers := c.target
+	c.mu.Unlock()
+	return map[string]interface{}{
+		"paused": c.gate.paused(),
+		"parallelism": workers,
+	}
+}
+
+// controlCommands are the commands understood on the control socket,
+// each given the rest of its line.
+var controlCommands = map[string]func(c *runControl, arg string) (map[string]interface{}, error){
+	"pause": func(c *runControl, arg string) (map[string]interface{}, error) {
+		c.gate.pause()
+		return c.status(), nil
+	},
+	"resume": func(c *runControl, arg string) (map[string]interface{}, error) {
+		c.gate.resume()
+		return c.status(), nil
+	},
+	"parallelism": func(c *runControl, arg string) (map[string]interface{}, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parallelism %s must be a number", arg)
+		}
+		if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+			_, err = c.adjust(n)
+		} else {
+			err = c.resize(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c.status(), nil
+	},
+	"cancel": func(c *runControl, arg string) (map[string]interface{}, error) {
+		if arg == "" {
+			return nil, errors.New("cancel takes a key")
+		}
+		n, err := c.cancel(arg)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("no running job has the key %s", arg)
+		}
+		return map[string]interface{}{"key": arg, "cancelled": n}, nil
+	},
+	"cancel-pending": func(c *runControl, arg string) (map[string]interface{}, error) {
+		if arg == "" {
+			return nil, errors.New("cancel-pending takes a key")
+		}
+		if err := c.drop(arg); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"key": arg, "dropped": true}, nil
+	},
+	"status": func(c *runControl, arg string) (map[string]interface{}, error) {
+		return c.status(), nil
+	},
+}
+
+// command runs one line sent to the control socket, returning the reply.
+func (c *runControl) command(lg *slog.Logger, line string) map[string]interface{} {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return map[string]interface{}{"error": "no command"}
+	}
+	name, arg, _ := strings.Cut(line, " ")
+	f, ok := controlCommands[name]
+	if !ok {
+		return map[string]interface{}{"error": fmt.Sprintf("unknown command %s", name)}
+	}
+	r, err := f(c, strings.TrimSpace(arg))
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	lg.Debug("control command", "command", line)
+	return r
+}
+
+// listenControl opens the control socket at path, answering commands
+// sent to it until it is closed.  Closing it removes the socket.
+func listenControl(ctx context.Context, lg *slog.Logger, path string, c *runControl) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open control socket: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(ctx, lg, conn)
+		}
+	}()
+	return l, nil
+}
+
+// serve answers each line read from a connection to the control socket
+// with a JSON record.
+func (c *runControl) serve(ctx context.Context, lg *slog.Logger, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		if err := enc.Encode(c.command(lg, scanner.Text())); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package jpar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlCommand(t *testing.T) {
+	c := newRunControl(2)
+	lg := discardLogger
+	if r := c.command(lg, "pause"); r["paused"] != true {
+		t.Errorf("expected pause to pause the run, got %v", r)
+	}
+	if r := c.command(lg, "status"); r["paused"] != true {
+		t.Errorf("expected the run to stay paused, got %v", r)
+	}
+	if r := c.command(lg, "resume"); r["paused"] != false {
+		t.Errorf("expected resume to resume the run, got %v", r)
+	}
+	if r := c.command(lg, "parallelism 4"); r["parallelism"] != 4 {
+		t.Errorf("expected parallelism 4, got %v", r)
+	}
+	if r := c.command(lg, "parallelism -1"); r["parallelism"] != 3 {
+		t.Errorf("expected parallelism 3, got %v", r)
+	}
+	if r := c.command(lg, "parallelism -5"); r["parallelism"] != 1 {
+		t.Errorf("expected parallelism to stay at least 1, got %v", r)
+	}
+	if r := c.command(lg, "parallelism 0"); r["error"] != "parallelism 0 must be at least 1" {
+		t.Errorf("expected parallelism 0 to fail, got %v", r)
+	}
+	if r := c.command(lg, "parallelism many"); r["error"] != "parallelism many must be a number" {
+		t.Errorf("expected a bad parallelism to fail, got %v", r)
+	}
+	if r := c.command(lg, "stop"); r["error"] != "unknown command stop" {
+		t.Errorf("expected an unknown command to fail, got %v", r)
+	}
+	if r := c.command(lg, " "); r["error"] != "no command" {
+		t.Errorf("expected an empty command to fail, got %v", r)
+	}
+}
+
+func TestRunControlResize(t *testing.T) {
+	c := newRunControl(2)
+	started := []int{}
+	c.launch(func(id int) {
+		started = append(started, id)
+	})
+	if err := c.resize(4); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(started, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected workers 0 to 3 to start, got %v", started)
+	}
+	shrunk := c.shrinking()
+	if err := c.resize(1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-shrunk:
+	default:
+		t.Fatal("expected idle workers to be woken")
+	}
+	retired := 0
+	for c.retire() {
+		retired = retired + 1
+	}
+	if retired != 3 {
+		t.Errorf("expected 3 workers to retire, got %d", retired)
+	}
+	if err := c.resize(2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(started, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("expected worker 4 to start, got %v", started)
+	}
+	if n := c.stop(); n != 2 {
+		t.Errorf("expected 2 workers at shutdown, got %d", n)
+	}
+	if err := c.resize(3); err == nil {
+		t.Error("expected no resizing once shutdown begins")
+	}
+}
+
+func TestRunControlCancel(t *testing.T) {
+	c := newRunControl(1)
+	if _, err := c.cancel("a"); err == nil {
+		t.Error("expected cancelling without keys to fail")
+	}
+	c.keyed = true
+	ctx, untrack := c.track(context.Background(), Job{Key: "a", Seq: 3})
+	_, other := c.track(context.Background(), Job{Key: "b", Seq: 4})
+	if n, err := c.cancel("a"); n != 1 || err != nil {
+		t.Fatalf("expected one job to be cancelled, got %d, %v", n, err)
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the job's context to be cancelled")
+	}
+	if !untrack() {
+		t.Error("expected the job to be reported as cancelled")
+	}
+	if other() {
+		t.Error("expected the other job not to be cancelled")
+	}
+	if n, _ := c.cancel("a"); n != 0 {
+		t.Errorf("expected no running job to be left, got %d", n)
+	}
+	if err := c.drop("c"); err != nil {
+		t.Fatal(err)
+	}
+	if !c.isDropped(Job{Key: "c"}) || c.isDropped(Job{Key: "a"}) {
+		t.Error("expected only jobs with key c to be dropped")
+	}
+}
+
+// dialControl connects to a control socket once it has been opened.
+func dialControl(t *testing.T, path string) net.Conn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sendControl sends a command and reads its reply.
+func sendControl(t *testing.T, conn net.Conn, replies *bufio.Scanner, command string) map[string]interface{} {
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if !replies.Scan() {
+		t.Fatalf("no reply to %s: %v", command, replies.Err())
+	}
+	r := map[string]interface{}{}
+	if err := json.Unmarshal(replies.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRunnerControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	o.Control = path
+	input, records := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- NewRunner(o).Run(context.Background(), input, &out)
+	}()
+	conn := dialControl(t, path)
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	if r := sendControl(t, conn, replies, "pause"); r["paused"] != true {
+		t.Fatalf("expected the run to be paused, got %v", r)
+	}
+	io.WriteString(records, `{"n":1}`)
+	records.Close()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the paused run to wait, but it finished with %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if r := sendControl(t, conn, replies, "resume"); r["paused"] != false {
+		t.Fatalf("expected the run to be resumed, got %v", r)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"stdout":"1\n"`) {
+		t.Errorf("expected the job to run once resumed, got %s", out.String())
+	}
+}
+
+func TestRunnerControlParallelism(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	o := NewOptions()
+	o.Args = []string{"sleep", "0.1"}
+	o.Parallelism = 1
+	o.Control = path
+	o.Debug = true
+	input, records := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- NewRunner(o).Run(context.Background(), input, &out)
+	}()
+	conn := dialControl(t, path)
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	sendControl(t, conn, replies, "pause")
+	if r := sendControl(t, conn, replies, "parallelism 3"); r["parallelism"] != float64(3) {
+		t.Fatalf("expected parallelism 3, got %v", r)
+	}
+	// Each worker holds one job while the run is paused.
+	io.WriteString(records, "{}\n{}\n{}\n")
+	time.Sleep(200 * time.Millisecond)
+	sendControl(t, conn, replies, "resume")
+	records.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	workers := map[interface{}]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		r := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		workers[r["worker-id"]] = true
+	}
+	if len(workers) != 3 {
+		t.Errorf("expected the jobs to run on 3 workers, got %s", out.String())
+	}
+}
+
+func TestRunnerControlCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	o := NewOptions()
+	o.Args = []string{"sleep", "{{t}}"}
+	o.Key = "{{id}}"
+	o.Control = path
+	input, records := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- NewRunner(o).Run(context.Background(), input, &out)
+	}()
+	conn := dialControl(t, path)
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	if r := sendControl(t, conn, replies, "cancel-pending b"); r["dropped"] != true {
+		t.Fatalf("expected b to be dropped, got %v", r)
+	}
+	io.WriteString(records, `{"id":"a","t":30}`+"\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r := sendControl(t, conn, replies, "cancel a")
+		if r["cancelled"] == float64(1) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a to be cancelled, got %v", r)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	io.WriteString(records, `{"id":"b","t":30}`+"\n")
+	records.Close()
+	if _, ok := (<-done).(*ExitError); !ok {
+		t.Error("expected cancelled jobs to fail the run")
+	}
+	outcomes := map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		r := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		outcomes[r["key"].(string)] = r["outcome"]
+	}
+	want := map[string]interface{}{"a": OUTCOME_CANCELLED, "b": OUTCOME_CANCELLED}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("expected both jobs to be cancelled, got %s", out.String())
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyControl pauses the run on SIGUSR1 and resumes it on SIGUSR2.  As
+// with GNU parallel, SIGTTIN adds a worker and SIGTTOU retires one.  It
+// returns a function which stops listening for them.
+func notifyControl(lg *slog.Logger, c *runControl) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTTIN, syscall.SIGTTOU)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				switch sig {
+				case syscall.SIGUSR1:
+					c.gate.pause()
+					lg.Info("paused", "signal", signalName(syscall.SIGUSR1))
+				case syscall.SIGUSR2:
+					c.gate.resume()
+					lg.Info("resumed", "signal", signalName(syscall.SIGUSR2))
+				case syscall.SIGTTIN, syscall.SIGTTOU:
+					delta := 1
+					if sig == syscall.SIGTTOU {
+						delta = -1
+					}
+					n, err := c.adjust(delta)
+					if err != nil {
+						lg.Warn("cannot change parallelism", "error", err.Error())
+						continue
+					}
+					lg.Info("parallelism changed", "parallelism", n, "signal", signalName(sig.(syscall.Signal)))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitPaused waits for the run to be paused or resumed by a signal.
+func waitPaused(c *runControl, paused bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.gate.paused() == paused {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// waitParallelism waits for a signal to change the number of workers.
+func waitParallelism(c *runControl, n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.status()["parallelism"] == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNotifyControl(t *testing.T) {
+	c := newRunControl(1)
+	stop := notifyControl(discardLogger, c)
+	defer stop()
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	if !waitPaused(c, true) {
+		t.Fatal("expected SIGUSR1 to pause the run")
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	if !waitPaused(c, false) {
+		t.Fatal("expected SIGUSR2 to resume the run")
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGTTIN)
+	if !waitParallelism(c, 2) {
+		t.Fatal("expected SIGTTIN to add a worker")
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGTTOU)
+	if !waitParallelism(c, 1) {
+		t.Fatal("expected SIGTTOU to retire a worker")
+	}
+}
@@ -0,0 +1,11 @@
+package jpar
+
+import (
+	"log/slog"
+)
+
+// notifyControl does nothing, as Windows has no SIGUSR1, SIGUSR2, SIGTTIN,
+// or SIGTTOU.  The control socket still steers the run.
+func notifyControl(lg *slog.Logger, c *runControl) func() {
+	return func() {}
+}
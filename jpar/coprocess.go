@@ -0,0 +1,208 @@
+package jpar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// coprocess is a long-running command which reads one JSON record per
+// line on stdin and answers each with one line on stdout.
+type coprocess struct {
+	c *exec.Cmd
+	args []string
+	stdin io.WriteCloser
+	// lines carries the replies.  It is closed when stdout ends.
+	lines chan string
+	// exited is closed once the command has been waited for.
+	exited chan struct{}
+	dead bool
+}
+
+// coprocessWorker runs a worker's jobs on its coprocess, starting a new
+// coprocess whenever the previous one has died.
+type coprocessWorker struct {
+	o *Options
+	cmd *CommandTemplate
+	cp *coprocess
+}
+
+func startCoprocess(o *Options, cmd *CommandTemplate, meta map[string]interface{}) (*coprocess, error) {
+	// There is no record to expand the command with, only the metadata.
+	none := map[string]interface{}{}
+	args := renderCommand(o, cmd, none, meta)
+	prog, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot locate command %s: %s", args[0], err)
+	}
+	c := exec.Command(prog)
+	c.Args = args
+	c.SysProcAttr, err = runAs(processGroup(o), o.User, o.Group)
+	if err != nil {
+		return nil, err
+	}
+	c.Stderr = os.Stderr
+	env, err := renderEnv(o, cmd, none, meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+	if cmd.Cwd != nil {
+		c.Dir = render(cmd.Cwd, none, meta)
+	}
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct stdin: %s", err)
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct stdout: %s", err)
+	}
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("failed to launch coprocess: %s", err)
+	}
+	if err := setPriority(o, c.Process.Pid); err != nil {
+		logger(o).Warn("cannot lower priority", "error", err.Error())
+	}
+	cp := &coprocess{
+		c: c,
+		args: args,
+		stdin: stdin,
+		lines: make(chan string, 1),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		rdr := bufio.NewReader(stdout)
+		for {
+			line, err := rdr.ReadString('\n')
+			if err != nil {
+				break
+			}
+			cp.lines <- line[:len(line)-1]
+		}
+		close(cp.lines)
+		c.Wait()
+		close(cp.exited)
+	}()
+	return cp, nil
+}
+
+// run sends a record to the coprocess and waits for its reply.
+func (cp *coprocess) run(ctx context.Context, o *Options, job interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	if o.Timings {
+		defer recordTimings(r, time.Now())
+	}
+	r["e"] = job
+	r["command"] = cp.args
+	r["returncode"] = RETURNCODE_FAILURE
+	r["stdout"] = ""
+	r["stderr"] = ""
+	r["outcome"] = OUTCOME_FAILURE
+	if ctx.Err() != nil {
+		r["error"] = "cancelled"
+		return r
+	}
+	timeout, err := jobTimeout(o, job)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	b, err := json.Marshal(job)
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot encode record: %s", err)
+		return r
+	}
+	start := time.Now()
+	if _, err := cp.stdin.Write(append(b, '\n')); err != nil {
+		cp.dead = true
+		r["error"] = fmt.Sprintf("cannot write to coprocess: %s", err)
+		return r
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case line, ok := <-cp.lines:
+		r["duration_ms"] = time.Since(start).Milliseconds()
+		if !ok {
+			cp.dead = true
+			<-cp.exited
+			recordExit(o, r, cp.c.ProcessState.Sys().(syscall.WaitStatus))
+			r["error"] = "coprocess exited"
+			r["outcome"] = OUTCOME_FAILURE
+			return r
+		}
+		r["stdout"] = line
+		r["returncode"] = uint32(0)
+		r["outcome"] = OUTCOME_SUCCESS
+	case <-expired:
+		// A late reply would be taken for the next record's.
+		cp.dead = true
+		signalProcess(o, cp.c.Process, syscall.SIGKILL)
+		r["duration_ms"] = time.Since(start).Milliseconds()
+		r["error"] = fmt.Sprintf("killed after timeout of %s", timeout)
+		r["outcome"] = OUTCOME_TIMEOUT
+	case <-ctx.Done():
+		cp.dead = true
+		r["error"] = "cancelled"
+	}
+	return r
+}
+
+// stop closes the coprocess's stdin, which asks it to exit.  It is sent
+// SIGTERM on shutdown, and killed if it has not exited after the grace
+// period.
+func (cp *coprocess) stop(ctx context.Context, o *Options) {
+	// Discard unread replies so that the reader can finish.
+	go func() {
+		for _ = range cp.lines {
+		}
+	}()
+	cp.stdin.Close()
+	if ctx.Err() != nil {
+		signalProcess(o, cp.c.Process, syscall.SIGTERM)
+	}
+	select {
+	case <-cp.exited:
+		return
+	case <-time.After(o.GracePeriod):
+	}
+	signalProcess(o, cp.c.Process, syscall.SIGKILL)
+	<-cp.exited
+}
+
+func (w *coprocessWorker) run(ctx context.Context, job interface{}, meta map[string]interface{}) map[string]interface{} {
+	if w.cp != nil && w.cp.dead {
+		w.cp.stop(ctx, w.o)
+		w.cp = nil
+	}
+	if w.cp == nil {
+		cp, err := startCoprocess(w.o, w.cmd, meta)
+		if err != nil {
+			r := skippedResult(job, "")
+			r["outcome"] = OUTCOME_FAILURE
+			r["error"] = err.Error()
+			return r
+		}
+		w.cp = cp
+	}
+	return w.cp.run(ctx, w.o, job)
+}
+
+func (w *coprocessWorker) stop(ctx context.Context) {
+	if w.cp != nil {
+		w.cp.stop(ctx, w.o)
+	}
+}
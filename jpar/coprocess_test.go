@@ -0,0 +1,41 @@
+package jpar
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoprocessWorker(t *testing.T) {
+	o := &Options{GracePeriod: time.Second}
+	// Replies with each record, and exits after the second.
+	cmd := parseCmd(t, "sh", "-c", "read a; echo \"got $a\"; read b; echo \"got $b\"")
+	w := &coprocessWorker{o: o, cmd: cmd}
+	defer w.stop(context.Background())
+	ctx := context.Background()
+	for i, n := range []float64{1, 2} {
+		r := w.run(ctx, map[string]interface{}{"n": n}, nil)
+		if r["outcome"] != OUTCOME_SUCCESS {
+			t.Fatalf("record %d: unexpected result %v", i, r)
+		}
+	}
+	r := w.run(ctx, map[string]interface{}{"n": 3.0}, nil)
+	if r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected the coprocess to have exited, got %v", r)
+	}
+	// The next record starts a new coprocess.
+	r = w.run(ctx, map[string]interface{}{"n": 4.0}, nil)
+	if r["stdout"] != `got {"n":4}` {
+		t.Errorf("unexpected result %v", r)
+	}
+}
+
+func TestCoprocessTimeout(t *testing.T) {
+	o := &Options{Timeout: 100 * time.Millisecond, GracePeriod: time.Second}
+	w := &coprocessWorker{o: o, cmd: parseCmd(t, "sleep", "5")}
+	defer w.stop(context.Background())
+	r := w.run(context.Background(), "x", nil)
+	if r["outcome"] != OUTCOME_TIMEOUT {
+		t.Errorf("expected a timeout, got %v", r)
+	}
+}
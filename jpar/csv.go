@@ -0,0 +1,176 @@
+package jpar
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const QUOTING_STANDARD string = "standard"
+const QUOTING_LAZY string = "lazy"
+const QUOTING_NONE string = "none"
+
+// ReadCsv turns each row of delimited text into a record mapping column
+// names to values.  Column names come from the header row, or are col1,
+// col2, ... when there is no header.  Malformed rows produce errors and
+// reading continues with the next row.
+func ReadCsv(stream io.Reader, delimiter rune, header bool, quoting string) chan JsonRead {
+	rows := readCsvRows(stream, delimiter, quoting)
+	out := make(chan JsonRead)
+	go func() {
+		defer close(out)
+		var names []string
+		for row := range rows {
+			if row.err != nil {
+				out <- JsonRead{Err: row.err, Line: row.line}
+				continue
+			}
+			if header && names == nil {
+				names = row.fields
+				continue
+			}
+			if header && len(row.fields) > len(names) {
+				err := fmt.Errorf("row has %d fields but the header has %d", len(row.fields), len(names))
+				out <- JsonRead{Err: err, Line: row.line}
+				continue
+			}
+			r := map[string]interface{}{}
+			for i, f := range row.fields {
+				if header {
+					r[names[i]] = f
+				} else {
+					r[fmt.Sprintf("col%d", i+1)] = f
+				}
+			}
+			out <- JsonRead{Value: r, Line: row.line}
+		}
+	}()
+	return out
+}
+
+type csvRow struct {
+	fields []string
+	line int
+	err error
+}
+
+func readCsvRows(stream io.Reader, delimiter rune, quoting string) chan csvRow {
+	out := make(chan csvRow)
+	if quoting == QUOTING_NONE {
+		go readUnquotedRows(stream, delimiter, out)
+		return out
+	}
+	rdr := csv.NewReader(stream)
+	rdr.Comma = delimiter
+	rdr.FieldsPerRecord = -1
+	rdr.LazyQuotes = quoting == QUOTING_LAZY
+	go func() {
+		defer close(out)
+		for {
+			fields, err := rdr.Read()
+			if err == io.EOF {
+				return
+			}
+			line, _ := rdr.FieldPos(0)
+			if perr, ok := err.(*csv.ParseError); ok {
+				line = perr.Line
+			} else if err != nil {
+				out <- csvRow{err: err, line: line}
+				return
+			}
+			out <- csvRow{fields: fields, line: line, err: err}
+		}
+	}()
+	return out
+}
+
+// readUnquotedRows splits lines on the delimiter without treating
+// quotes specially, which is how most TSV files are written.
+func readUnquotedRows(stream io.Reader, delimiter rune, out chan csvRow) {
+	defer close(out)
+	rdr := bufio.NewReader(stream)
+	line := 0
+	for {
+		text, err := rdr.ReadString('\n')
+		if len(text) > 0 {
+			line = line + 1
+			text = strings.TrimRight(text, "\r\n")
+			if text != "" {
+				out <- csvRow{fields: strings.Split(text, string(delimiter)), line: line}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				out <- csvRow{err: err, line: line}
+			}
+			return
+		}
+	}
+}
+
+// DEFAULT_CSV_COLUMNS are the result fields written as CSV when
+// --output-fields is not given.
+var DEFAULT_CSV_COLUMNS = []string{"command", "outcome", "returncode", "duration_ms", "error", "stdout", "stderr"}
+
+// csvOutput is an output which results are written to as rows of CSV,
+// under a header naming their columns.  Strings are written as they are,
+// missing fields as empty cells, and other values as JSON.
+type csvOutput struct {
+	io.Writer
+	csv *csv.Writer
+	columns []string
+}
+
+// newCsvOutput writes the header at once, so that a run with no results
+// still produces a valid file.
+func newCsvOutput(w io.Writer, columns []string) *csvOutput {
+	c := &csvOutput{Writer: w, csv: csv.NewWriter(w), columns: columns}
+	c.csv.Write(columns)
+	c.csv.Flush()
+	return c
+}
+
+func (c *csvOutput) writeRow(v interface{}) {
+	row := []string{}
+	for _, col := range c.columns {
+		x, _ := lookupField(v, col)
+		switch s := x.(type) {
+		case string:
+			row = append(row, s)
+		case nil:
+			row = append(row, "")
+		default:
+			b, _ := json.Marshal(s)
+			row = append(row, string(b))
+		}
+	}
+	c.csv.Write(row)
+	c.csv.Flush()
+}
+
+// resultWriter returns where results are written in the output format.
+// CSV output keeps its columns, under their new names when they are
+// renamed, and TAP output counts its test points.
+func resultWriter(o *Options, renames map[string]string, w io.Writer) io.Writer {
+	if o.OutputFormat == OUTPUT_FORMAT_TAP {
+		return newTapOutput(w)
+	}
+	if o.OutputFormat != OUTPUT_FORMAT_CSV {
+		return w
+	}
+	fields := o.OutputFields
+	if len(fields) == 0 {
+		fields = DEFAULT_CSV_COLUMNS
+	}
+	columns := []string{}
+	for _, f := range fields {
+		if name, ok := renames[f]; ok {
+			f = name
+		}
+		columns = append(columns, f)
+	}
+	return newCsvOutput(w, columns)
+}
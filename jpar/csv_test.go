@@ -0,0 +1,44 @@
+package jpar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCsvOutput(t *testing.T) {
+	tests := []struct {
+		fields []string
+		renames map[string]string
+		results []interface{}
+		want string
+	}{
+		{
+			nil, nil,
+			[]interface{}{map[string]interface{}{"command": []string{"echo", "a,b"}, "outcome": "SUCCESS", "returncode": uint32(0), "duration_ms": 3, "stdout": "a,b\n", "stderr": ""}},
+			"command,outcome,returncode,duration_ms,error,stdout,stderr\n" +
+				`"[""echo"",""a,b""]",SUCCESS,0,3,,"a,b` + "\n" + `",` + "\n",
+		},
+		{
+			[]string{"e", "outcome"}, map[string]string{"e": "input"},
+			[]interface{}{map[string]interface{}{"input": map[string]interface{}{"id": 1}, "outcome": "FAILURE"}, "x"},
+			"input,outcome\n" + `"{""id"":1}",FAILURE` + "\n" + ",\n",
+		},
+		{
+			[]string{"outcome"}, nil, nil,
+			"outcome\n",
+		},
+	}
+	for _, tc := range tests {
+		o := NewOptions()
+		o.OutputFormat = OUTPUT_FORMAT_CSV
+		o.OutputFields = tc.fields
+		var b bytes.Buffer
+		w := resultWriter(o, tc.renames, &b)
+		for _, r := range tc.results {
+			writeResult(w, o.OutputFormat, r)
+		}
+		if b.String() != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.fields, tc.want, b.String())
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package jpar
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const DAG_WAITING = 0
+const DAG_RUNNING = 1
+const DAG_SUCCEEDED = 2
+const DAG_FAILED = 3
+
+var errDuplicateId = errors.New("duplicate id")
+
+// dependencyGraph holds jobs until every job they depend on has
+// succeeded.  Jobs are identified by the id field of their records, and
+// list the ids they depend on in their depends_on field.  Dependencies
+// may appear later in the input than the jobs which need them.
+type dependencyGraph struct {
+	mu sync.Mutex
+	// state records the progress of every id which has been seen.
+	state map[string]int
+	// waiting holds jobs by sequence number, along with the ids they are
+	// still waiting for.
+	waiting map[int]*dagNode
+	// dependents lists the waiting jobs which need an id.
+	dependents map[string][]int
+}
+
+type dagNode struct {
+	job Job
+	remaining map[string]bool
+}
+
+// dagFailure is a waiting job which can no longer run.
+type dagFailure struct {
+	Job Job
+	Error string
+}
+
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{
+		state: map[string]int{},
+		waiting: map[int]*dagNode{},
+		dependents: map[string][]int{},
+	}
+}
+
+// recordId returns the id of a record, or "" when it has none.
+func recordId(o *Options, v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok || m[o.IdField] == nil {
+		return ""
+	}
+	return fmt.Sprint(m[o.IdField])
+}
+
+// recordDependencies returns the ids a record depends on, which are given
+// as a list or as a single id.
+func recordDependencies(o *Options, v interface{}) []string {
+	m, ok := v.(map[string]interface{})
+	if !ok || m[o.DependsOnField] == nil {
+		return nil
+	}
+	deps := []string{}
+	switch x := m[o.DependsOnField].(type) {
+	case []interface{}:
+		for _, d := range x {
+			deps = append(deps, fmt.Sprint(d))
+		}
+	default:
+		deps = append(deps, fmt.Sprint(x))
+	}
+	return deps
+}
+
+// add reports whether a job may run now.  Otherwise it waits until its
+// dependencies succeed, or it returns an error when the job cannot run
+// because its id is repeated or a dependency has already failed.  Unless
+// the id was repeated, the job must then be completed as failed.
+func (g *dependencyGraph) add(job Job) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if job.Id != "" {
+		if _, ok := g.state[job.Id]; ok {
+			return false, fmt.Errorf("%w %s", errDuplicateId, job.Id)
+		}
+	}
+	remaining := map[string]bool{}
+	for _, d := range job.DependsOn {
+		switch g.state[d] {
+		case DAG_SUCCEEDED:
+		case DAG_FAILED:
+			return false, fmt.Errorf("dependency %s failed", d)
+		default:
+			remaining[d] = true
+		}
+	}
+	if len(remaining) == 0 {
+		g.run(job.Id)
+		return true, nil
+	}
+	g.waiting[job.Seq] = &dagNode{job: job, remaining: remaining}
+	for d := range remaining {
+		g.dependents[d] = append(g.dependents[d], job.Seq)
+	}
+	if job.Id != "" {
+		g.state[job.Id] = DAG_WAITING
+	}
+	return false, nil
+}
+
+// complete records that the job with an id has finished, and returns the
+// waiting jobs which may now run and those which never will.
+func (g *dependencyGraph) complete(id string, succeeded bool) ([]Job, []dagFailure) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if succeeded {
+		g.state[id] = DAG_SUCCEEDED
+	} else {
+		g.state[id] = DAG_FAILED
+	}
+	ready := []Job{}
+	failed := []dagFailure{}
+	for _, seq := range g.dependents[id] {
+		n, ok := g.waiting[seq]
+		if !ok {
+			continue
+		}
+		if !succeeded {
+			delete(g.waiting, seq)
+			g.fail(n.job.Id)
+			failed = append(failed, dagFailure{Job: n.job, Error: fmt.Sprintf("dependency %s failed", id)})
+			continue
+		}
+		delete(n.remaining, id)
+		if len(n.remaining) == 0 {
+			delete(g.waiting, seq)
+			g.run(n.job.Id)
+			ready = append(ready, n.job)
+		}
+	}
+	delete(g.dependents, id)
+	return ready, failed
+}
+
+// close is called at the end of the input.  It returns the waiting jobs
+// which can never run, because they depend on ids which were never seen
+// or on each other.
+func (g *dependencyGraph) close() []dagFailure {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// A waiting job can still run if each of its dependencies is running
+	// or is a waiting job which can still run.
+	live := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range g.waiting {
+			if n.job.Id == "" || live[n.job.Id] {
+				continue
+			}
+			if g.canRun(n, live) {
+				live[n.job.Id] = true
+				changed = true
+			}
+		}
+	}
+	byId := map[string]*dagNode{}
+	seqs := []int{}
+	for seq, n := range g.waiting {
+		if n.job.Id != "" {
+			byId[n.job.Id] = n
+		}
+		if (n.job.Id == "" && !g.canRun(n, live)) || (n.job.Id != "" && !live[n.job.Id]) {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	failed := []dagFailure{}
+	for _, seq := range seqs {
+		n := g.waiting[seq]
+		failed = append(failed, dagFailure{Job: n.job, Error: stuckReason(n, byId, g.state)})
+	}
+	for _, f := range failed {
+		delete(g.waiting, f.Job.Seq)
+		g.fail(f.Job.Id)
+	}
+	return failed
+}
+
+// stuckReason explains why a waiting job can never run.
+func stuckReason(n *dagNode, byId map[string]*dagNode, state map[string]int) string {
+	deps := []string{}
+	for d := range n.remaining {
+		deps = append(deps, d)
+	}
+	sort.Strings(deps)
+	for _, d := range deps {
+		if _, ok := state[d]; !ok {
+			return fmt.Sprintf("unknown dependency %s", d)
+		}
+	}
+	if n.job.Id != "" && reaches(byId, deps, n.job.Id, map[string]bool{}) {
+		return "dependency cycle"
+	}
+	for _, d := range deps {
+		if state[d] != DAG_RUNNING {
+			return fmt.Sprintf("dependency %s cannot run", d)
+		}
+	}
+	return "dependency cycle"
+}
+
+// reaches reports whether id can be reached by following the remaining
+// dependencies of waiting jobs.
+func reaches(byId map[string]*dagNode, deps []string, id string, visited map[string]bool) bool {
+	for _, d := range deps {
+		if d == id {
+			return true
+		}
+		if visited[d] || byId[d] == nil {
+			continue
+		}
+		visited[d] = true
+		next := []string{}
+		for x := range byId[d].remaining {
+			next = append(next, x)
+		}
+		if reaches(byId, next, id, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *dependencyGraph) canRun(n *dagNode, live map[string]bool) bool {
+	for d := range n.remaining {
+		if g.state[d] != DAG_RUNNING && g.state[d] != DAG_SUCCEEDED && !live[d] {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *dependencyGraph) run(id string) {
+	if id != "" {
+		g.state[id] = DAG_RUNNING
+	}
+}
+
+func (g *dependencyGraph) fail(id string) {
+	if id != "" {
+		g.state[id] = DAG_FAILED
+	}
+}
+
+// dependencyFailure is the result of a job which could not run because
+// of its dependencies.
+func dependencyFailure(f dagFailure) map[string]interface{} {
+	r := skippedResult(f.Job.Value, "")
+	r["outcome"] = OUTCOME_FAILURE
+	r["error"] = f.Error
+	if f.Job.Key != "" {
+		r["key"] = f.Job.Key
+	}
+	return r
+}
@@ -0,0 +1,93 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	g := newDependencyGraph()
+	if ready, err := g.add(Job{Seq: 0, Id: "b", DependsOn: []string{"a"}}); ready || err != nil {
+		t.Fatalf("b should wait for a, got %v, %v", ready, err)
+	}
+	if ready, err := g.add(Job{Seq: 1, Id: "a"}); !ready || err != nil {
+		t.Fatalf("a should run, got %v, %v", ready, err)
+	}
+	if _, err := g.add(Job{Seq: 2, Id: "a"}); err == nil {
+		t.Fatal("a repeated id should be an error")
+	}
+	if ready, err := g.add(Job{Seq: 3, Id: "c", DependsOn: []string{"b"}}); ready || err != nil {
+		t.Fatalf("c should wait for b, got %v, %v", ready, err)
+	}
+	ready, failed := g.complete("a", true)
+	if len(ready) != 1 || ready[0].Id != "b" || len(failed) != 0 {
+		t.Fatalf("completing a should start b, got %v, %v", ready, failed)
+	}
+	ready, failed = g.complete("b", false)
+	if len(ready) != 0 || len(failed) != 1 || failed[0].Job.Id != "c" {
+		t.Fatalf("failing b should fail c, got %v, %v", ready, failed)
+	}
+	if _, err := g.add(Job{Seq: 4, Id: "d", DependsOn: []string{"b"}}); err == nil {
+		t.Fatal("d depends on a failed job and should fail")
+	}
+}
+
+func TestDependencyGraphClose(t *testing.T) {
+	g := newDependencyGraph()
+	g.add(Job{Seq: 0, Id: "a", DependsOn: []string{"b"}})
+	g.add(Job{Seq: 1, Id: "b", DependsOn: []string{"a"}})
+	g.add(Job{Seq: 2, Id: "c", DependsOn: []string{"x"}})
+	g.add(Job{Seq: 3, Id: "d", DependsOn: []string{"c"}})
+	g.add(Job{Seq: 4, Id: "e"})
+	g.add(Job{Seq: 5, Id: "f", DependsOn: []string{"e"}})
+	want := []string{"dependency cycle", "dependency cycle", "unknown dependency x", "dependency c cannot run"}
+	failed := g.close()
+	if len(failed) != len(want) {
+		t.Fatalf("expected %d failures, got %v", len(want), failed)
+	}
+	for i, f := range failed {
+		if f.Error != want[i] {
+			t.Errorf("job %d: got %q, want %q", f.Job.Seq, f.Error, want[i])
+		}
+	}
+}
+
+func TestRunnerDag(t *testing.T) {
+	o := NewOptions()
+	o.Dag = true
+	o.KeepOrder = true
+	o.ExitStatus = EXIT_STATUS_NEVER
+	o.Args = []string{"sh", "-c", "exit {{rc}}"}
+	input := `{"id":"deploy","depends_on":["build","test"],"rc":0}
+{"id":"build","rc":0}
+{"id":"test","depends_on":"build","rc":1}
+{"id":"notify","depends_on":"deploy","rc":0}
+{"id":"docs","depends_on":"build","rc":0}`
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		outcome string
+		error interface{}
+	}{
+		{OUTCOME_FAILURE, "dependency test failed"},
+		{OUTCOME_SUCCESS, nil},
+		{OUTCOME_FAILURE, "exited with status 1"},
+		{OUTCOME_FAILURE, "dependency deploy failed"},
+		{OUTCOME_SUCCESS, nil},
+	}
+	dec := json.NewDecoder(&out)
+	for _, w := range want {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r["outcome"] != w.outcome || r["error"] != w.error {
+			t.Errorf("%v: got %v (%v), want %s (%v)", r["e"], r["outcome"], r["error"], w.outcome, w.error)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jpar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Templates written with other delimiters, such as <% name %>, are
+// translated to the usual ones before they are parsed.  Braces in the
+// text between tags are replaced with brace markers, so mustache copies
+// them to its output and they are restored when the markers are
+// resolved.
+
+// withDelimiters returns the options with every template translated from
+// the delimiters given with --left-delim and --right-delim.
+func withDelimiters(o *Options) (*Options, error) {
+	left, right := o.LeftDelim, o.RightDelim
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	if left == "{{" && right == "}}" {
+		return o, nil
+	}
+	for _, d := range []string{left, right} {
+		if strings.ContainsAny(d, "= \t\n") {
+			return nil, fmt.Errorf("delimiter %q cannot contain spaces or =", d)
+		}
+	}
+	var err error
+	translate := func(src string) string {
+		t, e := translateDelimiters(src, left, right)
+		if e != nil && err == nil {
+			err = e
+		}
+		return t
+	}
+	translateAll := func(srcs []string) []string {
+		r := []string{}
+		for _, src := range srcs {
+			r = append(r, translate(src))
+		}
+		return r
+	}
+	t := *o
+	if o.Replace == "" {
+		t.Args = translateAll(o.Args)
+		t.Then = translateAll(o.Then)
+	}
+	t.Env = translateAll(o.Env)
+	t.Headers = translateAll(o.Headers)
+	t.K8sLabels = translateAll(o.K8sLabels)
+	t.Tags = translateAll(o.Tags)
+	t.DockerVolumes = translateAll(o.DockerVolumes)
+	for _, f := range []*string{&t.Cwd, &t.StdinFile, &t.StdoutFile, &t.StderrFile, &t.Key, &t.DedupeKey, &t.GroupBy,
+		&t.DockerImage, &t.DockerNetwork, &t.K8sImage, &t.K8sNamespace, &t.K8sCpu, &t.K8sMemory, &t.Body, &t.RenderTo} {
+		*f = translate(*f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// translateDelimiters rewrites a template which uses the delimiters left
+// and right so that it uses {{ and }}.
+func translateDelimiters(src string, left string, right string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(src, left)
+		if i < 0 {
+			b.WriteString(escapeBraces(src))
+			return b.String(), nil
+		}
+		b.WriteString(escapeBraces(src[:i]))
+		src = src[i+len(left):]
+		j := strings.Index(src, right)
+		if j < 0 {
+			return "", fmt.Errorf("unclosed tag %s%s", left, src)
+		}
+		tag := src[:j]
+		if strings.HasPrefix(strings.TrimSpace(tag), "=") {
+			return "", errors.New("delimiters cannot be changed within a template")
+		}
+		b.WriteString("{{" + tag + "}}")
+		src = src[j+len(right):]
+	}
+}
+
+// escapeBraces replaces the braces in template text with markers.
+func escapeBraces(s string) string {
+	s = strings.Replace(s, "{", MARK_OPEN_BRACE, -1)
+	return strings.Replace(s, "}", MARK_CLOSE_BRACE, -1)
+}
@@ -0,0 +1,55 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestTranslateDelimiters(t *testing.T) {
+	job := map[string]interface{}{"id": "a", "n": map[string]interface{}{"k": 1.0}}
+	cases := map[string]string{
+		"<% id %>": "a",
+		"{{id}} <%id%>": "{{id}} a",
+		"{<%id%>}": "{a}",
+		"{{{<%{ id }%>}}}": "{{{a}}}",
+		"<%#json%><%id%> <%n%><%/json%>": `"a" {"k":1}`,
+		"<%missing|default:x%>": "x",
+		"<%#n%><%k%><%/n%>": "1",
+	}
+	for src, want := range cases {
+		translated, err := translateDelimiters(src, "<%", "%>")
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if got := render(parseTemplate(t, translated), job, nil); got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+	for _, src := range []string{"<% id", "<%=[ ]=%>"} {
+		if _, err := translateDelimiters(src, "<%", "%>"); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestWithDelimiters(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{x}}", "<%id%>"}
+	if same, _ := withDelimiters(o); same != o {
+		t.Error("expected the options to be unchanged with the usual delimiters")
+	}
+	o.LeftDelim = "<%"
+	o.RightDelim = "%>"
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := renderCommand(o, cmd, map[string]interface{}{"id": "a"}, nil)
+	if len(got) != 3 || got[1] != "{{x}}" || got[2] != "a" {
+		t.Errorf("got %q", got)
+	}
+	o.RightDelim = "= %>"
+	if _, err := parseCommandTemplate(o); err == nil {
+		t.Error("expected an error for a delimiter with spaces")
+	}
+}
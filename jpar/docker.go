@@ -0,0 +1,115 @@
+package jpar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DOCKER_CONTROL_TIMEOUT bounds the docker commands used to inspect,
+// kill, and remove job containers.
+const DOCKER_CONTROL_TIMEOUT = 30 * time.Second
+
+// dockerRun runs a job's command inside a container with `docker run`.
+// The container is kept after it exits so that its state can be
+// inspected, and removed afterwards.
+type dockerRun struct {
+	docker string
+	cidFile string
+	options []string
+}
+
+func newDockerRun(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) (*dockerRun, error) {
+	f, err := os.CreateTemp("", "jpar-cid-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create container id file: %s", err)
+	}
+	f.Close()
+	// docker refuses to overwrite an existing cid file.
+	os.Remove(f.Name())
+	d := &dockerRun{docker: o.DockerPath, cidFile: f.Name()}
+	d.options = []string{"run", "--cidfile", d.cidFile}
+	if sendsStdin(o) {
+		d.options = append(d.options, "-i")
+	}
+	if cmd.Cwd != nil {
+		d.options = append(d.options, "-w", render(cmd.Cwd, job, meta))
+	}
+	if cmd.DockerNetwork != nil {
+		d.options = append(d.options, "--network", render(cmd.DockerNetwork, job, meta))
+	}
+	for _, v := range cmd.DockerVolumes {
+		d.options = append(d.options, "-v", render(v, job, meta))
+	}
+	// Variables are passed by name so that their values are taken from
+	// the environment of the docker client rather than its arguments.
+	env, err := renderEnv(o, cmd, job, meta)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range env {
+		d.options = append(d.options, "-e", strings.SplitN(e, "=", 2)[0])
+	}
+	for _, name := range o.DockerEnv {
+		d.options = append(d.options, "-e", name)
+	}
+	d.options = append(d.options, render(cmd.DockerImage, job, meta))
+	return d, nil
+}
+
+// wrap returns the docker command which runs args in the container.
+func (d *dockerRun) wrap(args []string) []string {
+	w := append([]string{d.docker}, d.options...)
+	return append(w, args...)
+}
+
+func (d *dockerRun) containerId() string {
+	b, err := os.ReadFile(d.cidFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// kill sends a signal to the container.  Killing the docker client
+// alone would leave the container running.
+func (d *dockerRun) kill(sig syscall.Signal) error {
+	id := d.containerId()
+	if id == "" {
+		return os.ErrProcessDone
+	}
+	_, err := d.control("kill", "--signal", fmt.Sprintf("%d", int(sig)), id)
+	return err
+}
+
+// finish records the container's id and whether it was killed for
+// running out of memory.
+func (d *dockerRun) finish(r map[string]interface{}) {
+	id := d.containerId()
+	if id == "" {
+		return
+	}
+	r["container_id"] = id
+	out, err := d.control("inspect", "--format", "{{.State.OOMKilled}}", id)
+	if err == nil {
+		r["oom_killed"] = strings.TrimSpace(string(out)) == "true"
+	}
+}
+
+// cleanup removes the container and the container id file.
+func (d *dockerRun) cleanup() {
+	if id := d.containerId(); id != "" {
+		d.control("rm", "-f", id)
+	}
+	os.Remove(d.cidFile)
+}
+
+func (d *dockerRun) control(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DOCKER_CONTROL_TIMEOUT)
+	defer cancel()
+	return exec.CommandContext(ctx, d.docker, args...).Output()
+}
@@ -0,0 +1,39 @@
+package jpar
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDockerRunWrap(t *testing.T) {
+	o := NewOptions()
+	o.Env = []string{"NAME={{name}}"}
+	o.DockerEnv = []string{"HOME"}
+	cmd := parseCmd(t, "echo", "{{name}}")
+	cmd.Env = []EnvTemplate{{Name: "NAME", Value: parseTemplate(t, "{{name}}")}}
+	cmd.Cwd = parseTemplate(t, "/work/{{name}}")
+	cmd.DockerImage = parseTemplate(t, "alpine:{{tag}}")
+	cmd.DockerNetwork = parseTemplate(t, "none")
+	cmd.DockerVolumes = append(cmd.DockerVolumes, parseTemplate(t, "/data/{{name}}:/data"))
+	job := map[string]interface{}{"name": "x", "tag": "3"}
+	d, err := newDockerRun(o, cmd, job, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(d.cidFile)
+	want := []string{
+		"docker", "run", "--cidfile", d.cidFile,
+		"-w", "/work/x",
+		"--network", "none",
+		"-v", "/data/x:/data",
+		"-e", "NAME",
+		"-e", "HOME",
+		"alpine:3",
+		"echo", "x",
+	}
+	got := d.wrap(renderCommand(o, cmd, job, nil))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
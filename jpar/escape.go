@@ -0,0 +1,75 @@
+package jpar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// STRICT_UNSAFE_CHARS are refused in record values with --escape strict,
+// along with control characters such as newlines and NULs.
+const STRICT_UNSAFE_CHARS = ";&|<>()$`\\\"'*?[]{}!#~"
+
+// quotesValues reports whether string values are shell-quoted when they
+// are inserted into commands.  Shell mode always quotes them.
+func quotesValues(o *Options) bool {
+	return o.Shell || o.Escape == ESCAPE_SHELL
+}
+
+// unsafeField returns the name of the first field of a record holding a
+// string which --escape strict refuses, or "" when there is none.  A
+// record which is itself such a string is named ".".
+func unsafeField(v interface{}, name string) string {
+	switch x := v.(type) {
+	case string:
+		if unsafeString(x) {
+			if name == "" {
+				return "."
+			}
+			return name
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if f := unsafeField(x[k], fieldName(name, k)); f != "" {
+				return f
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			if f := unsafeField(e, fieldName(name, strconv.Itoa(i))); f != "" {
+				return f
+			}
+		}
+	}
+	return ""
+}
+
+func fieldName(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func unsafeString(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(STRICT_UNSAFE_CHARS, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeResult is the result for a record refused by --escape strict.
+func unsafeResult(record interface{}, field string) map[string]interface{} {
+	r := skippedResult(record, "")
+	r["outcome"] = OUTCOME_FAILURE
+	r["error"] = fmt.Sprintf("field %s contains characters refused by --escape strict", field)
+	return r
+}
@@ -0,0 +1,47 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestUnsafeField(t *testing.T) {
+	cases := []struct {
+		v interface{}
+		want string
+	}{
+		{map[string]interface{}{"f": "report 2024.pdf", "n": 3.0}, ""},
+		{map[string]interface{}{"f": "a.txt; rm -rf /"}, "f"},
+		{map[string]interface{}{"f": "a\nb"}, "f"},
+		{map[string]interface{}{"f": "a\x00b"}, "f"},
+		{map[string]interface{}{"a": "$(id)", "b": "`id`"}, "a"},
+		{map[string]interface{}{"items": []interface{}{"ok", map[string]interface{}{"x": "it's"}}}, "items.1.x"},
+		{"plain line", ""},
+		{"line | tee", "."},
+	}
+	for _, c := range cases {
+		if got := unsafeField(c.v, ""); got != c.want {
+			t.Errorf("unsafeField(%v) = %q, want %q", c.v, got, c.want)
+		}
+	}
+}
+
+func TestRenderCommandEscapeShell(t *testing.T) {
+	o := &Options{Args: []string{"sh", "-c", "echo {{f}}"}, Escape: ESCAPE_SHELL}
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := renderCommand(o, cmd, map[string]interface{}{"f": "a; id"}, nil)
+	if len(got) != 3 || got[2] != "echo 'a; id'" {
+		t.Errorf("expected the value quoted inside the script, got %q", got)
+	}
+	o = &Options{Args: []string{"sh", "-c", "echo {}"}, Replace: "{}", Escape: ESCAPE_SHELL}
+	cmd, err = parseCommandTemplate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = renderCommand(o, cmd, "a; id", nil)
+	if got[2] != "echo 'a; id'" {
+		t.Errorf("expected the replacement quoted, got %q", got)
+	}
+}
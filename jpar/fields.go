@@ -0,0 +1,102 @@
+package jpar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseRenames parses OLD=NEW field renames.
+func parseRenames(renames []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, r := range renames {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("rename %s must have the form OLD=NEW", r)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// shapeResult keeps only the selected fields of a result, when fields
+// are selected, and then renames fields.  Fields are selected by their
+// original names.
+func shapeResult(fields []string, renames map[string]string, v interface{}) interface{} {
+	r, ok := v.(map[string]interface{})
+	if !ok || (len(fields) == 0 && len(renames) == 0) {
+		return v
+	}
+	shaped := map[string]interface{}{}
+	if len(fields) == 0 {
+		for k, x := range r {
+			shaped[k] = x
+		}
+	} else {
+		for _, k := range fields {
+			if x, ok := r[k]; ok {
+				shaped[k] = x
+			}
+		}
+	}
+	for old, name := range renames {
+		if x, ok := shaped[old]; ok {
+			delete(shaped, old)
+			shaped[name] = x
+		}
+	}
+	return shaped
+}
+
+// echoInput applies the echo policy to the input record held in a
+// result's e field, dropping it or keeping only the echoed fields.  The
+// items of a batch are echoed separately.
+func echoInput(o *Options, v interface{}) interface{} {
+	r, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	e, ok := r["e"]
+	if !ok {
+		return v
+	}
+	echo := o.EchoInput == ECHO_INPUT_ALWAYS || (o.EchoInput == ECHO_INPUT_ON_FAILURE && jobFailed(r))
+	if echo && len(o.EchoFields) == 0 {
+		return v
+	}
+	echoed := map[string]interface{}{}
+	for k, x := range r {
+		echoed[k] = x
+	}
+	delete(echoed, "e")
+	if !echo {
+		return echoed
+	}
+	if o.Batch > 0 {
+		if items, ok := e.(map[string]interface{})["items"].([]interface{}); ok {
+			selected := []interface{}{}
+			for _, item := range items {
+				selected = append(selected, selectFields(o.EchoFields, item))
+			}
+			echoed["e"] = map[string]interface{}{"items": selected}
+			return echoed
+		}
+	}
+	echoed["e"] = selectFields(o.EchoFields, e)
+	return echoed
+}
+
+// selectFields keeps only some fields of a record.  Records which are
+// not objects are kept whole.
+func selectFields(fields []string, v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	selected := map[string]interface{}{}
+	for _, k := range fields {
+		if x, ok := m[k]; ok {
+			selected[k] = x
+		}
+	}
+	return selected
+}
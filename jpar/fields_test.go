@@ -0,0 +1,60 @@
+package jpar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShapeResult(t *testing.T) {
+	r := map[string]interface{}{"e": 1, "stdout": "x", "stderr": "", "outcome": OUTCOME_SUCCESS}
+	renames := map[string]string{"e": "input"}
+	got := shapeResult([]string{"e", "stdout", "missing"}, renames, r)
+	want := map[string]interface{}{"input": 1, "stdout": "x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = shapeResult(nil, renames, r)
+	if m := got.(map[string]interface{}); len(m) != 4 || m["input"] != 1 {
+		t.Errorf("unexpected rename of all fields %v", got)
+	}
+	if _, err := parseRenames([]string{"e"}); err == nil {
+		t.Error("expected an error for a rename without =")
+	}
+}
+
+func TestEchoInput(t *testing.T) {
+	record := map[string]interface{}{"id": 1, "payload": "big"}
+	succeeded := map[string]interface{}{"e": record, "outcome": OUTCOME_SUCCESS}
+	failed := map[string]interface{}{"e": record, "outcome": OUTCOME_FAILURE}
+	cases := []struct {
+		policy string
+		fields []string
+		r map[string]interface{}
+		want interface{}
+	}{
+		{ECHO_INPUT_ON_FAILURE, nil, succeeded, nil},
+		{ECHO_INPUT_ON_FAILURE, nil, failed, record},
+		{ECHO_INPUT_NEVER, nil, failed, nil},
+		{ECHO_INPUT_ALWAYS, nil, succeeded, record},
+		{ECHO_INPUT_ALWAYS, []string{"id"}, succeeded, map[string]interface{}{"id": 1}},
+	}
+	for _, c := range cases {
+		o := &Options{EchoInput: c.policy, EchoFields: c.fields}
+		got := echoInput(o, c.r).(map[string]interface{})
+		if !reflect.DeepEqual(got["e"], c.want) {
+			t.Errorf("%s %v %s: got %v, want %v", c.policy, c.fields, c.r["outcome"], got["e"], c.want)
+		}
+		if got["outcome"] != c.r["outcome"] {
+			t.Errorf("%s: lost the outcome", c.policy)
+		}
+	}
+	if _, ok := succeeded["e"]; !ok {
+		t.Error("the original result was changed")
+	}
+	o := &Options{EchoInput: ECHO_INPUT_ALWAYS, EchoFields: []string{"id"}, Batch: 2}
+	batch := map[string]interface{}{"e": map[string]interface{}{"items": []interface{}{record, record}}}
+	want := map[string]interface{}{"items": []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 1}}}
+	if got := echoInput(o, batch).(map[string]interface{}); !reflect.DeepEqual(got["e"], want) {
+		t.Errorf("unexpected batch echo %v", got["e"])
+	}
+}
@@ -0,0 +1,112 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// compileFilter compiles a jq expression.  An empty expression yields a
+// nil filter.
+func compileFilter(expr string) (*gojq.Code, error) {
+	return compileExpression("filter", expr)
+}
+
+// compileCondition compiles the jq expression deciding whether records
+// are run.  An empty expression yields a nil condition.
+func compileCondition(expr string) (*gojq.Code, error) {
+	return compileExpression("condition", expr)
+}
+
+// compileOutputFilter compiles the jq expression applied to results
+// before they are written.  An empty expression yields a nil filter.
+func compileOutputFilter(expr string) (*gojq.Code, error) {
+	return compileExpression("output filter", expr)
+}
+
+func compileExpression(what string, expr string) (*gojq.Code, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s %s: %s", what, expr, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile %s %s: %s", what, expr, err)
+	}
+	return code, nil
+}
+
+// applyFilter runs a compiled filter over a record.  A filter may produce
+// any number of records; producing none means that the record is skipped.
+func applyFilter(code *gojq.Code, v interface{}) ([]interface{}, error) {
+	out := []interface{}{}
+	iter := code.Run(v)
+	for {
+		x, ok := iter.Next()
+		if !ok {
+			return out, nil
+		}
+		if err, ok := x.(error); ok {
+			return nil, err
+		}
+		out = append(out, x)
+	}
+}
+
+// conditionMet reports whether a condition holds for a record, which is
+// when its first value is neither false nor null, as in jq.
+func conditionMet(code *gojq.Code, v interface{}) (bool, error) {
+	iter := code.Run(v)
+	x, ok := iter.Next()
+	if !ok {
+		return false, nil
+	}
+	if err, ok := x.(error); ok {
+		return false, err
+	}
+	return x != nil && x != false, nil
+}
+
+// filterResult applies the output filter to a result, returning the
+// values written in its place.  A result the filter fails on is written
+// as it is, with the error under output_filter_error.
+func filterResult(code *gojq.Code, v interface{}) []interface{} {
+	if code == nil {
+		return []interface{}{v}
+	}
+	out, err := applyFilter(code, plainValue(v))
+	if err == nil {
+		return out
+	}
+	r, ok := v.(map[string]interface{})
+	if !ok {
+		return []interface{}{v}
+	}
+	failed := map[string]interface{}{}
+	for k, x := range r {
+		failed[k] = x
+	}
+	failed["output_filter_error"] = err.Error()
+	return []interface{}{failed}
+}
+
+// plainValue converts a result into the JSON types which jq works on,
+// such as []interface{} in place of []string.
+func plainValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var x interface{}
+	if err := d.Decode(&x); err != nil {
+		return v
+	}
+	return x
+}
@@ -0,0 +1,91 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestApplyFilter(t *testing.T) {
+	code, err := compileFilter("select(.enabled) | {name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := applyFilter(code, map[string]interface{}{"enabled": true, "name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].(map[string]interface{})["name"] != "a" {
+		t.Errorf("unexpected filter output %v", out)
+	}
+	out, err = applyFilter(code, map[string]interface{}{"enabled": false, "name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("expected record to be skipped, got %v", out)
+	}
+	if _, err := compileFilter("select("); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestConditionMet(t *testing.T) {
+	code, err := compileCondition(`.status == "active"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		v interface{}
+		want bool
+	}{
+		{map[string]interface{}{"status": "active"}, true},
+		{map[string]interface{}{"status": "idle"}, false},
+		{map[string]interface{}{}, false},
+	}
+	for _, c := range cases {
+		if got, err := conditionMet(code, c.v); err != nil || got != c.want {
+			t.Errorf("%v: got %v, %v, want %v", c.v, got, err, c.want)
+		}
+	}
+	code, _ = compileCondition(".n")
+	for v, want := range map[interface{}]bool{nil: false, 0.0: true, "": true} {
+		if got, _ := conditionMet(code, map[string]interface{}{"n": v}); got != want {
+			t.Errorf("%v: got %v, want %v", v, got, want)
+		}
+	}
+	code, _ = compileCondition("empty")
+	if got, _ := conditionMet(code, nil); got {
+		t.Error("expected no value to be false")
+	}
+	code, _ = compileCondition(".n + 1")
+	if _, err := conditionMet(code, map[string]interface{}{"n": "x"}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestFilterResult(t *testing.T) {
+	r := map[string]interface{}{"command": []string{"echo", "1"}, "returncode": uint32(0), "stdout": `{"n":1}`}
+	code, err := compileOutputFilter(`{cmd: .command[0], rc: .returncode, data: (.stdout | fromjson)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filterResult(code, r)
+	if len(out) != 1 {
+		t.Fatalf("expected one value, got %v", out)
+	}
+	v := out[0].(map[string]interface{})
+	if v["cmd"] != "echo" || v["rc"] != 0 || v["data"].(map[string]interface{})["n"] != 1 {
+		t.Errorf("unexpected output %v", v)
+	}
+	code, _ = compileOutputFilter("select(.returncode != 0)")
+	if out := filterResult(code, r); len(out) != 0 {
+		t.Errorf("expected the result to be dropped, got %v", out)
+	}
+	code, _ = compileOutputFilter(".stdout | tonumber")
+	out = filterResult(code, r)
+	if len(out) != 1 || out[0].(map[string]interface{})["output_filter_error"] == nil || r["output_filter_error"] != nil {
+		t.Errorf("expected the result with an error, got %v", out)
+	}
+	if out := filterResult(nil, r); len(out) != 1 {
+		t.Errorf("expected the result unchanged, got %v", out)
+	}
+}
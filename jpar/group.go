@@ -0,0 +1,59 @@
+package jpar
+
+import (
+	"sync"
+)
+
+// groupLimiter bounds the number of running jobs which share a group
+// key.  Jobs beyond the limit wait in a queue for their group, so a busy
+// group does not hold up the jobs of other groups.
+type groupLimiter struct {
+	mu sync.Mutex
+	limit int
+	running map[string]int
+	queued map[string][]Job
+}
+
+func newGroupLimiter(limit int) *groupLimiter {
+	return &groupLimiter{
+		limit: limit,
+		running: map[string]int{},
+		queued: map[string][]Job{},
+	}
+}
+
+// acquire reports whether job may run now.  Otherwise it is queued until
+// a job of the same group is released.
+func (g *groupLimiter) acquire(job Job) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running[job.Group] < g.limit {
+		g.running[job.Group] = g.running[job.Group] + 1
+		return true
+	}
+	g.queued[job.Group] = append(g.queued[job.Group], job)
+	return false
+}
+
+// release records that a job of the group has finished, and returns the
+// next queued job of the group if there is one.  That job takes over the
+// finished job's place.
+func (g *groupLimiter) release(group string) (Job, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	q := g.queued[group]
+	if len(q) > 0 {
+		next := q[0]
+		if len(q) == 1 {
+			delete(g.queued, group)
+		} else {
+			g.queued[group] = q[1:]
+		}
+		return next, true
+	}
+	g.running[group] = g.running[group] - 1
+	if g.running[group] == 0 {
+		delete(g.running, group)
+	}
+	return Job{}, false
+}
@@ -0,0 +1,35 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestGroupLimiter(t *testing.T) {
+	g := newGroupLimiter(1)
+	if !g.acquire(Job{Seq: 0, Group: "a"}) {
+		t.Fatal("first job of a should run")
+	}
+	if !g.acquire(Job{Seq: 1, Group: "b"}) {
+		t.Fatal("first job of b should run")
+	}
+	if g.acquire(Job{Seq: 2, Group: "a"}) {
+		t.Fatal("second job of a should be queued")
+	}
+	if g.acquire(Job{Seq: 3, Group: "a"}) {
+		t.Fatal("third job of a should be queued")
+	}
+	next, ok := g.release("a")
+	if !ok || next.Seq != 2 {
+		t.Fatalf("release(a) = %d, %v, want 2, true", next.Seq, ok)
+	}
+	next, ok = g.release("a")
+	if !ok || next.Seq != 3 {
+		t.Fatalf("release(a) = %d, %v, want 3, true", next.Seq, ok)
+	}
+	if _, ok = g.release("a"); ok {
+		t.Fatal("group a should be empty")
+	}
+	if !g.acquire(Job{Seq: 4, Group: "a"}) {
+		t.Fatal("group a should have room")
+	}
+}
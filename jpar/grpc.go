@@ -0,0 +1,205 @@
+package jpar
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// GRPC_STREAM_BUFFER is how many results may wait to be sent on a
+// stream before its client is taken to have fallen behind, and how many
+// results are held for the next stream while none is open.
+const GRPC_STREAM_BUFFER = 1024
+
+// grpcPool serves the gRPC API described in pool.proto.  The messages
+// are well known types, so the service is registered by hand rather
+// than generated.
+type grpcPool struct {
+	pool *servePool
+	server *grpc.Server
+	// streams holds the open result streams, held holds the most recent
+	// results finished while no stream was open, and pending counts the
+	// submitted jobs whose results have not been streamed.
+	mu sync.Mutex
+	streams map[*grpcStream]bool
+	held []*structpb.Struct
+	pending sync.WaitGroup
+}
+
+// grpcStream is an open StreamResults call.  Each stream has its own
+// buffer, so a slow client holds up nobody else.  behind is closed when
+// the buffer overflows.
+type grpcStream struct {
+	results chan *structpb.Struct
+	behind chan struct{}
+	done <-chan struct{}
+}
+
+func newGrpcPool(p *servePool) *grpcPool {
+	a := &grpcPool{pool: p, server: grpc.NewServer(), streams: map[*grpcStream]bool{}}
+	a.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "jpar.Pool",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			grpcUnary("SubmitJob", func() proto.Message { return &structpb.Struct{} }, a.submitJob),
+			grpcUnary("Pause", func() proto.Message { return &emptypb.Empty{} }, a.pause),
+			grpcUnary("Resume", func() proto.Message { return &emptypb.Empty{} }, a.resume),
+			grpcUnary("Stats", func() proto.Message { return &emptypb.Empty{} }, a.stats),
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "StreamResults", Handler: a.streamResults, ServerStreams: true},
+		},
+		Metadata: "pool.proto",
+	}, a)
+	return a
+}
+
+// grpcUnary describes a unary method which decodes its request into the
+// message made by in.
+func grpcUnary(name string, in func() proto.Message, call func(context.Context, proto.Message) (proto.Message, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := in()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return call(ctx, req)
+		},
+	}
+}
+
+func (a *grpcPool) serve(l net.Listener) {
+	a.server.Serve(l)
+}
+
+// stop waits for the results of submitted jobs to be streamed, and then
+// stops the server.
+func (a *grpcPool) stop() {
+	a.pending.Wait()
+	a.server.Stop()
+}
+
+// submitJob waits while the pool is paused, until the call is cancelled
+// or its deadline passes.
+func (a *grpcPool) submitJob(ctx context.Context, in proto.Message) (proto.Message, error) {
+	seqs, ok := a.pool.accept(ctx, a, in.(*structpb.Struct).AsMap())
+	if !ok {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Error(codes.Unavailable, "shutting down")
+	}
+	return toStruct(map[string]interface{}{"seqs": seqs})
+}
+
+func (a *grpcPool) pause(context.Context, proto.Message) (proto.Message, error) {
+	a.pool.gate.pause()
+	return &emptypb.Empty{}, nil
+}
+
+func (a *grpcPool) resume(context.Context, proto.Message) (proto.Message, error) {
+	a.pool.gate.resume()
+	return &emptypb.Empty{}, nil
+}
+
+func (a *grpcPool) stats(context.Context, proto.Message) (proto.Message, error) {
+	return toStruct(a.pool.record())
+}
+
+func (a *grpcPool) streamResults(_ interface{}, stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+	s := &grpcStream{
+		results: make(chan *structpb.Struct, GRPC_STREAM_BUFFER),
+		behind: make(chan struct{}),
+		done: stream.Context().Done(),
+	}
+	a.mu.Lock()
+	a.streams[s] = true
+	// The first stream opened gets the results no stream was open for.
+	for _, r := range a.held {
+		s.results <- r
+	}
+	a.held = nil
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.streams, s)
+		a.mu.Unlock()
+	}()
+	// The headers tell the client that results are now streamed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case r := <-s.results:
+			if err := stream.SendMsg(r); err != nil {
+				return err
+			}
+		case <-s.behind:
+			return status.Error(codes.ResourceExhausted, "results were dropped because the stream fell behind")
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// write sends a result to every open stream without waiting for any of
+// them.  A stream whose buffer is full is ended with an error, and while
+// no stream is open the result is held for the next one.
+func (a *grpcPool) write(seq int, v interface{}) {
+	r, err := toStruct(map[string]interface{}{"seq": seq, "result": v})
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.streams) == 0 {
+		a.held = append(a.held, r)
+		if len(a.held) > GRPC_STREAM_BUFFER {
+			a.held = a.held[1:]
+		}
+		return
+	}
+	for s := range a.streams {
+		select {
+		case s.results <- r:
+		default:
+			close(s.behind)
+			delete(a.streams, s)
+		}
+	}
+}
+
+func (a *grpcPool) add() {
+	a.pending.Add(1)
+}
+
+func (a *grpcPool) done() {
+	a.pending.Done()
+}
+
+// toStruct converts a value to a Struct through JSON, which Struct
+// mirrors.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
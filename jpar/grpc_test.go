@@ -0,0 +1,141 @@
+package jpar
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGrpcPool(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	g, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- NewRunner(o).Serve(ctx, nil, g)
+	}()
+	conn, err := grpc.NewClient(g.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	call := context.Background()
+	stream, err := conn.NewStream(call, &grpc.StreamDesc{ServerStreams: true}, "/jpar.Pool/StreamResults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	stats := &structpb.Struct{}
+	if err := conn.Invoke(call, "/jpar.Pool/Pause", &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Invoke(call, "/jpar.Pool/Stats", &emptypb.Empty{}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if !stats.AsMap()["paused"].(bool) {
+		t.Errorf("expected the pool to be paused, got %v", stats.AsMap())
+	}
+	if err := conn.Invoke(call, "/jpar.Pool/Resume", &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	record, _ := structpb.NewStruct(map[string]interface{}{"n": 7})
+	submitted := &structpb.Struct{}
+	if err := conn.Invoke(call, "/jpar.Pool/SubmitJob", record, submitted); err != nil {
+		t.Fatal(err)
+	}
+	seqs := submitted.AsMap()["seqs"].([]interface{})
+	if len(seqs) != 1 {
+		t.Fatalf("expected one sequence number, got %v", submitted.AsMap())
+	}
+	result := &structpb.Struct{}
+	if err := stream.RecvMsg(result); err != nil {
+		t.Fatal(err)
+	}
+	r := result.AsMap()
+	if r["seq"] != seqs[0] || !strings.Contains(r["result"].(map[string]interface{})["stdout"].(string), "7") {
+		t.Errorf("expected the result of the submitted job, got %v", r)
+	}
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected the server to stop when cancelled, got %v", err)
+	}
+}
+
+func TestGrpcPoolWithoutStream(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	g, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- NewRunner(o).Serve(ctx, nil, g)
+	}()
+	conn, err := grpc.NewClient(g.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	call := context.Background()
+	record, _ := structpb.NewStruct(map[string]interface{}{"n": 7})
+	if err := conn.Invoke(call, "/jpar.Pool/SubmitJob", record, &structpb.Struct{}); err != nil {
+		t.Fatal(err)
+	}
+	// A submission waiting while the pool is paused gives up with its call.
+	if err := conn.Invoke(call, "/jpar.Pool/Pause", &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	short, stop := context.WithTimeout(call, 100*time.Millisecond)
+	defer stop()
+	err = conn.Invoke(short, "/jpar.Pool/SubmitJob", record, &structpb.Struct{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected the deadline to end a paused submission, got %v", err)
+	}
+	// The result finished before any stream was open is sent to the first.
+	stream, err := conn.NewStream(call, &grpc.StreamDesc{ServerStreams: true}, "/jpar.Pool/StreamResults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	result := &structpb.Struct{}
+	if err := stream.RecvMsg(result); err != nil {
+		t.Fatal(err)
+	}
+	if r := result.AsMap(); r["seq"] != float64(0) || !strings.Contains(r["result"].(map[string]interface{})["stdout"].(string), "7") {
+		t.Errorf("expected the held result, got %v", r)
+	}
+	stats := &structpb.Struct{}
+	if err := conn.Invoke(call, "/jpar.Pool/Stats", &emptypb.Empty{}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.AsMap()["waiting"] != float64(0) || stats.AsMap()["submitted"] != float64(1) {
+		t.Errorf("expected the abandoned submission to be gone, got %v", stats.AsMap())
+	}
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected the server to stop when cancelled, got %v", err)
+	}
+}
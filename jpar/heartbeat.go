@@ -0,0 +1,48 @@
+package jpar
+
+import (
+	"time"
+)
+
+// heartbeatEvent is the record written for a job which is still
+// running.
+func heartbeatEvent(seq int, elapsed time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"event": "running",
+		"job": seq,
+		"elapsed_ms": elapsed.Milliseconds(),
+	}
+}
+
+// startHeartbeat writes a running event for a job each time interval
+// passes, until the returned function is called.  That function returns
+// once no more events can be written.
+func startHeartbeat(interval time.Duration, seq int, completed chan Output) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case completed <- Output{Value: heartbeatEvent(seq, time.Since(start)), Event: true}:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
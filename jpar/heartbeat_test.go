@@ -0,0 +1,27 @@
+package jpar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartHeartbeat(t *testing.T) {
+	completed := make(chan Output)
+	stop := startHeartbeat(10*time.Millisecond, 7, completed)
+	for i := 0; i < 2; i++ {
+		x := <-completed
+		e := x.Value.(map[string]interface{})
+		if !x.Event || e["event"] != "running" || e["job"] != 7 || e["elapsed_ms"].(int64) < 10 {
+			t.Errorf("unexpected heartbeat %v", x)
+		}
+	}
+	// Stopping does not wait for a heartbeat to be read.
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	select {
+	case x := <-completed:
+		t.Errorf("unexpected heartbeat after stopping %v", x)
+	case <-time.After(30 * time.Millisecond):
+	}
+	startHeartbeat(0, 0, completed)()
+}
@@ -0,0 +1,119 @@
+package jpar
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+)
+
+// htmlReport is a self-contained HTML page with a table of the jobs,
+// which can be sorted by any column, and whose output can be expanded.
+type htmlReport struct {
+	start time.Time
+	jobs []htmlJob
+}
+
+type htmlJob struct {
+	Seq int
+	Name string
+	Outcome string
+	Seconds float64
+	ExitCode string
+	Error string
+	Stdout string
+	Stderr string
+}
+
+func newHtmlReport() *htmlReport {
+	return &htmlReport{start: time.Now()}
+}
+
+func (h *htmlReport) add(seq int, r map[string]interface{}) {
+	j := htmlJob{Seq: seq, Name: reportName(seq, r), Seconds: resultSeconds(r)}
+	j.Outcome, _ = r["outcome"].(string)
+	if code, ok := r["exit_code"]; ok {
+		j.ExitCode = fmt.Sprint(code)
+	}
+	j.Error, _ = r["error"].(string)
+	if j.Error == "" && jobSkipped(r) {
+		j.Error, _ = r["reason"].(string)
+	}
+	j.Stdout, _ = r["stdout"].(string)
+	j.Stderr, _ = r["stderr"].(string)
+	h.jobs = append(h.jobs, j)
+}
+
+func (h *htmlReport) write(w io.Writer) error {
+	sort.Slice(h.jobs, func(a, b int) bool { return h.jobs[a].Seq < h.jobs[b].Seq })
+	counts := map[string]int{}
+	for _, j := range h.jobs {
+		counts[j.Outcome] = counts[j.Outcome] + 1
+	}
+	return htmlReportPage.Execute(w, map[string]interface{}{
+		"Start": h.start.Format(time.RFC3339),
+		"Wall": time.Since(h.start).Round(time.Millisecond).String(),
+		"Jobs": h.jobs,
+		"Counts": counts,
+	})
+}
+
+var htmlReportPage = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>jpar run {{.Start}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { cursor: pointer; background: #f4f4f4; user-select: none; }
+td.num { text-align: right; }
+tr.SUCCESS td.outcome { color: #060; }
+tr.FAILURE td.outcome, tr.TIMEOUT td.outcome { color: #b00; font-weight: bold; }
+tr.SKIPPED td.outcome, tr.CANCELLED td.outcome { color: #777; }
+pre { margin: 4px 0; max-height: 30em; overflow: auto; background: #f8f8f8; padding: 4px; }
+</style>
+</head>
+<body>
+<h1>jpar run</h1>
+<p>Started {{.Start}}, took {{.Wall}}.
+{{range $outcome, $n := .Counts}}{{$outcome}}: {{$n}}. {{end}}</p>
+<table id="jobs">
+<thead><tr><th>Seq</th><th>Job</th><th>Outcome</th><th>Seconds</th><th>Exit code</th><th>Error</th><th>Output</th></tr></thead>
+<tbody>
+{{range .Jobs}}<tr class="{{.Outcome}}">
+<td class="num">{{.Seq}}</td>
+<td>{{.Name}}</td>
+<td class="outcome">{{.Outcome}}</td>
+<td class="num">{{printf "%.3f" .Seconds}}</td>
+<td class="num">{{.ExitCode}}</td>
+<td>{{.Error}}</td>
+<td>{{if .Stdout}}<details><summary>stdout</summary><pre>{{.Stdout}}</pre></details>{{end}}{{if .Stderr}}<details><summary>stderr</summary><pre>{{.Stderr}}</pre></details>{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+<script>
+// Clicking a heading sorts by its column, and clicking again reverses.
+document.querySelectorAll("#jobs th").forEach(function(th, col) {
+  th.addEventListener("click", function() {
+    var body = document.querySelector("#jobs tbody");
+    var rows = Array.prototype.slice.call(body.rows);
+    var dir = th.dataset.dir === "asc" ? -1 : 1;
+    th.dataset.dir = dir === 1 ? "asc" : "desc";
+    rows.sort(function(a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      if (!isNaN(nx) && !isNaN(ny)) {
+        return (nx - ny) * dir;
+      }
+      return x.localeCompare(y) * dir;
+    });
+    rows.forEach(function(row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,30 @@
+package jpar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHtmlReport(t *testing.T) {
+	h := newHtmlReport()
+	h.add(1, map[string]interface{}{"outcome": OUTCOME_FAILURE, "key": "b", "exit_code": 2, "error": "exited with status 2", "stderr": "<script>alert(1)</script>"})
+	h.add(0, map[string]interface{}{"outcome": OUTCOME_SUCCESS, "key": "a", "duration_ms": int64(1500), "stdout": "ok\n"})
+	h.add(2, map[string]interface{}{"outcome": OUTCOME_SKIPPED, "reason": "condition not met"})
+	var b bytes.Buffer
+	if err := h.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{
+		"<td>a</td>", "<td>b</td>", "<td>job 2</td>", "1.500", "exited with status 2", "condition not met",
+		"<pre>ok\n</pre>", "&lt;script&gt;alert(1)&lt;/script&gt;", "FAILURE: 1.",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected the page to contain %q", want)
+		}
+	}
+	if strings.Index(page, "<td>a</td>") > strings.Index(page, "<td>b</td>") {
+		t.Error("expected jobs in sequence order")
+	}
+}
@@ -0,0 +1,93 @@
+package jpar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runHTTPJob performs the HTTP request described by the command, which
+// is a method followed by a URL.  The request body comes from --body or
+// from the stdin options, and the response body is captured like stdout.
+func runHTTPJob(ctx context.Context, o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}, args []string, r map[string]interface{}) map[string]interface{} {
+	if len(args) != 2 {
+		r["error"] = "an HTTP command must be a method and a URL"
+		return r
+	}
+	timeout, err := jobTimeout(o, job)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	var body io.Reader
+	if cmd.Body != nil {
+		body = strings.NewReader(render(cmd.Body, job, meta))
+	} else if body, err = jobStdin(o, cmd, job, meta); err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	if f, ok := body.(io.Closer); ok {
+		defer f.Close()
+	}
+	jobCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(jobCtx, strings.ToUpper(args[0]), args[1], body)
+	if err != nil {
+		r["error"] = fmt.Sprintf("invalid request: %s", err)
+		return r
+	}
+	for _, h := range cmd.Headers {
+		req.Header.Add(h.Name, render(h.Value, job, meta))
+	}
+	stdout, err := newOutputCapture("stdout", cmd.StdoutFile, o.MaxOutputBytes, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	defer stdout.Close()
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		<-stdout.collect(resp.Body)
+		resp.Body.Close()
+		err = stdout.err
+	}
+	r["duration_ms"] = time.Since(start).Milliseconds()
+	if ctx.Err() != nil {
+		r["error"] = "cancelled"
+		return r
+	}
+	if errors.Is(jobCtx.Err(), context.DeadlineExceeded) {
+		r["error"] = fmt.Sprintf("request abandoned after timeout of %s", timeout)
+		r["outcome"] = OUTCOME_TIMEOUT
+		return r
+	}
+	if err != nil {
+		r["error"] = fmt.Sprintf("request failed: %s", err)
+		return r
+	}
+	stdout.record(r)
+	r["status"] = resp.StatusCode
+	headers := map[string]interface{}{}
+	for k, v := range resp.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+	r["headers"] = headers
+	// Error statuses count as a non-zero exit, so they can be retried.
+	r["returncode"] = uint32(0)
+	r["outcome"] = OUTCOME_SUCCESS
+	if resp.StatusCode >= 400 {
+		r["returncode"] = uint32(1) << 8
+		r["outcome"] = OUTCOME_FAILURE
+		r["error"] = fmt.Sprintf("request returned %s", resp.Status)
+	}
+	return r
+}
@@ -0,0 +1,57 @@
+package jpar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunHTTPJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("X-Item", req.URL.Query().Get("id"))
+		if req.Header.Get("Authorization") != "token t1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		w.Write([]byte(req.Method + " " + string(body)))
+	}))
+	defer srv.Close()
+	o := &Options{HTTP: true}
+	cmd := parseCmd(t, "post", srv.URL+"/items?id={{id}}")
+	cmd.Body = parseTemplate(t, "name={{name}}")
+	cmd.Headers = []EnvTemplate{{Name: "Authorization", Value: parseTemplate(t, "token {{token}}")}}
+	job := map[string]interface{}{"id": "7", "name": "x", "token": "t1"}
+	r := runJob(context.Background(), o, cmd, job, nil)
+	if r["outcome"] != OUTCOME_SUCCESS || r["status"] != 200 || r["returncode"] != uint32(0) {
+		t.Fatalf("unexpected result %v", r)
+	}
+	if r["stdout"] != "POST name=x" {
+		t.Errorf("unexpected body %q", r["stdout"])
+	}
+	if r["headers"].(map[string]interface{})["X-Item"] != "7" {
+		t.Errorf("unexpected headers %v", r["headers"])
+	}
+	job["token"] = "bad"
+	r = runJob(context.Background(), o, cmd, job, nil)
+	if r["status"] != 401 || !shouldRetry(r) {
+		t.Errorf("expected a failed request, got %v", r)
+	}
+}
+
+func TestRunHTTPJobTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	o := &Options{HTTP: true, Timeout: 100 * time.Millisecond}
+	r := runJob(context.Background(), o, parseCmd(t, "GET", srv.URL), nil, nil)
+	if r["outcome"] != OUTCOME_TIMEOUT {
+		t.Errorf("expected a timeout, got %v", r)
+	}
+}
@@ -0,0 +1,57 @@
+package jpar
+
+import (
+	"sync"
+	"time"
+)
+
+// inactivityTimer cancels a job which writes nothing to stdout or
+// stderr for too long.  Every write to either stream restarts it.
+type inactivityTimer struct {
+	mu sync.Mutex
+	period time.Duration
+	timer *time.Timer
+	fired bool
+}
+
+// watchInactivity starts the timer, calling cancel once d passes with
+// no output.  It returns nil when d is zero.
+func watchInactivity(d time.Duration, cancel func()) *inactivityTimer {
+	if d <= 0 {
+		return nil
+	}
+	t := &inactivityTimer{period: d}
+	t.timer = time.AfterFunc(d, func() {
+		t.mu.Lock()
+		t.fired = true
+		t.mu.Unlock()
+		cancel()
+	})
+	return t
+}
+
+// Write restarts the timer, so output of any kind counts as activity.
+func (t *inactivityTimer) Write(p []byte) (int, error) {
+	if t == nil || len(p) == 0 {
+		return len(p), nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// A timer which has already gone off has cancelled the job.
+	if !t.fired && t.timer.Stop() {
+		t.timer.Reset(t.period)
+	}
+	return len(p), nil
+}
+
+// stop ends the watch, reporting whether the job was cancelled for
+// being idle.
+func (t *inactivityTimer) stop() bool {
+	if t == nil {
+		return false
+	}
+	t.timer.Stop()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fired
+}
@@ -0,0 +1,44 @@
+package jpar
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunJobInactivityTimeout(t *testing.T) {
+	o := &Options{InactivityTimeout: 300 * time.Millisecond}
+	// Steady output keeps the job alive past the inactivity timeout.
+	r := runJob(context.Background(), o, parseCmd(t, "sh", "-c", "for i in 1 2 3 4 5; do echo $i; sleep 0.1; done"), nil, nil)
+	if r["outcome"] != OUTCOME_SUCCESS {
+		t.Errorf("expected success, got %v", r)
+	}
+	r = runJob(context.Background(), o, parseCmd(t, "sh", "-c", "echo started; exec sleep 5"), nil, nil)
+	if r["outcome"] != OUTCOME_TIMEOUT || r["reason"] != "inactivity" {
+		t.Errorf("expected an inactivity timeout, got %v", r)
+	}
+	if r["stdout"] != "started\n" {
+		t.Errorf("expected the output before the timeout, got %v", r["stdout"])
+	}
+}
+
+func TestInactivityTimerRestarts(t *testing.T) {
+	fired := make(chan struct{})
+	idle := watchInactivity(100*time.Millisecond, func() { close(fired) })
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		idle.Write([]byte("x"))
+	}
+	select {
+	case <-fired:
+		t.Fatal("timer fired despite output")
+	default:
+	}
+	<-fired
+	if !idle.stop() {
+		t.Error("expected the timer to report that it fired")
+	}
+	if watchInactivity(0, func() {}).stop() {
+		t.Error("a disabled timer never fires")
+	}
+}
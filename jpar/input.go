@@ -0,0 +1,339 @@
+package jpar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readInput returns the stream of records for the input format.
+func readInput(o *Options, input io.Reader) (chan JsonRead, error) {
+	read, err := inputReader(o)
+	if err != nil {
+		return nil, err
+	}
+	return read(input), nil
+}
+
+// inputReader returns the function which reads records in the input
+// format.
+func inputReader(o *Options) (func(io.Reader) chan JsonRead, error) {
+	switch o.InputFormat {
+	case INPUT_FORMAT_JSON:
+		return ReadJsonStream, nil
+	case INPUT_FORMAT_JSONL:
+		return ReadJsonLines, nil
+	case INPUT_FORMAT_LINES:
+		sep := byte('\n')
+		if o.NullSeparated {
+			sep = 0
+		}
+		return func(input io.Reader) chan JsonRead {
+			return ReadLines(input, sep, o.LineKey)
+		}, nil
+	case INPUT_FORMAT_CSV, INPUT_FORMAT_TSV:
+		delimiter := o.Delimiter
+		quoting := o.Quoting
+		if delimiter == 0 {
+			delimiter = ','
+			if o.InputFormat == INPUT_FORMAT_TSV {
+				delimiter = '\t'
+			}
+		}
+		if quoting == "" {
+			quoting = QUOTING_STANDARD
+			if o.InputFormat == INPUT_FORMAT_TSV {
+				quoting = QUOTING_NONE
+			}
+		}
+		switch quoting {
+		case QUOTING_STANDARD, QUOTING_LAZY, QUOTING_NONE:
+		default:
+			return nil, fmt.Errorf("unknown quoting %s", quoting)
+		}
+		return func(input io.Reader) chan JsonRead {
+			return ReadCsv(input, delimiter, !o.NoHeader, quoting)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown input format %s", o.InputFormat)
+}
+
+// readInputFiles reads the records of each input in turn.  Inputs may be
+// globs, and "-" is the input reader.
+func readInputFiles(o *Options, paths []string, input io.Reader) (chan JsonRead, error) {
+	read, err := inputReader(o)
+	if err != nil {
+		return nil, err
+	}
+	files, err := expandInputs(paths)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan JsonRead)
+	go func() {
+		defer close(out)
+		for _, f := range files {
+			if isAmqp(f) || isRedis(f) || isNats(f) {
+				read := readAmqp
+				if isRedis(f) {
+					read = readRedis
+				} else if isNats(f) {
+					read = readNats
+				}
+				err := read(o, f, out)
+				out <- JsonRead{Err: err, File: redactAddress(f)}
+				return
+			}
+			if isObjectListing(f) {
+				if err := readObjects(f, out); err != nil {
+					out <- JsonRead{Err: err, File: f}
+					return
+				}
+				continue
+			}
+			rdr, err := openInput(f, input)
+			if err != nil {
+				out <- JsonRead{Err: err, File: f}
+				return
+			}
+			for x := range read(rdr) {
+				x.File = f
+				out <- x
+			}
+			rdr.Close()
+		}
+	}()
+	return out, nil
+}
+
+// expandInputs expands globs in the input paths.  A glob must match at
+// least one file.
+func expandInputs(paths []string) ([]string, error) {
+	files := []string{}
+	for _, p := range paths {
+		if p == "-" || strings.Contains(p, "://") || !strings.ContainsAny(p, "*?[") {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input pattern %s: %s", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no input files match %s", p)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// openInput opens an input file, decompressing .gz and .zst files.
+func openInput(path string, input io.Reader) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(input), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open input: %s", err)
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		z, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot decompress %s: %s", path, err)
+		}
+		return decompressed{z, f}, nil
+	case strings.HasSuffix(path, ".zst"):
+		z, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot decompress %s: %s", path, err)
+		}
+		return decompressed{z.IOReadCloser(), f}, nil
+	}
+	return f, nil
+}
+
+// decompressed closes both a decompressor and its file.
+type decompressed struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (d decompressed) Close() error {
+	d.ReadCloser.Close()
+	return d.file.Close()
+}
+
+// ReadJsonStream decodes a stream of concatenated JSON values.  Decoding
+// stops at the first error, which is delivered as the final value along
+// with its position and the text which could not be parsed.
+func ReadJsonStream(stream io.Reader) chan JsonRead {
+	counter := &lineCounter{r: stream}
+	dec := json.NewDecoder(counter)
+	out := make(chan JsonRead)
+	var j interface{}
+	go func() {
+		for {
+			if err := dec.Decode(&j); err != nil {
+				if err == io.EOF {
+					close(out)
+					return
+				} else {
+					// The decoder has not consumed the value it failed on,
+					// so its buffer starts with the value's text.
+					rest, _ := io.ReadAll(dec.Buffered())
+					text := bytes.TrimLeft(rest, " \t\r\n")
+					skipped := rest[:len(rest)-len(text)]
+					line := 1 + counter.lines - bytes.Count(rest, newline) + bytes.Count(skipped, newline)
+					offset := dec.InputOffset() + int64(len(skipped))
+					out <- JsonRead{Err: err, Line: line, Offset: offset, Text: snippet(string(text))}
+					close(out)
+					return
+				}
+			}
+			out <- JsonRead{Value: j}
+		}
+	}()
+	return out
+}
+
+var newline = []byte{'\n'}
+
+// lineCounter counts the lines read from a reader.
+type lineCounter struct {
+	r io.Reader
+	lines int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.lines = c.lines + bytes.Count(p[:n], newline)
+	return n, err
+}
+
+const SNIPPET_LENGTH = 80
+
+// snippet returns the start of the first line of text, for reporting
+// input which cannot be parsed.
+func snippet(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimRight(text, "\r")
+	if len(text) > SNIPPET_LENGTH {
+		text = strings.ToValidUTF8(text[:SNIPPET_LENGTH], "") + "..."
+	}
+	return text
+}
+
+// ReadJsonLines decodes one JSON value per line.  Blank lines are
+// skipped.  A malformed line produces an error carrying its line number,
+// and reading continues with the next line.
+func ReadJsonLines(stream io.Reader) chan JsonRead {
+	rdr := bufio.NewReader(stream)
+	out := make(chan JsonRead)
+	go func() {
+		defer close(out)
+		line := 0
+		var offset int64
+		for {
+			text, err := rdr.ReadString('\n')
+			if len(text) > 0 {
+				line = line + 1
+				start := offset
+				offset = offset + int64(len(text))
+				text = strings.TrimSpace(text)
+				if text != "" {
+					var j interface{}
+					if perr := json.Unmarshal([]byte(text), &j); perr != nil {
+						out <- JsonRead{Err: perr, Line: line, Offset: start, Text: snippet(text)}
+					} else {
+						out <- JsonRead{Value: j, Line: line}
+					}
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					out <- JsonRead{Err: err, Line: line}
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ReadLines turns each sep-terminated item of plain text into a record
+// of the form {key: item}.  Empty items are skipped.
+func ReadLines(stream io.Reader, sep byte, key string) chan JsonRead {
+	rdr := bufio.NewReader(stream)
+	out := make(chan JsonRead)
+	go func() {
+		defer close(out)
+		line := 0
+		for {
+			text, err := rdr.ReadString(sep)
+			if len(text) > 0 {
+				line = line + 1
+				text = strings.TrimSuffix(text, string(sep))
+				if sep == '\n' {
+					text = strings.TrimSuffix(text, "\r")
+				}
+				if text != "" {
+					out <- JsonRead{Value: map[string]interface{}{key: text}, Line: line}
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					out <- JsonRead{Err: err, Line: line}
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// parseError describes input which cannot be parsed.
+func parseError(x JsonRead) string {
+	msg := fmt.Sprintf("parse error: %s", x.Err)
+	if x.Line > 0 {
+		msg = fmt.Sprintf("parse error on line %d (offset %d): %s", x.Line, x.Offset, x.Err)
+	}
+	if x.Text != "" {
+		msg = fmt.Sprintf("%s: near %q", msg, x.Text)
+	}
+	if x.File != "" {
+		msg = fmt.Sprintf("%s: %s", x.File, msg)
+	}
+	return msg
+}
+
+type JsonRead struct {
+	Value interface{}
+	Err   error
+	// Line is the input line of the record, or zero when unknown.
+	Line int
+	// File is the input file of the record when reading from files.
+	File string
+	// Offset is the byte offset in the input of a value which cannot be
+	// parsed, and Text is the start of its text.
+	Offset int64
+	Text string
+	// Ack acknowledges a record read from a queue, reporting whether its
+	// jobs succeeded.
+	Ack func(ok bool)
+	// Reply answers a record read from a request with each of its
+	// results.
+	Reply func(v interface{})
+}
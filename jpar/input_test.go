@@ -0,0 +1,142 @@
+package jpar
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestReadJsonLines(t *testing.T) {
+	in := "{\"a\":1}\n\n  \n{\"a\":\nnot json\n{\"a\":2}"
+	got := []JsonRead{}
+	for x := range ReadJsonLines(strings.NewReader(in)) {
+		got = append(got, x)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 reads, got %d: %v", len(got), got)
+	}
+	for i, line := range []int{1, 4, 5, 6} {
+		if got[i].Line != line {
+			t.Errorf("read %d: expected line %d, got %d", i, line, got[i].Line)
+		}
+	}
+	if got[0].Err != nil || got[3].Err != nil {
+		t.Errorf("unexpected errors: %v, %v", got[0].Err, got[3].Err)
+	}
+	if got[1].Err == nil || got[2].Err == nil {
+		t.Error("expected errors for malformed lines")
+	}
+}
+
+func TestReadJsonStreamError(t *testing.T) {
+	in := "{\"a\":1}\n{\"a\":2}\n\n  {\"a\": nope}\n{\"a\":3}"
+	got := readAll(ReadJsonStream(strings.NewReader(in)))
+	if len(got) != 3 {
+		t.Fatalf("expected 3 reads, got %d: %v", len(got), got)
+	}
+	x := got[2]
+	if x.Err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if x.Line != 4 || x.Offset != 19 || x.Text != "{\"a\": nope}" {
+		t.Errorf("expected line 4, offset 19 and the bad value, got %d, %d, %q", x.Line, x.Offset, x.Text)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"{\"a\":", "{\"a\":"},
+		{"{\"a\":\r\n1}", "{\"a\":"},
+		{strings.Repeat("x", 100), strings.Repeat("x", SNIPPET_LENGTH) + "..."},
+	}
+	for _, c := range cases {
+		if got := snippet(c.text); got != c.want {
+			t.Errorf("snippet(%q): expected %q, got %q", c.text, c.want, got)
+		}
+	}
+}
+
+func readAll(c chan JsonRead) []JsonRead {
+	got := []JsonRead{}
+	for x := range c {
+		got = append(got, x)
+	}
+	return got
+}
+
+func TestReadCsv(t *testing.T) {
+	in := "name,path\na,\"/tmp/x, y\"\nb,/usr\nc,d,e\n"
+	got := readAll(ReadCsv(strings.NewReader(in), ',', true, QUOTING_STANDARD))
+	if len(got) != 3 {
+		t.Fatalf("expected 3 reads, got %d: %v", len(got), got)
+	}
+	if r := got[0].Value.(map[string]interface{}); r["name"] != "a" || r["path"] != "/tmp/x, y" {
+		t.Errorf("unexpected first record %v", r)
+	}
+	if got[2].Err == nil || got[2].Line != 4 {
+		t.Errorf("expected error on line 4, got %v", got[2])
+	}
+}
+
+func TestReadTsvWithoutHeader(t *testing.T) {
+	in := "a\t\"quoted\"\nb\tc\n"
+	got := readAll(ReadCsv(strings.NewReader(in), '\t', false, QUOTING_NONE))
+	if len(got) != 2 {
+		t.Fatalf("expected 2 reads, got %d: %v", len(got), got)
+	}
+	if r := got[0].Value.(map[string]interface{}); r["col1"] != "a" || r["col2"] != "\"quoted\"" {
+		t.Errorf("unexpected first record %v", r)
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	got := readAll(ReadLines(strings.NewReader("a b\r\n\nc"), '\n', "f"))
+	if len(got) != 2 || got[0].Value.(map[string]interface{})["f"] != "a b" || got[1].Line != 3 {
+		t.Errorf("unexpected lines %v", got)
+	}
+	got = readAll(ReadLines(strings.NewReader("x\ny\x00z\x00"), 0, "line"))
+	if len(got) != 2 || got[0].Value.(map[string]interface{})["line"] != "x\ny" {
+		t.Errorf("unexpected NUL-separated lines %v", got)
+	}
+}
+
+func TestReadInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"n":1}{"n":2}`), 0644)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"n":3}`))
+	w.Close()
+	os.WriteFile(filepath.Join(dir, "b.json.gz"), gz.Bytes(), 0644)
+	zw, _ := zstd.NewWriter(nil)
+	os.WriteFile(filepath.Join(dir, "c.json.zst"), zw.EncodeAll([]byte(`{"n":4}`), nil), 0644)
+	o := NewOptions()
+	inputs := []string{filepath.Join(dir, "*.json*"), "-"}
+	c, err := readInputFiles(o, inputs, strings.NewReader(`{"n":5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(c)
+	if len(got) != 5 {
+		t.Fatalf("expected 5 reads, got %v", got)
+	}
+	for i, x := range got {
+		if x.Err != nil || x.Value.(map[string]interface{})["n"] != float64(i+1) {
+			t.Errorf("read %d: unexpected %v", i, x)
+		}
+	}
+	if got[4].File != "-" {
+		t.Errorf("expected the last record from stdin, got %s", got[4].File)
+	}
+	if _, err := readInputFiles(o, []string{filepath.Join(dir, "*.csv")}, nil); err == nil {
+		t.Error("expected an error for a glob matching nothing")
+	}
+}
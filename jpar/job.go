@@ -0,0 +1,820 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmyounker/mustache"
+)
+
+// runJobWithRetries runs a job, rerunning it while it exits non-zero or
+// times out.  The delay between attempts grows by the backoff factor.
+func runJobWithRetries(ctx context.Context, o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) map[string]interface{} {
+	history := []interface{}{}
+	attempt := 1
+	for {
+		r := runJob(ctx, o, cmd, job, meta)
+		if o.AttemptHistory {
+			history = append(history, attemptRecord(r))
+		}
+		if attempt > o.Retries || !shouldRetry(r) || ctx.Err() != nil {
+			finishAttempts(o, r, attempt, history)
+			return r
+		}
+		delay := retryDelay(o, attempt)
+		logger(o).Info("retrying job", "seq", meta["_seq"], "attempt", attempt, "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		attempt = attempt + 1
+	}
+}
+
+// retryDelay is the delay after the given attempt before the next one.
+func retryDelay(o *Options, attempt int) time.Duration {
+	return time.Duration(float64(o.RetryDelay) * math.Pow(o.RetryBackoff, float64(attempt-1)))
+}
+
+// finishAttempts records the attempts made in the final result.
+func finishAttempts(o *Options, r map[string]interface{}, attempts int, history []interface{}) {
+	r["attempts"] = attempts
+	if o.AttemptHistory {
+		r["attempt_history"] = history
+	}
+}
+
+// shouldRetry reports whether a job ran and then failed.  Jobs which
+// could not be launched at all are not retried.
+func shouldRetry(r map[string]interface{}) bool {
+	if r["outcome"] == OUTCOME_TIMEOUT {
+		return true
+	}
+	_, ran := r["returncode"].(uint32)
+	return ran && r["outcome"] == OUTCOME_FAILURE
+}
+
+// recordExit records how a command exited, and whether that counts as
+// success.  Commands succeed when they exit with one of the success exit
+// codes, which default to zero.
+func recordExit(o *Options, r map[string]interface{}, stat syscall.WaitStatus) {
+	r["returncode"] = rawStatus(stat)
+	if stat.Signaled() {
+		r["signal"] = signalName(stat.Signal())
+		r["outcome"] = OUTCOME_FAILURE
+		addError(r, fmt.Sprintf("killed by %s", signalName(stat.Signal())))
+		return
+	}
+	code := stat.ExitStatus()
+	r["exit_code"] = code
+	if !successExit(o, code) {
+		r["outcome"] = OUTCOME_FAILURE
+		addError(r, fmt.Sprintf("exited with status %d", code))
+		return
+	}
+	r["outcome"] = OUTCOME_SUCCESS
+}
+
+// recordUsage records the CPU time and peak memory used by a command
+// and the descendants it waited for.
+func recordUsage(r map[string]interface{}, ps *os.ProcessState) {
+	r["user_cpu_ms"] = ps.UserTime().Milliseconds()
+	r["sys_cpu_ms"] = ps.SystemTime().Milliseconds()
+	if rss, ok := maxRssBytes(ps); ok {
+		r["max_rss_bytes"] = rss
+	}
+}
+
+// successExit reports whether an exit code counts as success.
+func successExit(o *Options, code int) bool {
+	if len(o.SuccessExitCodes) == 0 {
+		return code == 0
+	}
+	for _, c := range o.SuccessExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// addError adds a message to a result's error, keeping any already there.
+func addError(r map[string]interface{}, msg string) {
+	if err, ok := r["error"]; ok {
+		r["error"] = fmt.Sprintf("%s; %s", err, msg)
+	} else {
+		r["error"] = msg
+	}
+}
+
+// attemptRecord extracts the per-attempt fields of a result.
+func attemptRecord(r map[string]interface{}) map[string]interface{} {
+	h := map[string]interface{}{}
+	for _, k := range []string{"returncode", "exit_code", "signal", "stdout", "stderr", "outcome", "error", "duration_ms", "user_cpu_ms", "sys_cpu_ms", "max_rss_bytes", "started_at", "finished_at"} {
+		if v, ok := r[k]; ok {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+// dryRunJob expands everything needed to run a job and reports it
+// without running anything.
+func dryRunJob(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) map[string]interface{} {
+	r := skippedResult(job, "dry run")
+	r["command"] = renderCommand(o, cmd, job, meta)
+	env, err := renderEnv(o, cmd, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		r["outcome"] = OUTCOME_FAILURE
+		return r
+	}
+	vars := map[string]string{}
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		vars[parts[0]] = parts[1]
+	}
+	r["env"] = vars
+	if cmd.Cwd != nil {
+		r["cwd"] = render(cmd.Cwd, job, meta)
+	} else if cwd, err := os.Getwd(); err == nil {
+		r["cwd"] = cwd
+	}
+	if cmd.DockerImage != nil {
+		r["docker_image"] = render(cmd.DockerImage, job, meta)
+	}
+	if cmd.RenderTo != nil {
+		delete(r, "command")
+		r["path"] = render(cmd.RenderTo, job, meta)
+	}
+	return r
+}
+
+// renderTags expands the tags for a record.
+func renderTags(cmd *CommandTemplate, job interface{}, meta map[string]interface{}) map[string]interface{} {
+	tags := map[string]interface{}{}
+	for _, t := range cmd.Tags {
+		tags[t.Name] = render(t.Value, job, meta)
+	}
+	return tags
+}
+
+// skippedResult is the result for a record whose command never runs.
+func skippedResult(job interface{}, reason string) map[string]interface{} {
+	r := map[string]interface{}{}
+	r["e"] = job
+	r["command"] = []string{}
+	r["returncode"] = RETURNCODE_FAILURE
+	r["stdout"] = ""
+	r["stderr"] = ""
+	r["outcome"] = OUTCOME_SKIPPED
+	if reason != "" {
+		r["reason"] = reason
+	}
+	return r
+}
+
+func runJob(ctx context.Context, o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	if o.Timings {
+		defer recordTimings(r, time.Now())
+	}
+	r["e"] = job
+	args := renderCommand(o, cmd, job, meta)
+	r["command"] = args
+	r["returncode"] = RETURNCODE_FAILURE
+	r["stdout"] = ""
+	r["stderr"] = ""
+	r["outcome"] = OUTCOME_FAILURE
+	if ctx.Err() != nil {
+		r["error"] = "cancelled"
+		return r
+	}
+	if !o.HTTP {
+		if err := checkProgram(o, cmd, job, meta); err != nil {
+			r["error"] = err.Error()
+			return r
+		}
+	}
+	if o.K8s {
+		return runK8sJob(ctx, o, cmd, job, meta, args, r)
+	}
+	if o.HTTP {
+		return runHTTPJob(ctx, o, cmd, job, meta, args, r)
+	}
+	if cmd.RenderTo != nil {
+		return runRenderJob(cmd, job, meta, r)
+	}
+	var docker *dockerRun
+	if cmd.DockerImage != nil {
+		var err error
+		docker, err = newDockerRun(o, cmd, job, meta)
+		if err != nil {
+			r["error"] = err.Error()
+			return r
+		}
+		defer docker.cleanup()
+		args = docker.wrap(args)
+		r["command"] = args
+	}
+	// Sandboxed commands are looked up inside the sandbox.
+	prog := args[0]
+	if o.Sandbox == "" {
+		var err error
+		prog, err = exec.LookPath(args[0])
+		if err != nil {
+			r["error"] = fmt.Sprintf("cannot locate command %s: %s", args[0], err)
+			return r
+		}
+	}
+	if o.Debug {
+		r["prog"] = prog
+	}
+	timeout, err := jobTimeout(o, job)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	jobCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cancelIdle := func() {}
+	if o.InactivityTimeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithCancel(jobCtx)
+		defer cancel()
+		cancelIdle = cancel
+	}
+	// Timed out commands are sent the kill signal, and on shutdown they
+	// are sent SIGTERM.  Either way they are killed if they are still
+	// running after the delay.
+	c := exec.CommandContext(jobCtx, prog)
+	c.Args = args
+	c.SysProcAttr = processGroup(o)
+	cgroup, err := openJobCgroup(o, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	defer func() {
+		if err := cgroup.Close(); err != nil {
+			logger(o).Warn("cannot clean up cgroup", "seq", meta["_seq"], "error", err.Error())
+		}
+	}()
+	c.SysProcAttr = cgroup.attach(c.SysProcAttr)
+	runUser, err := jobUser(o, job)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	c.SysProcAttr, err = runAs(c.SysProcAttr, runUser, o.Group)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	if runUser != "" {
+		r["user"] = runUser
+	}
+	signal := func(sig syscall.Signal) error {
+		if docker != nil && docker.kill(sig) == nil {
+			return nil
+		}
+		return signalProcess(o, c.Process, sig)
+	}
+	killSignal := o.KillSignal
+	if killSignal == 0 {
+		killSignal = syscall.SIGKILL
+	}
+	killAfter := o.KillAfter
+	if killAfter == 0 {
+		killAfter = o.GracePeriod
+	}
+	c.Cancel = func() error {
+		sig, delay := killSignal, killAfter
+		if ctx.Err() != nil {
+			sig, delay = syscall.SIGTERM, o.GracePeriod
+		}
+		if sig != syscall.SIGKILL {
+			time.AfterFunc(delay, func() { signal(syscall.SIGKILL) })
+		}
+		return signal(sig)
+	}
+	// Wait gives up on the command's output once both delays have passed.
+	c.WaitDelay = max(o.GracePeriod, killAfter)
+	stdin, err := jobStdin(o, cmd, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	if f, ok := stdin.(io.Closer); ok {
+		defer f.Close()
+	}
+	c.Stdin = stdin
+	env, err := renderEnv(o, cmd, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+	if cmd.Cwd != nil && (docker != nil || o.Sandbox != "") {
+		// The working directory is inside the container or sandbox.
+		r["cwd"] = render(cmd.Cwd, job, meta)
+	} else if cmd.Cwd != nil {
+		c.Dir = render(cmd.Cwd, job, meta)
+		r["cwd"] = c.Dir
+		info, err := os.Stat(c.Dir)
+		if err != nil {
+			r["error"] = fmt.Sprintf("cannot use working directory %s: %s", c.Dir, err)
+			return r
+		}
+		if !info.IsDir() {
+			r["error"] = fmt.Sprintf("working directory %s is not a directory", c.Dir)
+			return r
+		}
+	}
+	if o.Sandbox != "" {
+		cwd, _ := r["cwd"].(string)
+		if err := sandboxCommand(o, c, cwd); err != nil {
+			r["error"] = err.Error()
+			return r
+		}
+	}
+	stdout, err := newOutputCapture("stdout", cmd.StdoutFile, o.MaxOutputBytes, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	defer stdout.Close()
+	stderr, err := newOutputCapture("stderr", cmd.StderrFile, o.MaxOutputBytes, job, meta)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	defer stderr.Close()
+	if emit := outputEvents(ctx); emit != nil {
+		stdout.lines = append(stdout.lines, &lineEvents{emit: func(line string) { emit("stdout", line) }})
+		stderr.lines = append(stderr.lines, &lineEvents{emit: func(line string) { emit("stderr", line) }})
+	}
+	if o.Passthrough {
+		stdout.lines = append(stdout.lines, passthroughLines(os.Stdout))
+		stderr.lines = append(stderr.lines, passthroughLines(os.Stderr))
+	}
+	if o.Tee {
+		stdout.lines = append(stdout.lines, teeLines(os.Stderr, teeTag(cmd, job, meta, "stdout")))
+		stderr.lines = append(stderr.lines, teeLines(os.Stderr, teeTag(cmd, job, meta, "stderr")))
+	}
+	outRdr, err := c.StdoutPipe()
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot construct stdout: %s", err)
+		return r
+	}
+	errRdr, err := c.StderrPipe()
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot construct stderr: %s", err)
+		return r
+	}
+	start := time.Now()
+	err = c.Start()
+	if err != nil {
+		r["error"] = fmt.Sprintf("failed to launch cmd: %s", err)
+		return r
+	}
+	if err := setPriority(o, c.Process.Pid); err != nil {
+		logger(o).Warn("cannot lower priority", "seq", meta["_seq"], "error", err.Error())
+	}
+	idle := watchInactivity(o.InactivityTimeout, cancelIdle)
+	stdout.idle = idle
+	stderr.idle = idle
+	outDone := stdout.collect(outRdr)
+	errDone := stderr.collect(errRdr)
+	<-outDone
+	<-errDone
+	stdout.record(r)
+	stderr.record(r)
+	if stdout.err != nil {
+		r["error"] = fmt.Sprintf("stdout: %s", stdout.err.Error())
+	}
+	if stderr.err != nil {
+		addError(r, fmt.Sprintf("stderr: %s", stderr.err.Error()))
+	}
+	c.Wait()
+	idled := idle.stop()
+	r["duration_ms"] = time.Since(start).Milliseconds()
+	if docker != nil {
+		docker.finish(r)
+	}
+	stat := c.ProcessState.Sys().(syscall.WaitStatus)
+	if !o.NoProcessGroup && (jobCtx.Err() != nil || rawStatus(stat) != 0) {
+		// Clean up descendants left behind by a failed command.
+		killProcessGroup(c.Process.Pid)
+	}
+	recordExit(o, r, stat)
+	recordUsage(r, c.ProcessState)
+	cgroup.finish(r)
+	if ctx.Err() != nil {
+		r["error"] = "cancelled"
+		r["outcome"] = OUTCOME_FAILURE
+		return r
+	}
+	if jobCtx.Err() == context.DeadlineExceeded {
+		r["error"] = fmt.Sprintf("killed after timeout of %s", timeout)
+		r["outcome"] = OUTCOME_TIMEOUT
+	} else if idled {
+		r["error"] = fmt.Sprintf("killed after no output for %s", o.InactivityTimeout)
+		r["outcome"] = OUTCOME_TIMEOUT
+		r["reason"] = "inactivity"
+	}
+	return r
+}
+
+// recordTimings records when a job started and finished.  Jobs which
+// never launched a command get a duration as well.
+func recordTimings(r map[string]interface{}, started time.Time) {
+	finished := time.Now()
+	r["started_at"] = started.UTC().Format(time.RFC3339Nano)
+	r["finished_at"] = finished.UTC().Format(time.RFC3339Nano)
+	if _, ok := r["duration_ms"]; !ok {
+		r["duration_ms"] = finished.Sub(started).Milliseconds()
+	}
+}
+
+// jobStdin returns the reader supplying the child's stdin, or nil when
+// the child should get no input.  A file named by the stdin file
+// template must be closed by the caller.
+func jobStdin(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) (io.Reader, error) {
+	if cmd.StdinFile != nil {
+		f, err := os.Open(render(cmd.StdinFile, job, meta))
+		if err != nil {
+			return nil, fmt.Errorf("cannot open stdin file: %s", err)
+		}
+		return f, nil
+	}
+	if o.StdinJson {
+		v := job
+		if o.Batch > 0 {
+			// A batch is sent as the array of its records.
+			v = job.(map[string]interface{})["items"]
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode stdin: %s", err)
+		}
+		return bytes.NewReader(b), nil
+	}
+	if o.StdinField == "" {
+		return nil, nil
+	}
+	m, ok := job.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("stdin field %s requires an object record", o.StdinField)
+	}
+	v, ok := m[o.StdinField]
+	if !ok {
+		return nil, fmt.Errorf("stdin field %s is missing", o.StdinField)
+	}
+	if str, ok := v.(string); ok {
+		return strings.NewReader(str), nil
+	}
+	// Non-string values are passed along as JSON.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode stdin: %s", err)
+	}
+	return bytes.NewReader(b), nil
+}
+
+// sendsStdin reports whether commands are given stdin.
+func sendsStdin(o *Options) bool {
+	return o.StdinJson || o.StdinField != "" || o.StdinFile != ""
+}
+
+// jobTimeout returns the timeout for a single job.  A timeout field in
+// the input record takes precedence over the global timeout.  The field
+// may contain either a number of seconds or a duration string like "1m30s".
+func jobTimeout(o *Options, job interface{}) (time.Duration, error) {
+	if o.TimeoutField == "" {
+		return o.Timeout, nil
+	}
+	m, ok := job.(map[string]interface{})
+	if !ok {
+		return o.Timeout, nil
+	}
+	v, ok := m[o.TimeoutField]
+	if !ok {
+		return o.Timeout, nil
+	}
+	switch t := v.(type) {
+	case float64:
+		return time.Duration(t * float64(time.Second)), nil
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout in field %s: %s", o.TimeoutField, err)
+		}
+		return d, nil
+	}
+	return 0, fmt.Errorf("timeout field %s must be a number of seconds or a duration string", o.TimeoutField)
+}
+
+
+// renderCommand expands the command templates for a record.  In shell
+// mode the expanded words are joined into a single script, and string
+// values from the record are quoted so they cannot inject shell syntax.
+// With --escape shell they are quoted in every mode.
+func renderCommand(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) []string {
+	if cmd.Literal != nil {
+		return append([]string{}, cmd.Literal...)
+	}
+	if cmd.Raw != nil {
+		words := replaceCommand(o, cmd.Raw, job)
+		if !o.Shell {
+			return words
+		}
+		return shellCommand(o.ShellPath, strings.Join(words, " "))
+	}
+	if !quotesValues(o) {
+		return instantiateArgs(cmd.Args, job, meta)
+	}
+	quote := shellQuoter(o.ShellPath)
+	words := instantiateArgs(cmd.Args, shellQuoteValues(job, quote), shellQuoteMeta(meta, quote))
+	if !o.Shell {
+		return words
+	}
+	return shellCommand(o.ShellPath, strings.Join(words, " "))
+}
+
+// renderEnv returns the extra KEY=VALUE environment entries for a record.
+func renderEnv(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}) ([]string, error) {
+	env := []string{}
+	if o.EnvFromObject != "" {
+		obj := job
+		if o.EnvFromObject != "." {
+			m, ok := job.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("env field %s requires an object record", o.EnvFromObject)
+			}
+			obj = m[o.EnvFromObject]
+		}
+		fields, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("env field %s must contain an object", o.EnvFromObject)
+		}
+		for k, v := range fields {
+			value, ok := v.(string)
+			if !ok {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, fmt.Errorf("cannot encode env field %s: %s", k, err)
+				}
+				value = string(b)
+			}
+			env = append(env, k+"="+value)
+		}
+	}
+	env = append(env, secretsEnv(o)...)
+	// Explicit variables come last so they override exported fields.
+	for _, e := range cmd.Env {
+		env = append(env, e.Name+"="+render(e.Value, job, meta))
+	}
+	return env, nil
+}
+
+func instantiateArgs(cmd []*mustache.Template, params interface{}, meta map[string]interface{}) []string {
+	r := []string{}
+	for _, t := range(cmd) {
+		r = append(r, render(t, params, meta))
+	}
+	return r
+}
+
+// render expands a template against a record.  Names which the record
+// does not define are looked up in the job metadata.
+func render(t *mustache.Template, job interface{}, meta map[string]interface{}) string {
+	if meta == nil {
+		return resolveMarkers(t.Render(false, templateValue(job)))
+	}
+	return resolveMarkers(t.Render(false, templateValue(job), templateValue(meta)))
+}
+
+// jobMeta returns the reserved template context for a job.
+func jobMeta(seq int, worker int) map[string]interface{} {
+	env := map[string]interface{}{}
+	for _, e := range os.Environ() {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return map[string]interface{}{
+		"_env": env,
+		"_seq": seq,
+		"_worker": worker,
+		"_timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// parseCommandTemplate parses all of the templates in the options.
+func parseCommandTemplate(o *Options) (*CommandTemplate, error) {
+	o, err := withDelimiters(o)
+	if err != nil {
+		return nil, err
+	}
+	cmd := &CommandTemplate{}
+	if o.Replace != "" {
+		cmd.Raw = append([]string{}, o.Args...)
+	} else {
+		for _, arg := range(o.Args) {
+			t, err := parseMustache(arg)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse command template %s: %s", arg, err)
+			}
+			cmd.Args = append(cmd.Args, t)
+		}
+	}
+	if len(o.Args) > 0 {
+		if err := parseProgram(o, cmd, o.Args[0]); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range o.Env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("environment variable %s must have the form KEY=TEMPLATE", e)
+		}
+		t, err := parseMustache(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse environment template %s: %s", e, err)
+		}
+		cmd.Env = append(cmd.Env, EnvTemplate{Name: parts[0], Value: t})
+	}
+	if cmd.Cwd, err = parseOptionalTemplate("working directory", o.Cwd); err != nil {
+		return nil, err
+	}
+	if cmd.StdinFile, err = parseOptionalTemplate("stdin file", o.StdinFile); err != nil {
+		return nil, err
+	}
+	if cmd.StdoutFile, err = parseOptionalTemplate("stdout file", o.StdoutFile); err != nil {
+		return nil, err
+	}
+	if cmd.StderrFile, err = parseOptionalTemplate("stderr file", o.StderrFile); err != nil {
+		return nil, err
+	}
+	if cmd.Key, err = parseOptionalTemplate("key", o.Key); err != nil {
+		return nil, err
+	}
+	if cmd.Dedupe, err = parseOptionalTemplate("dedupe", o.DedupeKey); err != nil {
+		return nil, err
+	}
+	if cmd.GroupBy, err = parseOptionalTemplate("group", o.GroupBy); err != nil {
+		return nil, err
+	}
+	if cmd.DockerImage, err = parseOptionalTemplate("docker image", o.DockerImage); err != nil {
+		return nil, err
+	}
+	if cmd.DockerNetwork, err = parseOptionalTemplate("docker network", o.DockerNetwork); err != nil {
+		return nil, err
+	}
+	if cmd.K8sImage, err = parseOptionalTemplate("kubernetes image", o.K8sImage); err != nil {
+		return nil, err
+	}
+	if cmd.K8sNamespace, err = parseOptionalTemplate("kubernetes namespace", o.K8sNamespace); err != nil {
+		return nil, err
+	}
+	if cmd.K8sCpu, err = parseOptionalTemplate("kubernetes cpu", o.K8sCpu); err != nil {
+		return nil, err
+	}
+	if cmd.K8sMemory, err = parseOptionalTemplate("kubernetes memory", o.K8sMemory); err != nil {
+		return nil, err
+	}
+	if cmd.Body, err = parseOptionalTemplate("body", o.Body); err != nil {
+		return nil, err
+	}
+	if cmd.RenderTo, err = parseOptionalTemplate("render path", o.RenderTo); err != nil {
+		return nil, err
+	}
+	for _, h := range o.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("header %s must have the form NAME: TEMPLATE", h)
+		}
+		t, err := parseMustache(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse header template %s: %s", h, err)
+		}
+		cmd.Headers = append(cmd.Headers, EnvTemplate{Name: strings.TrimSpace(parts[0]), Value: t})
+	}
+	for _, l := range o.K8sLabels {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("label %s must have the form KEY=TEMPLATE", l)
+		}
+		t, err := parseMustache(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse label template %s: %s", l, err)
+		}
+		cmd.K8sLabels = append(cmd.K8sLabels, EnvTemplate{Name: parts[0], Value: t})
+	}
+	for _, tag := range o.Tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("tag %s must have the form NAME=TEMPLATE", tag)
+		}
+		t, err := parseMustache(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tag template %s: %s", tag, err)
+		}
+		cmd.Tags = append(cmd.Tags, EnvTemplate{Name: parts[0], Value: t})
+	}
+	for _, v := range o.DockerVolumes {
+		t, err := parseOptionalTemplate("docker volume", v)
+		if err != nil {
+			return nil, err
+		}
+		cmd.DockerVolumes = append(cmd.DockerVolumes, t)
+	}
+	if cmd.Then, err = parseSteps(o, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// parseOptionalTemplate parses a template which may be empty, in which
+// case the result is nil.
+func parseOptionalTemplate(what string, src string) (*mustache.Template, error) {
+	if src == "" {
+		return nil, nil
+	}
+	t, err := parseMustache(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s template %s: %s", what, src, err)
+	}
+	return t, nil
+}
+
+// CommandTemplate holds the parsed templates which are expanded for
+// each record.
+type CommandTemplate struct {
+	Args []*mustache.Template
+	Env []EnvTemplate
+	Cwd *mustache.Template
+	StdinFile *mustache.Template
+	StdoutFile *mustache.Template
+	StderrFile *mustache.Template
+	Key *mustache.Template
+	Dedupe *mustache.Template
+	GroupBy *mustache.Template
+	DockerImage *mustache.Template
+	DockerVolumes []*mustache.Template
+	DockerNetwork *mustache.Template
+	K8sImage *mustache.Template
+	K8sNamespace *mustache.Template
+	K8sCpu *mustache.Template
+	K8sMemory *mustache.Template
+	// K8sLabels, Headers, and Tags are name and value templates, like Env.
+	K8sLabels []EnvTemplate
+	Headers []EnvTemplate
+	Tags []EnvTemplate
+	Body *mustache.Template
+	// RenderTo is the path the body is written to instead of running a
+	// command.
+	RenderTo *mustache.Template
+	// Then holds the commands run in turn after Args succeeds.
+	Then []*CommandTemplate
+	// Raw is a command given with --replace, whose words have the
+	// placeholder substituted instead of being expanded as templates.
+	Raw []string
+	// Literal is a command run as it is instead of expanding Args.
+	Literal []string
+	// Program is the word of the command which names what runs, and
+	// Programs is its text outside of template tags, one of which it must
+	// expand to unless commands are allowed by name.
+	Program *mustache.Template
+	Programs []string
+}
+
+// literal returns the templates with a command which is run as it is.
+func (cmd *CommandTemplate) literal(command []string) *CommandTemplate {
+	c := *cmd
+	c.Literal = command
+	c.Then = nil
+	return &c
+}
+
+type EnvTemplate struct {
+	Name string
+	Value *mustache.Template
+}
@@ -0,0 +1,300 @@
+package jpar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jmyounker/mustache"
+)
+
+func parseCmd(t *testing.T, args ...string) *CommandTemplate {
+	cmd := &CommandTemplate{}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, parseTemplate(t, arg))
+	}
+	return cmd
+}
+
+func parseTemplate(t *testing.T, s string) *mustache.Template {
+	tmpl, err := parseMustache(s)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", s, err)
+	}
+	return tmpl
+}
+
+func TestJobTimeoutFromField(t *testing.T) {
+	o := &Options{Timeout: time.Second, TimeoutField: "t"}
+	cases := []struct {
+		job  interface{}
+		want time.Duration
+	}{
+		{map[string]interface{}{}, time.Second},
+		{map[string]interface{}{"t": 2.5}, 2500 * time.Millisecond},
+		{map[string]interface{}{"t": "1m"}, time.Minute},
+		{"not a map", time.Second},
+	}
+	for _, c := range cases {
+		got, err := jobTimeout(o, c.job)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %s", c.job, err)
+		}
+		if got != c.want {
+			t.Errorf("jobTimeout(%v) = %s, want %s", c.job, got, c.want)
+		}
+	}
+	if _, err := jobTimeout(o, map[string]interface{}{"t": true}); err == nil {
+		t.Error("expected error for boolean timeout field")
+	}
+}
+
+func TestRunJobTimeout(t *testing.T) {
+	o := &Options{Timeout: 100 * time.Millisecond}
+	r := runJob(context.Background(), o, parseCmd(t, "sleep", "{{s}}"), map[string]interface{}{"s": "5"}, nil)
+	if r["outcome"] != OUTCOME_TIMEOUT {
+		t.Errorf("expected outcome %s, got %v", OUTCOME_TIMEOUT, r["outcome"])
+	}
+	if r["duration_ms"].(int64) >= 5000 {
+		t.Errorf("process was not killed: ran for %dms", r["duration_ms"])
+	}
+}
+
+func TestRunJobKillSignal(t *testing.T) {
+	o := &Options{Timeout: 100 * time.Millisecond, KillSignal: syscall.SIGTERM}
+	r := runJob(context.Background(), o, parseCmd(t, "sleep", "5"), nil, nil)
+	if r["outcome"] != OUTCOME_TIMEOUT || r["signal"] != "SIGTERM" {
+		t.Errorf("expected TIMEOUT by SIGTERM, got %v by %v", r["outcome"], r["signal"])
+	}
+	// The command ignores SIGTERM, so it is killed after the delay.
+	o.KillAfter = 200 * time.Millisecond
+	r = runJob(context.Background(), o, parseCmd(t, "sh", "-c", "trap '' TERM; exec sleep 5"), nil, nil)
+	if r["signal"] != "SIGKILL" {
+		t.Errorf("expected SIGKILL, got %v", r["signal"])
+	}
+	if d := r["duration_ms"].(int64); d < 300 || d >= 5000 {
+		t.Errorf("expected kill after 300ms, ran for %dms", d)
+	}
+}
+
+func TestRunJobExit(t *testing.T) {
+	cases := []struct {
+		script string
+		success []int
+		outcome string
+		code interface{}
+		signal interface{}
+	}{
+		{"exit 0", nil, OUTCOME_SUCCESS, 0, nil},
+		{"exit 3", nil, OUTCOME_FAILURE, 3, nil},
+		{"exit 2", []int{0, 2}, OUTCOME_SUCCESS, 2, nil},
+		{"exit 0", []int{2}, OUTCOME_FAILURE, 0, nil},
+		{"kill -SEGV $$", []int{0, 139}, OUTCOME_FAILURE, nil, "SIGSEGV"},
+	}
+	for _, c := range cases {
+		o := &Options{SuccessExitCodes: c.success}
+		r := runJob(context.Background(), o, parseCmd(t, "sh", "-c", c.script), nil, nil)
+		if r["outcome"] != c.outcome || r["exit_code"] != c.code || r["signal"] != c.signal {
+			t.Errorf("%q with %v: got outcome %v, exit_code %v, signal %v", c.script, c.success, r["outcome"], r["exit_code"], r["signal"])
+		}
+	}
+}
+
+func TestRunJobTimeoutKillsDescendants(t *testing.T) {
+	o := &Options{Timeout: 100 * time.Millisecond}
+	// The background sleep holds stdout open after the shell is killed.
+	r := runJob(context.Background(), o, parseCmd(t, "sh", "-c", "sleep 5 & wait"), nil, nil)
+	if r["outcome"] != OUTCOME_TIMEOUT {
+		t.Errorf("expected outcome %s, got %v", OUTCOME_TIMEOUT, r["outcome"])
+	}
+	if r["duration_ms"].(int64) >= 5000 {
+		t.Errorf("descendants were not killed: ran for %dms", r["duration_ms"])
+	}
+}
+
+func TestRunJobTimings(t *testing.T) {
+	o := &Options{Timings: true}
+	r := runJob(context.Background(), o, parseCmd(t, "sleep", "0.1"), nil, nil)
+	started, err := time.Parse(time.RFC3339Nano, r["started_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, err := time.Parse(time.RFC3339Nano, r["finished_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.Sub(started) < 100*time.Millisecond {
+		t.Errorf("expected at least 100ms between %s and %s", started, finished)
+	}
+	r = runJob(context.Background(), o, parseCmd(t, "/no/such/command"), nil, nil)
+	if _, ok := r["duration_ms"]; !ok {
+		t.Errorf("expected a duration for a job which never launched: %v", r)
+	}
+}
+
+func TestRunJobUsage(t *testing.T) {
+	r := runJob(context.Background(), &Options{}, parseCmd(t, "sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done"), nil, nil)
+	user, ok := r["user_cpu_ms"].(int64)
+	if !ok || user < 0 {
+		t.Errorf("expected user CPU time, got %v", r)
+	}
+	if _, ok := r["sys_cpu_ms"].(int64); !ok {
+		t.Errorf("expected system CPU time, got %v", r)
+	}
+	if rss, ok := r["max_rss_bytes"].(int64); !ok || rss < 1024 {
+		t.Errorf("expected the peak memory in bytes, got %v", r["max_rss_bytes"])
+	}
+}
+
+func TestRunJobWithRetries(t *testing.T) {
+	o := &Options{Retries: 2, RetryDelay: time.Millisecond, RetryBackoff: 2, AttemptHistory: true}
+	r := runJobWithRetries(context.Background(), o, parseCmd(t, "false"), map[string]interface{}{}, nil)
+	if r["attempts"] != 3 {
+		t.Errorf("expected 3 attempts, got %v", r["attempts"])
+	}
+	if h := r["attempt_history"].([]interface{}); len(h) != 3 {
+		t.Errorf("expected 3 history entries, got %d", len(h))
+	}
+	r = runJobWithRetries(context.Background(), o, parseCmd(t, "true"), map[string]interface{}{}, nil)
+	if r["attempts"] != 1 {
+		t.Errorf("expected a single attempt, got %v", r["attempts"])
+	}
+}
+
+func TestRunJobStdin(t *testing.T) {
+	job := map[string]interface{}{"payload": "hello", "n": 1.0}
+	r := runJob(context.Background(), &Options{StdinJson: true}, parseCmd(t, "cat"), job, nil)
+	if r["stdout"] != `{"n":1,"payload":"hello"}` {
+		t.Errorf("unexpected stdout for --stdin-json: %q", r["stdout"])
+	}
+	r = runJob(context.Background(), &Options{StdinField: "payload"}, parseCmd(t, "cat"), job, nil)
+	if r["stdout"] != "hello" {
+		t.Errorf("unexpected stdout for --stdin-field: %q", r["stdout"])
+	}
+	r = runJob(context.Background(), &Options{StdinField: "missing"}, parseCmd(t, "cat"), job, nil)
+	if r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected failure for missing stdin field, got %v", r["outcome"])
+	}
+	path := filepath.Join(t.TempDir(), "payload")
+	os.WriteFile(path, []byte("from a file"), 0644)
+	cmd := parseCmd(t, "cat")
+	cmd.StdinFile = parseTemplate(t, "{{path}}")
+	r = runJob(context.Background(), &Options{}, cmd, map[string]interface{}{"path": path}, nil)
+	if r["stdout"] != "from a file" {
+		t.Errorf("unexpected stdout for --stdin-file: %q", r["stdout"])
+	}
+	r = runJob(context.Background(), &Options{}, cmd, map[string]interface{}{"path": path + ".missing"}, nil)
+	if r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected failure for a missing stdin file, got %v", r["outcome"])
+	}
+}
+
+func TestRunJobShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	o := &Options{GracePeriod: time.Second}
+	r := runJob(ctx, o, parseCmd(t, "sleep", "5"), map[string]interface{}{}, nil)
+	if r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected outcome %s, got %v", OUTCOME_FAILURE, r["outcome"])
+	}
+	if r["duration_ms"].(int64) >= 1000 {
+		t.Errorf("SIGTERM was not delivered: ran for %dms", r["duration_ms"])
+	}
+}
+
+func TestRunJobShell(t *testing.T) {
+	o := &Options{Shell: true, ShellPath: DEFAULT_SHELL}
+	job := map[string]interface{}{"msg": "a; echo injected"}
+	r := runJob(context.Background(), o, parseCmd(t, "echo {{msg}} | tr a-z A-Z"), job, nil)
+	if r["stdout"] != "A; ECHO INJECTED\n" {
+		t.Errorf("unexpected stdout %q", r["stdout"])
+	}
+}
+
+func TestRunJobEnv(t *testing.T) {
+	o := &Options{EnvFromObject: "vars"}
+	cmd := parseCmd(t, "sh", "-c", "echo $A $B $C")
+	cmd.Env = []EnvTemplate{{Name: "C", Value: parseTemplate(t, "c-{{name}}")}}
+	job := map[string]interface{}{
+		"name": "x y",
+		"vars": map[string]interface{}{"A": "a b", "B": 2.0},
+	}
+	r := runJob(context.Background(), o, cmd, job, nil)
+	if r["stdout"] != "a b 2 c-x y\n" {
+		t.Errorf("unexpected stdout %q", r["stdout"])
+	}
+}
+
+func TestDryRunJob(t *testing.T) {
+	o := &Options{}
+	cmd := parseCmd(t, "rm", "{{f}}")
+	cmd.Env = []EnvTemplate{{Name: "F", Value: parseTemplate(t, "{{f}}")}}
+	r := dryRunJob(o, cmd, map[string]interface{}{"f": "/nonexistent"}, nil)
+	if r["outcome"] != OUTCOME_SKIPPED {
+		t.Errorf("expected outcome %s, got %v", OUTCOME_SKIPPED, r["outcome"])
+	}
+	if got := r["command"].([]string); len(got) != 2 || got[1] != "/nonexistent" {
+		t.Errorf("unexpected command %v", got)
+	}
+	if r["env"].(map[string]string)["F"] != "/nonexistent" {
+		t.Errorf("unexpected env %v", r["env"])
+	}
+}
+
+func TestRunJobCwd(t *testing.T) {
+	cmd := parseCmd(t, "pwd")
+	cmd.Cwd = parseTemplate(t, "{{dir}}")
+	dir := t.TempDir()
+	r := runJob(context.Background(), &Options{}, cmd, map[string]interface{}{"dir": dir}, nil)
+	if r["stdout"] != dir+"\n" || r["cwd"] != dir {
+		t.Errorf("command did not run in %s: %v", dir, r)
+	}
+	r = runJob(context.Background(), &Options{}, cmd, map[string]interface{}{"dir": dir + "/missing"}, nil)
+	if r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected outcome %s for missing directory, got %v", OUTCOME_FAILURE, r["outcome"])
+	}
+}
+
+func TestRenderMeta(t *testing.T) {
+	cmd := parseCmd(t, "{{name}}", "{{_seq}}", "{{_worker}}", "{{_env.JPAR_TEST}}")
+	t.Setenv("JPAR_TEST", "x")
+	args := renderCommand(&Options{}, cmd, map[string]interface{}{"name": "n"}, jobMeta(3, 1))
+	if strings.Join(args, " ") != "n 3 1 x" {
+		t.Errorf("unexpected command %v", args)
+	}
+}
+
+func TestRunJobOutputFiles(t *testing.T) {
+	dir := t.TempDir()
+	cmd := parseCmd(t, "sh", "-c", "echo out; echo err >&2")
+	cmd.StdoutFile = parseTemplate(t, dir+"/{{name}}.out")
+	r := runJob(context.Background(), &Options{}, cmd, map[string]interface{}{"name": "a"}, nil)
+	if r["stdout_file"] != dir+"/a.out" || r["stdout_bytes"] != int64(4) {
+		t.Errorf("unexpected stdout capture %v", r)
+	}
+	if _, ok := r["stdout"]; ok {
+		t.Error("stdout should not be embedded when written to a file")
+	}
+	if r["stderr"] != "err\n" {
+		t.Errorf("unexpected stderr %q", r["stderr"])
+	}
+	b, err := os.ReadFile(dir + "/a.out")
+	if err != nil || string(b) != "out\n" {
+		t.Errorf("unexpected file contents %q: %v", b, err)
+	}
+}
+
+func TestRunJobMaxOutputBytes(t *testing.T) {
+	o := &Options{MaxOutputBytes: 5}
+	r := runJob(context.Background(), o, parseCmd(t, "seq", "1000"), map[string]interface{}{}, nil)
+	if r["stdout"] != "1\n2\n3" || r["stdout_truncated"] != true {
+		t.Errorf("unexpected truncated output %q, %v", r["stdout"], r["stdout_truncated"])
+	}
+	if _, ok := r["stderr_truncated"]; ok {
+		t.Error("stderr should not be marked as truncated")
+	}
+}
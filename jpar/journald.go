@@ -0,0 +1,77 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const JOURNALD_SOCKET = "/run/systemd/journal/socket"
+
+// journaldResults sends results to the systemd journal with its native
+// protocol, so that each field of a result can be queried, as in
+// journalctl JPAR_OUTCOME=FAILURE.
+type journaldResults struct {
+	conn net.Conn
+}
+
+func openJournald() (resultLog, error) {
+	return dialJournald(JOURNALD_SOCKET)
+}
+
+func dialJournald(path string) (*journaldResults, error) {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to journald: %s", err)
+	}
+	return &journaldResults{conn}, nil
+}
+
+func (j *journaldResults) log(seq int, r map[string]interface{}) error {
+	fields := resultFields(seq, r)
+	priority := "6"
+	if jobFailed(r) {
+		priority = "3"
+	}
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", resultMessage(fields))
+	writeJournalField(&b, "PRIORITY", priority)
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", "jpar")
+	for _, f := range fields {
+		writeJournalField(&b, "JPAR_"+journalName(f.name), f.value)
+	}
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *journaldResults) Close() error {
+	return j.conn.Close()
+}
+
+// writeJournalField writes NAME=VALUE on a line, or, for values holding
+// newlines, the name followed by the length of the value and the value.
+func writeJournalField(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalName turns a field name into a journal field name, which holds
+// only upper case letters, digits, and underscores.
+func journalName(name string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			return c
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,416 @@
+// Package jpar runs a command in parallel for every record of a JSON
+// stream, and produces a JSON result record for each command.
+package jpar
+
+import (
+	"io"
+	"log/slog"
+	"syscall"
+	"time"
+)
+
+const OUTCOME_SUCCESS string = "SUCCESS"
+const OUTCOME_FAILURE string = "FAILURE"
+const OUTCOME_TIMEOUT string = "TIMEOUT"
+const OUTCOME_SKIPPED string = "SKIPPED"
+const OUTCOME_CANCELLED string = "CANCELLED"
+
+const OUTPUT_FORMAT_NDJSON string = "ndjson"
+const OUTPUT_FORMAT_CONCAT string = "concat"
+const OUTPUT_FORMAT_PRETTY string = "pretty"
+const OUTPUT_FORMAT_CSV string = "csv"
+const OUTPUT_FORMAT_TAP string = "tap"
+
+const ONLY_FAILURES string = "failures"
+const ONLY_SUCCESSES string = "successes"
+
+const FAILURES_FORMAT_ORIGINAL string = "original"
+const FAILURES_FORMAT_RESULT string = "result"
+
+const ECHO_INPUT_NEVER string = "never"
+const ECHO_INPUT_ALWAYS string = "always"
+const ECHO_INPUT_ON_FAILURE string = "on-failure"
+
+const MISSING_VAR_ERROR string = "error"
+const MISSING_VAR_EMPTY string = "empty"
+const MISSING_VAR_SKIP string = "skip"
+
+const ESCAPE_NONE string = "none"
+const ESCAPE_SHELL string = "shell"
+const ESCAPE_STRICT string = "strict"
+
+const PARSE_STDOUT_NONE string = "none"
+const PARSE_STDOUT_JSON string = "json"
+
+const INPUT_FORMAT_JSON string = "json"
+const INPUT_FORMAT_JSONL string = "jsonl"
+const INPUT_FORMAT_LINES string = "lines"
+const INPUT_FORMAT_CSV string = "csv"
+const INPUT_FORMAT_TSV string = "tsv"
+
+const RETURNCODE_FAILURE = -4242
+
+// Like GNU parallel, the exit status counts failed jobs up to
+// EXIT_MAX_FAILURES, and is EXIT_MAX_FAILURES+1 when even more failed.
+const EXIT_MAX_FAILURES = 100
+
+const EXIT_STATUS_ANY_FAILURE string = "any-failure"
+const EXIT_STATUS_ALL_FAILURE string = "all-failure"
+const EXIT_STATUS_NEVER string = "never"
+
+const DEFAULT_PARALLELISM = 8
+const DEFAULT_RETRY_DELAY = time.Second
+const DEFAULT_RETRY_BACKOFF = 2.0
+const DEFAULT_REORDER_BUFFER = 1000
+const DEFAULT_GRACE_PERIOD = 10 * time.Second
+const DEFAULT_LINE_KEY = "line"
+const DEFAULT_DOCKER = "docker"
+const DEFAULT_ID_FIELD = "id"
+const DEFAULT_DEPENDS_ON_FIELD = "depends_on"
+
+// Options configures a Runner.
+type Options struct {
+	Parallelism int
+	Timeout time.Duration
+	TimeoutField string
+	// InactivityTimeout kills commands which write nothing to stdout or
+	// stderr for that long.
+	InactivityTimeout time.Duration
+	// Inputs are files or globs to read records from instead of the
+	// input reader, which is named "-".
+	Inputs []string
+	InputFormat string
+	// Skip ignores the first records of the input.  Sample then keeps a
+	// random fraction of the remaining records, chosen with Seed, and Head
+	// stops the input once that many records have been kept.  Zero values
+	// keep every record.
+	Skip int
+	Sample float64
+	Seed int64
+	Head int
+	// StrictInput aborts the run when input cannot be parsed, instead of
+	// producing a failed result for it.
+	StrictInput bool
+	// Delimiter, NoHeader, and Quoting apply to csv and tsv input.  A
+	// zero Delimiter or empty Quoting selects the format's default.
+	Delimiter rune
+	NoHeader bool
+	Quoting string
+	// LineKey and NullSeparated apply to lines input.
+	LineKey string
+	NullSeparated bool
+	OutputFormat string
+	// ParseStdout is json to decode the stdout of jobs into result, or
+	// none to keep it as a string.
+	ParseStdout string
+	// OutputFields selects the fields written for each result, and
+	// Rename renames them with OLD=NEW.
+	OutputFields []string
+	Rename []string
+	// EchoInput decides which results include their input record as e,
+	// and EchoFields limits it to some of the record's fields.
+	EchoInput string
+	EchoFields []string
+	Retries int
+	RetryDelay time.Duration
+	RetryBackoff float64
+	AttemptHistory bool
+	// Repeat runs each job this many times in a row, and adds statistics
+	// about the runs to its result.
+	Repeat int
+	// Timings records when each job started and finished.
+	Timings bool
+	RequeueFailures bool
+	StdinJson bool
+	StdinField string
+	// StdinFile is a template for the path of a file sent to each
+	// command's stdin.
+	StdinFile string
+	KeepOrder bool
+	ReorderBuffer int
+	GracePeriod time.Duration
+	// NoProcessGroup leaves commands in jpar's process group.  Otherwise
+	// each command leads its own group, and signals reach its descendants.
+	NoProcessGroup bool
+	// Nice and IoNice lower the CPU and I/O priority of commands.  IoNice
+	// is written CLASS[:LEVEL], like ionice.
+	Nice int
+	IoNice string
+	// CgroupMem and CgroupCpu confine each command and its descendants
+	// to a cgroup with a memory limit, such as 512M, and a number of
+	// CPUs.  Cgroups are only supported on Linux.
+	CgroupMem string
+	CgroupCpu string
+	// User and Group run commands as another user, given by name or
+	// number, which requires jpar to run as root.  The group defaults to
+	// the user's primary group.  A user named in UserField of a record
+	// takes precedence over User.
+	User string
+	Group string
+	UserField string
+	// Sandbox runs each command with its root changed to this directory,
+	// in a mount namespace of its own where only the default binds and
+	// SandboxBinds, written SRC[:DST][:ro], are visible.  Sandboxes are
+	// only supported on Linux.
+	Sandbox string
+	SandboxBinds []string
+	// AllowCmds are the only commands which may run, by name or path.
+	// Without them, a command whose program comes from a record rather
+	// than the template is refused.
+	AllowCmds []string
+	// Secrets are given as NAME=env:VAR or NAME=file:PATH.  Templates
+	// see them as _secrets.NAME and commands get them as the environment
+	// variable NAME, but their values are masked in results and logs.
+	Secrets []string
+	// Redact masks text matching these regular expressions in results
+	// and logs, and RedactFields masks the values of these fields of each
+	// record in its result.
+	Redact []string
+	RedactFields []string
+	// Coprocess starts the command once per worker and sends it one
+	// record per line on stdin, reading one line of reply per record.
+	Coprocess bool
+	// Passthrough copies the output of commands, line by line, to
+	// jpar's own stdout and stderr.
+	Passthrough bool
+	// Tee copies the output of commands, line by line, to jpar's stderr
+	// while it is still captured.  Each line is tagged with its job.
+	Tee bool
+	// StreamOutput writes each line of output as an event while jobs run.
+	StreamOutput bool
+	// Heartbeat is the interval at which an event is written for each
+	// job which is still running.
+	Heartbeat time.Duration
+	// KillSignal is sent to commands which time out.  Unless it is
+	// SIGKILL, they are killed if they are still running KillAfter
+	// later, or after the grace period when KillAfter is zero.
+	KillSignal syscall.Signal
+	KillAfter time.Duration
+	// SuccessExitCodes are the exit codes counted as success.  Only zero
+	// is when it is empty.
+	SuccessExitCodes []int
+	// LeftDelim and RightDelim enclose the tags in templates.
+	LeftDelim string
+	RightDelim string
+	// Replace is a placeholder, such as {}, which is replaced in the
+	// words of the command instead of expanding them as templates.
+	Replace string
+	// ReplaceField is the field which replaces the placeholder, instead of
+	// the whole record.
+	ReplaceField string
+	// MissingVar decides what happens to records which do not define the
+	// variables used by the templates: they fail with error, are skipped
+	// with skip, or expand them to nothing with empty.
+	MissingVar string
+	// Escape decides how values from records are made safe.  With shell
+	// they are quoted as shell words even without Shell, and with strict
+	// records holding control characters or shell metacharacters fail
+	// without running.  With none they are left alone, except in shell
+	// mode.
+	Escape string
+	Filter string
+	// OutputFilter is a jq expression applied to each result before it
+	// is written, which may produce any number of values.
+	OutputFilter string
+	// Matrix expands each record with array fields into a record for
+	// every combination of their elements, after Filter is applied.
+	Matrix bool
+	// When is a jq expression.  Records for which it is false or null are
+	// skipped without running a command.
+	When string
+	EmitSkipped bool
+	Shell bool
+	ShellPath string
+	Env []string
+	EnvFromObject string
+	DryRun bool
+	HaltOnError bool
+	ExitStatus string
+	Rate time.Duration
+	RateBurst int
+	Cwd string
+	StdoutFile string
+	StderrFile string
+	MaxOutputBytes int64
+	// Key identifies records.  It defaults to a hash of the record.
+	Key string
+	StateFile string
+	// Dedupe skips records already seen in the input.  Records are the
+	// same when DedupeKey expands identically, or when they are equal if
+	// DedupeKey is empty.
+	Dedupe bool
+	DedupeKey string
+	// Batch groups up to Batch records into each job, which sees them
+	// as the array items.  A partial batch is run once BatchTimeout has
+	// passed since its first record.
+	Batch int
+	BatchTimeout time.Duration
+	// QueueSize is how many jobs may wait for a worker.  When it is zero
+	// records are only read as workers become free.
+	QueueSize int
+	// PriorityField names a numeric field.  Records with higher values
+	// are run first.
+	PriorityField string
+	// Dag holds each job until the jobs named in its DependsOnField have
+	// succeeded.  Jobs are named by their IdField.
+	Dag bool
+	IdField string
+	DependsOnField string
+	// GroupBy limits records which expand to the same group key to
+	// GroupParallelism running jobs.
+	GroupBy string
+	GroupParallelism int
+	// DockerImage runs each command in a container of this image.
+	DockerImage string
+	DockerVolumes []string
+	DockerNetwork string
+	// DockerEnv names host environment variables passed to containers.
+	DockerEnv []string
+	DockerPath string
+	// HTTP performs a request for each record instead of running a
+	// command.  The command is a method and a URL, Headers are written
+	// "NAME: TEMPLATE", and Body is a template for the request body.
+	HTTP bool
+	Headers []string
+	Body string
+	// RenderTo is a template for a path which the body is written to
+	// for each record, instead of running a command.
+	RenderTo string
+	// K8s submits each command as a Kubernetes Job running K8sImage.
+	K8s bool
+	K8sImage string
+	K8sNamespace string
+	// K8sLabels are KEY=TEMPLATE labels for the Job and its pod.
+	K8sLabels []string
+	// K8sCpu and K8sMemory are resource quantities such as 500m or 1Gi.
+	K8sCpu string
+	K8sMemory string
+	KubectlPath string
+	// Tags are NAME=TEMPLATE labels added to each result.
+	Tags []string
+	// Only limits the results written to failures or successes.
+	Only string
+	// FailuresOutput receives a line for each failed job: its input
+	// records with FailuresFormat original, or its result with result.
+	FailuresOutput io.Writer
+	FailuresFormat string
+	// Summary writes a record of totals once every job has finished, to
+	// SummaryOutput or, when that is nil, after the results.
+	Summary bool
+	SummaryOutput io.Writer
+	// Reports are written once every job has finished, each given as
+	// FORMAT=PATH.
+	Reports []string
+	// LogResults is syslog or journald to record the outcome of every job
+	// in the system log.
+	LogResults string
+	// OtelEndpoint is an OTLP over HTTP endpoint which a span for each
+	// job is exported to.
+	OtelEndpoint string
+	// Statsd is the host:port of a StatsD server which metrics for each
+	// job are sent to.
+	Statsd string
+	// Tui draws a live dashboard of the run on the terminal, with keys to
+	// pause it, change its parallelism, and kill jobs.
+	Tui bool
+	// Control is the path of a unix socket which accepts commands, one
+	// per line, to pause and resume the run, change its parallelism, and
+	// cancel jobs by key.
+	Control string
+	// Webhook is a URL which records are posted to: every result, only
+	// failures, or only the summary, as chosen by WebhookOn.
+	Webhook string
+	WebhookOn string
+	WebhookRetries int
+	WebhookConcurrency int
+	Debug bool
+	// Logger receives structured logs about the run.  Nothing is logged
+	// when it is nil.
+	Logger *slog.Logger
+	// Replay reads results of an earlier run and runs their commands
+	// again as they were recorded, or only those of failed jobs with
+	// ReplayFailures.
+	Replay bool
+	ReplayFailures bool
+	// Args are the command templates, one per argument.
+	Args []string
+	// Then are commands run in turn after the command succeeds, each
+	// written as a single template.
+	Then []string
+	// secrets are loaded from Secrets when a run starts, and redact
+	// masks them.
+	secrets map[string]string
+	redact *redactor
+}
+
+// NewOptions returns the default options.
+func NewOptions() *Options {
+	return &Options{
+		Parallelism: DEFAULT_PARALLELISM,
+		InputFormat: INPUT_FORMAT_JSON,
+		Seed: time.Now().UnixNano(),
+		LineKey: DEFAULT_LINE_KEY,
+		OutputFormat: OUTPUT_FORMAT_NDJSON,
+		ParseStdout: PARSE_STDOUT_NONE,
+		EchoInput: ECHO_INPUT_ON_FAILURE,
+		MissingVar: MISSING_VAR_EMPTY,
+		Escape: ESCAPE_NONE,
+		LeftDelim: "{{",
+		RightDelim: "}}",
+		RetryDelay: DEFAULT_RETRY_DELAY,
+		WebhookOn: WEBHOOK_ON_RESULT,
+		WebhookRetries: DEFAULT_WEBHOOK_RETRIES,
+		WebhookConcurrency: DEFAULT_WEBHOOK_CONCURRENCY,
+		RetryBackoff: DEFAULT_RETRY_BACKOFF,
+		ReorderBuffer: DEFAULT_REORDER_BUFFER,
+		GracePeriod: DEFAULT_GRACE_PERIOD,
+		KillSignal: syscall.SIGKILL,
+		ShellPath: DEFAULT_SHELL,
+		DockerPath: DEFAULT_DOCKER,
+		KubectlPath: DEFAULT_KUBECTL,
+		ExitStatus: EXIT_STATUS_ANY_FAILURE,
+		FailuresFormat: FAILURES_FORMAT_ORIGINAL,
+		RateBurst: 1,
+		GroupParallelism: 1,
+		IdField: DEFAULT_ID_FIELD,
+		DependsOnField: DEFAULT_DEPENDS_ON_FIELD,
+	}
+}
+
+// ExitError is returned when jpar should exit with a specific status.
+type ExitError struct {
+	Code int
+	Message string
+}
+
+func (e *ExitError) Error() string {
+	return e.Message
+}
+
+type Job struct {
+	Value interface{}
+	Seq int
+	Key string
+	Group string
+	// Id and DependsOn place the job in the dependency graph.
+	Id string
+	DependsOn []string
+	Priority float64
+	// Command is run instead of expanding the command templates.
+	Command []string
+	// Attempt and History track requeued jobs.
+	Attempt int
+	History []interface{}
+	// Ack acknowledges the record to the queue it came from.
+	Ack *recordAck
+	Done bool
+}
+
+type Output struct {
+	Value interface{}
+	Seq int
+	// Event marks streamed output, which is written immediately.
+	Event bool
+	Ack *recordAck
+	Done bool
+}
\ No newline at end of file
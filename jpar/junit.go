@@ -0,0 +1,109 @@
+package jpar
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// junitReport is a JUnit XML report, in which each job is a test case.
+// Failed jobs carry their error and stderr, and skipped records their
+// reason.
+type junitReport struct {
+	start time.Time
+	cases []junitCase
+}
+
+type junitSuites struct {
+	XMLName xml.Name `xml:"testsuites"`
+	Tests int `xml:"tests,attr"`
+	Failures int `xml:"failures,attr"`
+	Skipped int `xml:"skipped,attr"`
+	Time float64 `xml:"time,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name string `xml:"name,attr"`
+	Tests int `xml:"tests,attr"`
+	Failures int `xml:"failures,attr"`
+	Skipped int `xml:"skipped,attr"`
+	Time float64 `xml:"time,attr"`
+	Timestamp string `xml:"timestamp,attr"`
+	Cases []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name string `xml:"name,attr"`
+	ClassName string `xml:"classname,attr"`
+	Time float64 `xml:"time,attr"`
+	Failure *junitMessage `xml:"failure,omitempty"`
+	Skipped *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string `xml:"system-out,omitempty"`
+	SystemErr string `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+func newJunitReport() *junitReport {
+	return &junitReport{start: time.Now()}
+}
+
+func (j *junitReport) add(seq int, r map[string]interface{}) {
+	c := junitCase{Name: reportName(seq, r), ClassName: "jpar", Time: resultSeconds(r)}
+	c.SystemOut, _ = r["stdout"].(string)
+	c.SystemErr, _ = r["stderr"].(string)
+	switch {
+	case jobSkipped(r):
+		reason, _ := r["reason"].(string)
+		c.Skipped = &junitMessage{Message: reason}
+	case jobFailed(r):
+		c.Failure = &junitMessage{Message: failureMessage(r), Type: fmt.Sprint(r["outcome"]), Text: c.SystemErr}
+	}
+	j.cases = append(j.cases, c)
+}
+
+// failureMessage describes why a job failed.
+func failureMessage(r map[string]interface{}) string {
+	if e, ok := r["error"].(string); ok && e != "" {
+		return e
+	}
+	if code, ok := r["exit_code"]; ok {
+		return fmt.Sprintf("exited with %v", code)
+	}
+	return "failed"
+}
+
+func (j *junitReport) write(w io.Writer) error {
+	suite := junitSuite{
+		Name: "jpar",
+		Tests: len(j.cases),
+		Time: time.Since(j.start).Seconds(),
+		Timestamp: j.start.UTC().Format(time.RFC3339),
+		Cases: j.cases,
+	}
+	for _, c := range j.cases {
+		if c.Failure != nil {
+			suite.Failures = suite.Failures + 1
+		}
+		if c.Skipped != nil {
+			suite.Skipped = suite.Skipped + 1
+		}
+	}
+	suites := junitSuites{Tests: suite.Tests, Failures: suite.Failures, Skipped: suite.Skipped, Time: suite.Time, Suites: []junitSuite{suite}}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
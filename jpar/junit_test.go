@@ -0,0 +1,46 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func TestJunitReport(t *testing.T) {
+	j := newJunitReport()
+	j.add(0, map[string]interface{}{"outcome": OUTCOME_SUCCESS, "key": "a", "duration_ms": int64(1500), "stdout": "ok\n"})
+	j.add(1, map[string]interface{}{"outcome": OUTCOME_FAILURE, "tags": map[string]interface{}{"host": "b", "az": "1"}, "exit_code": 2, "stderr": "no <route>\n"})
+	j.add(2, map[string]interface{}{"outcome": OUTCOME_SKIPPED, "reason": "condition not met"})
+	var b bytes.Buffer
+	if err := j.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(b.Bytes(), &suites); err != nil {
+		t.Fatalf("cannot parse report: %s\n%s", err, b.String())
+	}
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Suites) != 1 {
+		t.Fatalf("unexpected totals %+v", suites)
+	}
+	cases := suites.Suites[0].Cases
+	if cases[0].Name != "a" || cases[0].Time != 1.5 || cases[0].SystemOut != "ok\n" || cases[0].Failure != nil {
+		t.Errorf("unexpected successful case %+v", cases[0])
+	}
+	if cases[1].Name != "az=1 host=b" || cases[1].Failure == nil || cases[1].Failure.Message != "exited with 2" || cases[1].Failure.Text != "no <route>\n" {
+		t.Errorf("unexpected failed case %+v", cases[1])
+	}
+	if cases[2].Name != "job 2" || cases[2].Skipped == nil || cases[2].Skipped.Message != "condition not met" {
+		t.Errorf("unexpected skipped case %+v", cases[2])
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	for _, r := range []string{"junit", "junit=", "xml=out.xml"} {
+		if _, err := parseReports([]string{r}); err == nil {
+			t.Errorf("%s: expected an error", r)
+		}
+	}
+	if f, err := parseReports([]string{"junit=out.xml"}); err != nil || len(f) != 1 || f[0].path != "out.xml" {
+		t.Errorf("unexpected reports %v, %v", f, err)
+	}
+}
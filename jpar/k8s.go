@@ -0,0 +1,240 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const DEFAULT_KUBECTL = "kubectl"
+
+// K8S_CONTROL_TIMEOUT bounds the kubectl commands used to clean up.
+const K8S_CONTROL_TIMEOUT = 30 * time.Second
+
+// K8S_POLL_INTERVAL is how often the status of a Kubernetes Job is
+// checked while waiting for it to finish.
+const K8S_POLL_INTERVAL = 2 * time.Second
+
+// k8sManifest returns the Kubernetes Job which runs a command.
+func k8sManifest(o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}, args []string) (map[string]interface{}, error) {
+	labels := map[string]interface{}{"app.kubernetes.io/managed-by": "jpar"}
+	for _, l := range cmd.K8sLabels {
+		labels[l.Name] = render(l.Value, job, meta)
+	}
+	env, err := renderEnv(o, cmd, job, meta)
+	if err != nil {
+		return nil, err
+	}
+	vars := []interface{}{}
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		vars = append(vars, map[string]interface{}{"name": parts[0], "value": parts[1]})
+	}
+	container := map[string]interface{}{
+		"name": "job",
+		"image": render(cmd.K8sImage, job, meta),
+		"command": args,
+		"env": vars,
+	}
+	if cmd.Cwd != nil {
+		container["workingDir"] = render(cmd.Cwd, job, meta)
+	}
+	resources := map[string]interface{}{}
+	if cmd.K8sCpu != nil {
+		resources["cpu"] = render(cmd.K8sCpu, job, meta)
+	}
+	if cmd.K8sMemory != nil {
+		resources["memory"] = render(cmd.K8sMemory, job, meta)
+	}
+	if len(resources) > 0 {
+		container["resources"] = map[string]interface{}{"requests": resources, "limits": resources}
+	}
+	metadata := map[string]interface{}{"generateName": "jpar-", "labels": labels}
+	if cmd.K8sNamespace != nil {
+		metadata["namespace"] = render(cmd.K8sNamespace, job, meta)
+	}
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind": "Job",
+		"metadata": metadata,
+		"spec": map[string]interface{}{
+			// jpar does its own retrying.
+			"backoffLimit": 0,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": labels},
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []interface{}{container},
+				},
+			},
+		},
+	}, nil
+}
+
+// k8sJob is a submitted Kubernetes Job.
+type k8sJob struct {
+	kubectl string
+	namespace string
+	name string
+}
+
+// runK8sJob submits a command as a Kubernetes Job, waits for it to
+// finish, and records its logs and termination status.
+func runK8sJob(ctx context.Context, o *Options, cmd *CommandTemplate, job interface{}, meta map[string]interface{}, args []string, r map[string]interface{}) map[string]interface{} {
+	timeout, err := jobTimeout(o, job)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	manifest, err := k8sManifest(o, cmd, job, meta, args)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot encode job: %s", err)
+		return r
+	}
+	k := &k8sJob{kubectl: o.KubectlPath}
+	if ns, ok := manifest["metadata"].(map[string]interface{})["namespace"].(string); ok {
+		k.namespace = ns
+	}
+	start := time.Now()
+	out, err := k.control(ctx, bytes.NewReader(b), "create", "-f", "-", "-o", "jsonpath={.metadata.name}")
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot create job: %s", err)
+		return r
+	}
+	k.name = strings.TrimSpace(string(out))
+	r["k8s_job"] = k.name
+	defer k.delete()
+	jobCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := k.wait(jobCtx); err != nil {
+		r["duration_ms"] = time.Since(start).Milliseconds()
+		if ctx.Err() != nil {
+			r["error"] = "cancelled"
+		} else if jobCtx.Err() == context.DeadlineExceeded {
+			r["error"] = fmt.Sprintf("deleted after timeout of %s", timeout)
+			r["outcome"] = OUTCOME_TIMEOUT
+		} else {
+			r["error"] = err.Error()
+		}
+		return r
+	}
+	r["duration_ms"] = time.Since(start).Milliseconds()
+	pod, code, reason, err := k.terminated(ctx)
+	if err != nil {
+		r["error"] = err.Error()
+		return r
+	}
+	r["pod"] = pod
+	if reason != "" {
+		r["k8s_reason"] = reason
+	}
+	// Pods report exit codes, which are encoded like a wait status.
+	recordExit(o, r, exitedStatus(code))
+	if logs, err := k.control(ctx, nil, "logs", "pod/"+pod); err == nil {
+		r["stdout"] = string(logs)
+	} else {
+		addError(r, fmt.Sprintf("cannot read logs: %s", err))
+	}
+	return r
+}
+
+// wait polls the job until it has succeeded or failed.
+func (k *k8sJob) wait(ctx context.Context) error {
+	for {
+		out, err := k.control(ctx, nil, "get", "job", k.name, "-o", "json")
+		if err != nil {
+			return fmt.Errorf("cannot get job status: %s", err)
+		}
+		var status struct {
+			Status struct {
+				Succeeded int
+				Failed int
+			}
+		}
+		if err := json.Unmarshal(out, &status); err != nil {
+			return fmt.Errorf("cannot decode job status: %s", err)
+		}
+		if status.Status.Succeeded > 0 || status.Status.Failed > 0 {
+			return nil
+		}
+		select {
+		case <-time.After(K8S_POLL_INTERVAL):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// terminated returns the job's pod, and the exit code and reason of its
+// container.
+func (k *k8sJob) terminated(ctx context.Context) (string, int, string, error) {
+	out, err := k.control(ctx, nil, "get", "pods", "-l", "job-name="+k.name, "-o", "json")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("cannot get pod: %s", err)
+	}
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name string
+			}
+			Status struct {
+				ContainerStatuses []struct {
+					State struct {
+						Terminated *struct {
+							ExitCode int
+							Reason string
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(out, &pods); err != nil {
+		return "", 0, "", fmt.Errorf("cannot decode pod: %s", err)
+	}
+	for _, p := range pods.Items {
+		for _, c := range p.Status.ContainerStatuses {
+			if t := c.State.Terminated; t != nil {
+				return p.Metadata.Name, t.ExitCode, t.Reason, nil
+			}
+		}
+	}
+	return "", 0, "", fmt.Errorf("job %s has no terminated pod", k.name)
+}
+
+// delete removes the job and its pods.
+func (k *k8sJob) delete() {
+	ctx, cancel := context.WithTimeout(context.Background(), K8S_CONTROL_TIMEOUT)
+	defer cancel()
+	k.control(ctx, nil, "delete", "job", k.name, "--propagation-policy=Background", "--wait=false")
+}
+
+func (k *k8sJob) control(ctx context.Context, stdin *bytes.Reader, args ...string) ([]byte, error) {
+	if k.namespace != "" {
+		args = append([]string{"--namespace", k.namespace}, args...)
+	}
+	c := exec.CommandContext(ctx, k.kubectl, args...)
+	if stdin != nil {
+		c.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+	}
+	return out, err
+}
@@ -0,0 +1,35 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestK8sManifest(t *testing.T) {
+	o := NewOptions()
+	cmd := parseCmd(t, "process", "{{id}}")
+	cmd.K8sImage = parseTemplate(t, "worker:{{version}}")
+	cmd.K8sNamespace = parseTemplate(t, "batch")
+	cmd.K8sMemory = parseTemplate(t, "1Gi")
+	cmd.K8sLabels = []EnvTemplate{{Name: "item", Value: parseTemplate(t, "{{id}}")}}
+	job := map[string]interface{}{"id": "a1", "version": "2"}
+	m, err := k8sManifest(o, cmd, job, nil, renderCommand(o, cmd, job, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := m["metadata"].(map[string]interface{})
+	if metadata["namespace"] != "batch" || metadata["labels"].(map[string]interface{})["item"] != "a1" {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	pod := m["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	c := pod["containers"].([]interface{})[0].(map[string]interface{})
+	if c["image"] != "worker:2" {
+		t.Errorf("unexpected image %v", c["image"])
+	}
+	if args := c["command"].([]string); len(args) != 2 || args[1] != "a1" {
+		t.Errorf("unexpected command %v", args)
+	}
+	limits := c["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+	if limits["memory"] != "1Gi" {
+		t.Errorf("unexpected limits %v", limits)
+	}
+}
@@ -0,0 +1,18 @@
+package jpar
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger is used when no logger is configured.
+var discardLogger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+// logger returns the logger for a run.  Logs never go to the result
+// stream.
+func logger(o *Options) *slog.Logger {
+	if o.Logger == nil {
+		return discardLogger
+	}
+	return o.Logger
+}
@@ -0,0 +1,29 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRunnerLogs(t *testing.T) {
+	var logs bytes.Buffer
+	o := NewOptions()
+	o.Args = []string{"true"}
+	o.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var out bytes.Buffer
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"n":1}{`), &out)
+	if err == nil {
+		t.Fatal("expected the parse error to fail the run")
+	}
+	for _, msg := range []string{"worker started", "job dispatched", "job finished", "cannot parse input"} {
+		if !strings.Contains(logs.String(), `"msg":"`+msg+`"`) {
+			t.Errorf("missing log %q in %s", msg, logs.String())
+		}
+	}
+	if strings.Contains(out.String(), `"msg"`) {
+		t.Errorf("logs written to the result stream: %s", out.String())
+	}
+}
@@ -0,0 +1,52 @@
+package jpar
+
+import (
+	"sort"
+)
+
+// expandMatrix expands a record whose fields hold arrays into one record
+// for every combination of their elements, like a CI build matrix.
+// Other fields are copied into every record.  Combinations are ordered
+// by field name, with the last field varying fastest, and a field with
+// an empty array yields no records.  Records which are not objects are
+// returned as they are.
+func expandMatrix(v interface{}) []interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return []interface{}{v}
+	}
+	axes := []string{}
+	for k, x := range m {
+		if _, ok := x.([]interface{}); ok {
+			axes = append(axes, k)
+		}
+	}
+	sort.Strings(axes)
+	combinations := []map[string]interface{}{{}}
+	for _, axis := range axes {
+		next := []map[string]interface{}{}
+		for _, c := range combinations {
+			for _, x := range m[axis].([]interface{}) {
+				e := map[string]interface{}{}
+				for ck, cx := range c {
+					e[ck] = cx
+				}
+				e[axis] = x
+				next = append(next, e)
+			}
+		}
+		combinations = next
+	}
+	records := []interface{}{}
+	for _, c := range combinations {
+		r := map[string]interface{}{}
+		for k, x := range m {
+			r[k] = x
+		}
+		for k, x := range c {
+			r[k] = x
+		}
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,34 @@
+package jpar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	record := map[string]interface{}{
+		"os": []interface{}{"linux", "darwin"},
+		"arch": []interface{}{"amd64", "arm64"},
+		"pkg": "./cmd",
+	}
+	got := expandMatrix(record)
+	want := []interface{}{
+		map[string]interface{}{"arch": "amd64", "os": "linux", "pkg": "./cmd"},
+		map[string]interface{}{"arch": "amd64", "os": "darwin", "pkg": "./cmd"},
+		map[string]interface{}{"arch": "arm64", "os": "linux", "pkg": "./cmd"},
+		map[string]interface{}{"arch": "arm64", "os": "darwin", "pkg": "./cmd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := expandMatrix(map[string]interface{}{"n": []interface{}{}, "x": 1}); len(got) != 0 {
+		t.Errorf("expected no records for an empty array, got %v", got)
+	}
+	if got := expandMatrix("x"); !reflect.DeepEqual(got, []interface{}{"x"}) {
+		t.Errorf("expected a scalar record to be kept, got %v", got)
+	}
+	plain := map[string]interface{}{"x": 1}
+	if got := expandMatrix(plain); !reflect.DeepEqual(got, []interface{}{plain}) {
+		t.Errorf("expected a record without arrays to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,31 @@
+package jpar
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNatsAddress(t *testing.T) {
+	server, subject, params, err := natsAddress("nats://u:p@host:4222/jobs.run?queue=w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "nats://u:p@host:4222" || subject != "jobs.run" || params.Get("queue") != "w" {
+		t.Errorf("got %s, %s, and %v", server, subject, params)
+	}
+	if _, _, _, err := natsAddress("nats://host:4222"); err == nil {
+		t.Error("expected an error without a subject")
+	}
+}
+
+func TestNatsRecord(t *testing.T) {
+	x := natsRecord(nil, &nats.Msg{Data: []byte(`{"n":1}`)})
+	if x.Err != nil || x.Reply != nil || x.Value.(map[string]interface{})["n"] != 1.0 {
+		t.Errorf("got %+v", x)
+	}
+	x = natsRecord(nil, &nats.Msg{Data: []byte(`{`), Reply: "_INBOX.1"})
+	if x.Err == nil || x.Reply == nil {
+		t.Errorf("expected a parse error which is answered, got %+v", x)
+	}
+}
@@ -0,0 +1,78 @@
+package jpar
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// GCS_ENDPOINT serves the S3 compatible API of Google Cloud Storage.
+const GCS_ENDPOINT string = "https://storage.googleapis.com"
+
+// isObjectListing reports whether an input lists the objects in a
+// bucket.
+func isObjectListing(addr string) bool {
+	return strings.HasPrefix(addr, "s3://") || strings.HasPrefix(addr, "gs://")
+}
+
+// readObjects lists the objects under s3://bucket/prefix or
+// gs://bucket/prefix, emitting a record for each.  Credentials and the
+// region come from the usual AWS configuration.  Google Cloud Storage is
+// reached through its S3 compatible API, which takes HMAC keys as the
+// AWS credentials.
+func readObjects(addr string, out chan JsonRead) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("cannot parse object address %s: %s", addr, err)
+	}
+	bucket := u.Host
+	prefix := strings.TrimPrefix(u.Path, "/")
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot load aws configuration: %s", err)
+	}
+	client := s3.NewFromConfig(cfg, func(so *s3.Options) {
+		if u.Scheme == "gs" {
+			so.BaseEndpoint = aws.String(GCS_ENDPOINT)
+			so.UsePathStyle = true
+			if so.Region == "" {
+				so.Region = "auto"
+			}
+		}
+	})
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot list %s: %s", addr, err)
+		}
+		for _, obj := range page.Contents {
+			out <- JsonRead{Value: objectRecord(u.Scheme, bucket, aws.ToString(obj.Key), aws.ToInt64(obj.Size),
+				aws.ToString(obj.ETag), aws.ToTime(obj.LastModified), string(obj.StorageClass))}
+		}
+	}
+	return nil
+}
+
+// objectRecord is the record for an object in a listing.
+func objectRecord(scheme string, bucket string, key string, size int64, etag string, modified time.Time, class string) map[string]interface{} {
+	return map[string]interface{}{
+		"url": scheme + "://" + bucket + "/" + key,
+		"bucket": bucket,
+		"key": key,
+		"size": size,
+		"etag": strings.Trim(etag, `"`),
+		"last_modified": modified.UTC().Format(time.RFC3339),
+		"storage_class": class,
+	}
+}
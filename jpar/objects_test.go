@@ -0,0 +1,66 @@
+package jpar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestObjectRecord(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := objectRecord("s3", "media", "in/a.mov", 42, `"abc"`, modified, "STANDARD")
+	want := map[string]interface{}{
+		"url": "s3://media/in/a.mov",
+		"bucket": "media",
+		"key": "in/a.mov",
+		"size": int64(42),
+		"etag": "abc",
+		"last_modified": "2024-05-01T12:00:00Z",
+		"storage_class": "STANDARD",
+	}
+	for k, v := range want {
+		if r[k] != v {
+			t.Errorf("%s: got %v, want %v", k, r[k], v)
+		}
+	}
+}
+
+func TestReadObjects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "in/" {
+			t.Errorf("expected the prefix in/, got %s", r.URL)
+		}
+		token := r.URL.Query().Get("continuation-token")
+		w.Header().Set("Content-Type", "application/xml")
+		if token == "" {
+			w.Write([]byte(`<ListBucketResult><Name>media</Name><IsTruncated>true</IsTruncated><NextContinuationToken>t</NextContinuationToken>` +
+				`<Contents><Key>in/a.mov</Key><Size>1</Size><ETag>"e1"</ETag><LastModified>2024-05-01T12:00:00.000Z</LastModified></Contents></ListBucketResult>`))
+			return
+		}
+		w.Write([]byte(`<ListBucketResult><Name>media</Name><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>in/b.mov</Key><Size>2</Size><ETag>"e2"</ETag><LastModified>2024-05-01T12:00:00.000Z</LastModified></Contents></ListBucketResult>`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	o := NewOptions()
+	j, err := readInputFiles(o, []string{"s3://media/in/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for x := range j {
+		if x.Err != nil {
+			t.Fatal(x.Err)
+		}
+		keys = append(keys, x.Value.(map[string]interface{})["key"].(string))
+	}
+	if len(keys) != 2 || keys[0] != "in/a.mov" || keys[1] != "in/b.mov" {
+		t.Errorf("got keys %v", keys)
+	}
+}
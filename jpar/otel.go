@@ -0,0 +1,145 @@
+package jpar
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const OTEL_SHUTDOWN_TIMEOUT = 10 * time.Second
+
+// jobTracer exports a span for every job to an OTLP endpoint, under a
+// root span for the run.
+type jobTracer struct {
+	provider *sdktrace.TracerProvider
+	tracer trace.Tracer
+	ctx context.Context
+	root trace.Span
+}
+
+// startTracing starts the root span of a run.  It returns nil when no
+// endpoint is given.
+func startTracing(o *Options, name string) (*jobTracer, error) {
+	if o.OtelEndpoint == "" {
+		return nil, nil
+	}
+	endpoint, err := otelEndpoint(o.OtelEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("cannot export traces to %s: %s", endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "jpar"))),
+	)
+	tracer := provider.Tracer("github.com/jmyounker/jpar")
+	ctx, root := tracer.Start(context.Background(), name)
+	return &jobTracer{provider: provider, tracer: tracer, ctx: ctx, root: root}, nil
+}
+
+// otelEndpoint completes an endpoint given as host:port or as a URL
+// without a path, which OTLP over HTTP sends traces to at /v1/traces.
+func otelEndpoint(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("otel endpoint %s must be host:port or a URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// job records the span of a finished job.  Jobs are traced once their
+// results arrive, so the span ends now and starts its duration earlier.
+func (t *jobTracer) job(x Output) {
+	r, ok := x.Value.(map[string]interface{})
+	if t == nil || !ok || x.Event {
+		return
+	}
+	end := time.Now()
+	start := end.Add(-time.Duration(resultSeconds(r) * float64(time.Second)))
+	attrs := []attribute.KeyValue{attribute.Int("jpar.seq", x.Seq)}
+	outcome, _ := r["outcome"].(string)
+	attrs = append(attrs, attribute.String("jpar.outcome", outcome))
+	attrs = append(attrs, attribute.Float64("jpar.duration_ms", resultSeconds(r)*1000))
+	if command := resultCommand(r); command != nil {
+		attrs = append(attrs, attribute.StringSlice("jpar.command", command))
+	}
+	if code, ok := r["exit_code"].(int); ok {
+		attrs = append(attrs, attribute.Int("jpar.exit_code", code))
+	}
+	if key, ok := r["key"].(string); ok {
+		attrs = append(attrs, attribute.String("jpar.key", key))
+	}
+	if tags, ok := r["tags"].(map[string]interface{}); ok {
+		names := []string{}
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			attrs = append(attrs, attribute.String("jpar.tag."+name, fieldValue(tags[name])))
+		}
+	}
+	name := "job"
+	if command := resultCommand(r); len(command) > 0 {
+		name = command[0]
+	}
+	_, span := t.tracer.Start(t.ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if jobFailed(r) {
+		msg, _ := r["error"].(string)
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// resultCommand is the command of a result, or nil when it has none.
+func resultCommand(r map[string]interface{}) []string {
+	switch c := r["command"].(type) {
+	case []string:
+		return c
+	case []interface{}:
+		words := []string{}
+		for _, w := range c {
+			words = append(words, fmt.Sprint(w))
+		}
+		return words
+	}
+	return nil
+}
+
+// finish ends the root span, recording how many jobs ran and failed,
+// and waits for the spans to be exported.
+func (t *jobTracer) finish(ran int, failed int) error {
+	if t == nil {
+		return nil
+	}
+	t.root.SetAttributes(attribute.Int("jpar.jobs", ran), attribute.Int("jpar.failed", failed))
+	if failed > 0 {
+		t.root.SetStatus(codes.Error, fmt.Sprintf("%d of %d jobs failed", failed, ran))
+	}
+	t.root.End()
+	ctx, cancel := context.WithTimeout(context.Background(), OTEL_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("cannot export traces: %s", err)
+	}
+	return nil
+}
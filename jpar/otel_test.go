@@ -0,0 +1,89 @@
+package jpar
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestJobTracer(t *testing.T) {
+	var mu sync.Mutex
+	spans := map[string]map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		body, _ := io.ReadAll(req.Body)
+		var export coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &export); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					attrs := map[string]string{"status": s.Status.GetCode().String()}
+					for _, a := range s.Attributes {
+						attrs[a.Key] = a.Value.GetStringValue()
+						if _, ok := a.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+							attrs[a.Key] = fmt.Sprint(a.Value.GetIntValue())
+						}
+					}
+					spans[s.Name] = attrs
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+	o := NewOptions()
+	o.OtelEndpoint = srv.URL
+	tracer, err := startTracing(o, "jpar run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.job(Output{Seq: 3, Value: map[string]interface{}{
+		"command": []string{"./deploy", "web1"}, "outcome": OUTCOME_FAILURE, "exit_code": 2, "duration_ms": int64(20),
+		"error": "exited with status 2", "tags": map[string]interface{}{"host": "web1"},
+	}})
+	tracer.job(Output{Seq: 4, Event: true, Value: outputEvent(4, "stdout", "x")})
+	if err := tracer.finish(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 2 {
+		t.Fatalf("expected a root span and a job span, got %v", spans)
+	}
+	job := spans["./deploy"]
+	for key, want := range map[string]string{"jpar.seq": "3", "jpar.exit_code": "2", "jpar.outcome": "FAILURE", "jpar.tag.host": "web1", "status": "STATUS_CODE_ERROR"} {
+		if job[key] != want {
+			t.Errorf("%s: expected %s, got %s", key, want, job[key])
+		}
+	}
+	if spans["jpar run"]["jpar.failed"] != "1" {
+		t.Errorf("unexpected root span %v", spans["jpar run"])
+	}
+}
+
+func TestOtelEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"localhost:4318": "http://localhost:4318/v1/traces",
+		"https://otel.example.com": "https://otel.example.com/v1/traces",
+		"http://c:4318/custom": "http://c:4318/custom",
+	}
+	for in, want := range tests {
+		if got, err := otelEndpoint(in); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s, %v", in, want, got, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package jpar
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OpenOutput opens a destination for results.  The destination is "-"
+// for stdout, a unix:// or tcp:// address to connect to, an amqp://,
+// redis://, or nats:// address to publish to, a sqlite:// database, or a
+// file path.
+func OpenOutput(dest string) (io.WriteCloser, error) {
+	switch {
+	case dest == "-":
+		return nopCloser{os.Stdout}, nil
+	case strings.HasPrefix(dest, "unix://"):
+		return dialOutput("unix", strings.TrimPrefix(dest, "unix://"))
+	case strings.HasPrefix(dest, "tcp://"):
+		return dialOutput("tcp", strings.TrimPrefix(dest, "tcp://"))
+	case isAmqp(dest):
+		w, err := openAmqpOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	case isRedis(dest):
+		w, err := openRedisOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	case isNats(dest):
+		w, err := openNatsOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	case isSqlite(dest):
+		w, err := openSqliteOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create output %s: %s", dest, err)
+	}
+	return f, nil
+}
+
+func dialOutput(network string, addr string) (io.WriteCloser, error) {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to output %s://%s: %s", network, addr, err)
+	}
+	return c, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// passthroughMu keeps lines passed through from different jobs whole.
+var passthroughMu sync.Mutex
+
+// passthroughLines copies each line of a child's output to w.
+func passthroughLines(w io.Writer) *lineEvents {
+	return &lineEvents{emit: func(line string) {
+		passthroughMu.Lock()
+		defer passthroughMu.Unlock()
+		fmt.Fprintln(w, line)
+	}}
+}
+
+// teeLines copies each line of a child's output to w, prefixed with tag.
+func teeLines(w io.Writer, tag string) *lineEvents {
+	return &lineEvents{emit: func(line string) {
+		passthroughMu.Lock()
+		defer passthroughMu.Unlock()
+		fmt.Fprintf(w, "%s %s\n", tag, line)
+	}}
+}
+
+// teeTag identifies the job and stream of teed output, as in "[3]" for
+// the stdout of the job for record 3 and "[3 stderr]" for its stderr.
+// Tags follow the record's position, as in "[3 host=web1]".
+func teeTag(cmd *CommandTemplate, job interface{}, meta map[string]interface{}, stream string) string {
+	parts := []string{fmt.Sprint(meta["_seq"])}
+	for _, t := range cmd.Tags {
+		parts = append(parts, t.Name+"="+render(t.Value, job, meta))
+	}
+	if stream != "stdout" {
+		parts = append(parts, stream)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
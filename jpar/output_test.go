@@ -0,0 +1,74 @@
+package jpar
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenOutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	w, err := OpenOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("{}\n"))
+	w.Close()
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "{}\n" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func TestOpenOutputUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("cannot listen on a unix socket:", err)
+	}
+	defer l.Close()
+	received := make(chan string)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		received <- line
+	}()
+	w, err := OpenOutput("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("{}\n"))
+	w.Close()
+	if line := <-received; line != "{}\n" {
+		t.Errorf("got %q", line)
+	}
+}
+
+func TestTeeLines(t *testing.T) {
+	var w bytes.Buffer
+	meta := jobMeta(3, 0)
+	cmd := &CommandTemplate{}
+	out := teeLines(&w, teeTag(cmd, nil, meta, "stdout"))
+	errs := teeLines(&w, teeTag(cmd, nil, meta, "stderr"))
+	out.Write([]byte("one\ntw"))
+	errs.Write([]byte("oops\n"))
+	out.Write([]byte("o\n"))
+	if got := w.String(); got != "[3] one\n[3 stderr] oops\n[3] two\n" {
+		t.Errorf("unexpected tee output %q", got)
+	}
+}
+
+func TestTeeTag(t *testing.T) {
+	cmd := &CommandTemplate{Tags: []EnvTemplate{{Name: "host", Value: parseTemplate(t, "{{h}}")}}}
+	job := map[string]interface{}{"h": "web1"}
+	if got := teeTag(cmd, job, jobMeta(3, 0), "stderr"); got != "[3 host=web1 stderr]" {
+		t.Errorf("unexpected tag %q", got)
+	}
+}
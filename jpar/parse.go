@@ -0,0 +1,30 @@
+package jpar
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parseStdout moves the stdout of a job into result, decoded as JSON.
+// Output which is not a single JSON value is kept as a string, and the
+// error is recorded under result_parse_error.  Jobs which print nothing
+// have a null result.
+func parseStdout(r map[string]interface{}) {
+	stdout, ok := r["stdout"].(string)
+	if !ok {
+		return
+	}
+	delete(r, "stdout")
+	if strings.TrimSpace(stdout) == "" {
+		r["result"] = nil
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(stdout), &v); err != nil {
+		r["result"] = stdout
+		r["result_parse_error"] = fmt.Sprintf("cannot parse stdout as json: %s", err)
+		return
+	}
+	r["result"] = v
+}
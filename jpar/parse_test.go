@@ -0,0 +1,32 @@
+package jpar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStdout(t *testing.T) {
+	tests := []struct {
+		stdout interface{}
+		want map[string]interface{}
+	}{
+		{`{"n":1}` + "\n", map[string]interface{}{"result": map[string]interface{}{"n": 1.0}}},
+		{"[1,2]", map[string]interface{}{"result": []interface{}{1.0, 2.0}}},
+		{" \n", map[string]interface{}{"result": nil}},
+		{"ok\n", map[string]interface{}{"result": "ok\n", "result_parse_error": "cannot parse stdout as json: invalid character 'o' looking for beginning of value"}},
+		{`{"n":1}{"n":2}`, map[string]interface{}{"result": `{"n":1}{"n":2}`, "result_parse_error": "cannot parse stdout as json: invalid character '{' after top-level value"}},
+		{nil, map[string]interface{}{"stdout_file": "out.log"}},
+	}
+	for _, tc := range tests {
+		r := map[string]interface{}{}
+		if tc.stdout != nil {
+			r["stdout"] = tc.stdout
+		} else {
+			r["stdout_file"] = "out.log"
+		}
+		parseStdout(r)
+		if !reflect.DeepEqual(r, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.stdout, tc.want, r)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package jpar
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PLAN_PROBLEMS is how many of the jobs which would fail a plan lists.
+const PLAN_PROBLEMS = 20
+
+// Plan reads the whole input and reports what a run would do, without
+// running anything: how many jobs would run, their distinct commands,
+// how many jobs fall in each group, and which jobs would fail because
+// their templates cannot be rendered.  It returns the plan as a record,
+// and the number of problems found: jobs which would fail, and jobs
+// which use variables their records do not define.
+func Plan(o *Options, input io.Reader) (map[string]interface{}, int, error) {
+	if err := validateOptions(o); err != nil {
+		return nil, 0, err
+	}
+	o, err := withRedaction(o)
+	if err != nil {
+		return nil, 0, err
+	}
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter, err := compileFilter(o.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	when, err := compileCondition(o.When)
+	if err != nil {
+		return nil, 0, err
+	}
+	var j chan JsonRead
+	if len(o.Inputs) > 0 {
+		j, err = readInputFiles(o, o.Inputs, input)
+	} else {
+		j, err = readInput(o, input)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// The state file is only read, so a plan does not create one.
+	var state *StateFile
+	if _, err := os.Stat(o.StateFile); o.StateFile != "" && err == nil {
+		state, err = OpenStateFile(o.StateFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer state.Close()
+	}
+	var seen map[string]bool
+	if o.Dedupe || o.DedupeKey != "" {
+		seen = map[string]bool{}
+	}
+	sampler := newInputSampler(o)
+	read := 0
+	parseErrors := 0
+	skipped := map[string]interface{}{}
+	skip := func(reason string) {
+		n, _ := skipped[reason].(int)
+		skipped[reason] = n + 1
+	}
+	jobs := 0
+	commands := map[string]bool{}
+	programs := map[string]interface{}{}
+	groups := map[string]interface{}{}
+	failures := 0
+	undefined := 0
+	problems := []interface{}{}
+	// problem lists a record which would fail, or which uses variables
+	// it does not define.
+	problem := func(record interface{}, reason string) {
+		if len(problems) < PLAN_PROBLEMS {
+			record = o.redact.forRecord(o.RedactFields, record).value(record)
+			problems = append(problems, map[string]interface{}{"e": record, "error": reason})
+		}
+	}
+	fail := func(record interface{}, reason string) {
+		failures = failures + 1
+		problem(record, reason)
+	}
+	// plan renders the templates of a job as a worker would, without
+	// running its command, and counts it if it would run.
+	plan := func(v interface{}, recorded []string) {
+		meta := jobMeta(jobs, 0)
+		if len(o.secrets) > 0 {
+			meta["_secrets"] = secretsMeta(o)
+		}
+		cmd := cmd
+		if recorded != nil {
+			cmd = cmd.literal(recorded)
+		}
+		redact := o.redact.forRecord(o.RedactFields, v)
+		if recorded == nil {
+			// Undefined variables are reported even when they would be
+			// expanded as empty strings, as they are usually mistakes.
+			if missing := missingVariables(o, v, meta); len(missing) > 0 {
+				reason := "undefined variables: " + strings.Join(missing, ", ")
+				switch o.MissingVar {
+				case MISSING_VAR_SKIP:
+					skip("undefined variables")
+					return
+				case MISSING_VAR_ERROR:
+					fail(v, reason)
+					return
+				}
+				undefined = undefined + 1
+				problem(v, reason)
+			}
+		}
+		if recorded == nil && o.Escape == ESCAPE_STRICT {
+			if unsafe := unsafeField(v, ""); unsafe != "" {
+				fail(v, unsafeResult(v, unsafe)["error"].(string))
+				return
+			}
+		}
+		if !o.HTTP {
+			if err := checkProgram(o, cmd, v, meta); err != nil {
+				fail(v, err.Error())
+				return
+			}
+		}
+		if _, err := renderEnv(o, cmd, v, meta); err != nil {
+			fail(v, err.Error())
+			return
+		}
+		if cmd.GroupBy != nil {
+			group := redact.string(render(cmd.GroupBy, v, nil))
+			n, _ := groups[group].(int)
+			groups[group] = n + 1
+		}
+		jobs = jobs + 1
+		args := redact.value(renderCommand(o, cmd, v, meta)).([]string)
+		commands[strings.Join(args, " ")] = true
+		if len(args) > 0 {
+			n, _ := programs[args[0]].(int)
+			programs[args[0]] = n + 1
+		}
+	}
+	// With --batch the records are planned in batches, as they would run.
+	var batch []interface{}
+	flush := func() {
+		if len(batch) > 0 {
+			plan(map[string]interface{}{"items": batch}, nil)
+			batch = nil
+		}
+	}
+	for x := range j {
+		if sampler != nil && sampler.full() {
+			break
+		}
+		read = read + 1
+		if x.Err != nil {
+			parseErrors = parseErrors + 1
+			continue
+		}
+		if sampler != nil && !sampler.keep() {
+			skip("sampled out")
+			continue
+		}
+		var recorded []string
+		if o.Replay {
+			var keep bool
+			x.Value, recorded, keep = replayRecord(o, x.Value)
+			if !keep {
+				skip("not replayed")
+				continue
+			}
+			if len(recorded) == 0 {
+				skip("no command recorded")
+				continue
+			}
+		}
+		values := []interface{}{x.Value}
+		if filter != nil {
+			values, err = applyFilter(filter, x.Value)
+			if err != nil {
+				fail(x.Value, fmt.Sprintf("filter error: %s", err))
+				continue
+			}
+			if len(values) == 0 {
+				skip("filtered")
+			}
+		}
+		if o.Matrix {
+			expanded := []interface{}{}
+			for _, v := range values {
+				expanded = append(expanded, expandMatrix(v)...)
+			}
+			values = expanded
+		}
+		for _, v := range values {
+			if when != nil {
+				met, err := conditionMet(when, v)
+				if err != nil {
+					fail(v, fmt.Sprintf("condition error: %s", err))
+					continue
+				}
+				if !met {
+					skip("condition not met")
+					continue
+				}
+			}
+			if seen != nil {
+				k := dedupeKey(cmd, v)
+				if seen[k] {
+					skip("duplicate")
+					continue
+				}
+				seen[k] = true
+			}
+			if state != nil && state.Completed(jobKey(cmd, v)) {
+				skip("already completed")
+				continue
+			}
+			if o.Batch == 0 {
+				plan(v, recorded)
+				continue
+			}
+			batch = append(batch, v)
+			if len(batch) >= o.Batch {
+				flush()
+			}
+		}
+	}
+	flush()
+	record := map[string]interface{}{
+		"read": read,
+		"parse_errors": parseErrors,
+		"skipped": skipped,
+		"jobs": jobs,
+		"commands": len(commands),
+		"programs": programs,
+		"failures": failures,
+		"undefined": undefined,
+		"problems": problems,
+	}
+	if cmd.GroupBy != nil {
+		record["groups"] = groups
+	}
+	return map[string]interface{}{"plan": record}, failures + undefined, nil
+}
@@ -0,0 +1,89 @@
+package jpar
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"deploy", "{{host}}", "{{region}}"}
+	o.GroupBy = "{{region}}"
+	o.Filter = "select(.skip | not)"
+	o.Dedupe = true
+	input := strings.Join([]string{
+		`{"host":"a","region":"eu"}`,
+		`{"host":"b","region":"eu"}`,
+		`{"host":"b","region":"eu"}`,
+		`{"host":"c","region":"us"}`,
+		`{"host":"d"}`,
+		`{"host":"e","skip":true}`,
+		`not json`,
+	}, "\n")
+	plan, problems, err := Plan(o, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems != 1 {
+		t.Errorf("expected 1 problem, got %d", problems)
+	}
+	b, _ := json.Marshal(plan)
+	got := map[string]interface{}{}
+	json.Unmarshal(b, &got)
+	want := map[string]interface{}{
+		"read": 7.0,
+		"parse_errors": 1.0,
+		"skipped": map[string]interface{}{"filtered": 1.0, "duplicate": 1.0},
+		"jobs": 4.0,
+		"commands": 4.0,
+		"programs": map[string]interface{}{"deploy": 4.0},
+		"groups": map[string]interface{}{"eu": 2.0, "us": 1.0, "": 1.0},
+		"failures": 0.0,
+		"undefined": 1.0,
+		"problems": []interface{}{
+			map[string]interface{}{"e": map[string]interface{}{"host": "d"}, "error": "undefined variables: region"},
+		},
+	}
+	if !reflect.DeepEqual(got["plan"], want) {
+		t.Errorf("expected %v, got %v", want, got["plan"])
+	}
+}
+
+func TestPlanFailures(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"{{cmd}}", "{{n}}"}
+	o.MissingVar = MISSING_VAR_ERROR
+	input := `{"cmd":"echo","n":1} {"cmd":"rm","n":2} {"cmd":"echo"}`
+	plan, problems, err := Plan(o, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := plan["plan"].(map[string]interface{})
+	if problems != 3 || record["failures"] != 3 || record["jobs"] != 0 {
+		t.Errorf("expected every job to fail, got %v", record)
+	}
+	o.AllowCmds = []string{"echo"}
+	plan, problems, err = Plan(o, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record = plan["plan"].(map[string]interface{})
+	if problems != 2 || record["jobs"] != 1 {
+		t.Errorf("expected one job to run, got %v", record)
+	}
+}
+
+func TestPlanBatch(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{#items}}{{n}}{{/items}}"}
+	o.Batch = 2
+	plan, _, err := Plan(o, strings.NewReader(`{"n":1} {"n":2} {"n":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs := plan["plan"].(map[string]interface{})["jobs"]; jobs != 2 {
+		t.Errorf("expected 2 batches, got %v", jobs)
+	}
+}
@@ -0,0 +1,40 @@
+package jpar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const IOPRIO_CLASS_REALTIME = 1
+const IOPRIO_CLASS_BEST_EFFORT = 2
+const IOPRIO_CLASS_IDLE = 3
+
+const DEFAULT_IONICE_LEVEL = 4
+
+// parseIoNice parses an I/O scheduling class and level written as
+// CLASS[:LEVEL], like ionice.  The class is realtime, best-effort, or
+// idle, or its number.
+func parseIoNice(s string) (int, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	class := 0
+	switch parts[0] {
+	case "realtime", "1":
+		class = IOPRIO_CLASS_REALTIME
+	case "best-effort", "2":
+		class = IOPRIO_CLASS_BEST_EFFORT
+	case "idle", "3":
+		class = IOPRIO_CLASS_IDLE
+	default:
+		return 0, 0, fmt.Errorf("unknown I/O scheduling class %s", parts[0])
+	}
+	level := DEFAULT_IONICE_LEVEL
+	if len(parts) == 2 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 0 || n > 7 {
+			return 0, 0, fmt.Errorf("I/O priority level %s must be between 0 and 7", parts[1])
+		}
+		level = n
+	}
+	return class, level, nil
+}
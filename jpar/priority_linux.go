@@ -0,0 +1,30 @@
+package jpar
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const IOPRIO_WHO_PROCESS = 1
+const IOPRIO_CLASS_SHIFT = 13
+
+// setPriority lowers the CPU and I/O priority of a started command.
+func setPriority(o *Options, pid int) error {
+	if o.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, o.Nice); err != nil {
+			return fmt.Errorf("cannot set nice value: %s", err)
+		}
+	}
+	if o.IoNice != "" {
+		class, level, err := parseIoNice(o.IoNice)
+		if err != nil {
+			return err
+		}
+		prio := class<<IOPRIO_CLASS_SHIFT | level
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, IOPRIO_WHO_PROCESS, uintptr(pid), uintptr(prio))
+		if errno != 0 {
+			return fmt.Errorf("cannot set I/O priority: %s", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package jpar
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// setPriority lowers the CPU priority of a started command.  I/O
+// priorities are only supported on Linux.
+func setPriority(o *Options, pid int) error {
+	if o.IoNice != "" {
+		return errors.New("--ionice is only supported on Linux")
+	}
+	if o.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, o.Nice); err != nil {
+			return fmt.Errorf("cannot set nice value: %s", err)
+		}
+	}
+	return nil
+}
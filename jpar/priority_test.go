@@ -0,0 +1,27 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestParseIoNice(t *testing.T) {
+	cases := []struct {
+		s            string
+		class, level int
+	}{
+		{"idle", IOPRIO_CLASS_IDLE, DEFAULT_IONICE_LEVEL},
+		{"best-effort:7", IOPRIO_CLASS_BEST_EFFORT, 7},
+		{"1:0", IOPRIO_CLASS_REALTIME, 0},
+	}
+	for _, c := range cases {
+		class, level, err := parseIoNice(c.s)
+		if err != nil || class != c.class || level != c.level {
+			t.Errorf("parseIoNice(%s) = %d, %d, %v", c.s, class, level, err)
+		}
+	}
+	for _, s := range []string{"", "fast", "idle:8", "2:x"} {
+		if _, _, err := parseIoNice(s); err == nil {
+			t.Errorf("parseIoNice(%q) should fail", s)
+		}
+	}
+}
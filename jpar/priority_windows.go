@@ -0,0 +1,16 @@
+package jpar
+
+import (
+	"errors"
+)
+
+// setPriority reports that priorities cannot be lowered on Windows.
+func setPriority(o *Options, pid int) error {
+	if o.IoNice != "" {
+		return errors.New("--ionice is only supported on Linux")
+	}
+	if o.Nice != 0 {
+		return errors.New("--nice is not supported on Windows")
+	}
+	return nil
+}
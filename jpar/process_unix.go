@@ -0,0 +1,101 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_SHELL = "/bin/sh"
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP",
+	syscall.SIGINT: "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL: "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS: "SIGBUS",
+	syscall.SIGFPE: "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGCHLD: "SIGCHLD",
+	syscall.SIGCONT: "SIGCONT",
+	syscall.SIGSTOP: "SIGSTOP",
+	syscall.SIGTSTP: "SIGTSTP",
+	syscall.SIGTTIN: "SIGTTIN",
+	syscall.SIGTTOU: "SIGTTOU",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+	syscall.SIGVTALRM: "SIGVTALRM",
+	syscall.SIGPROF: "SIGPROF",
+	syscall.SIGWINCH: "SIGWINCH",
+	syscall.SIGSYS: "SIGSYS",
+}
+
+// processGroup returns the attributes which start a command in its own
+// process group, unless process groups are disabled.
+func processGroup(o *Options) *syscall.SysProcAttr {
+	if o.NoProcessGroup {
+		return nil
+	}
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcess sends a signal to a command and, when it leads its own
+// process group, to all of its descendants.
+func signalProcess(o *Options, p *os.Process, sig syscall.Signal) error {
+	if o.NoProcessGroup {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, sig)
+}
+
+// killProcessGroup kills whatever remains of a command's process group.
+func killProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// rawStatus returns a wait status as the number reported in returncode.
+func rawStatus(stat syscall.WaitStatus) uint32 {
+	return uint32(stat)
+}
+
+// exitedStatus returns the wait status of a process which exited with
+// the given code.
+func exitedStatus(code int) syscall.WaitStatus {
+	return syscall.WaitStatus(code << 8)
+}
+
+// childCpuTime returns the user and system time used by all waited-for
+// child processes.
+func childCpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// maxRssBytes returns the peak resident set size of a command.  Darwin
+// reports it in bytes and other systems in kilobytes.  Linux counts the
+// memory of the process before it became the command, so it is never
+// less than jpar's own resident set size when the command started.
+func maxRssBytes(ps *os.ProcessState) (int64, bool) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss), true
+	}
+	return int64(ru.Maxrss) * 1024, true
+}
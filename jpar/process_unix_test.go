@@ -0,0 +1,34 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestMaxRssBytes(t *testing.T) {
+	c := exec.Command("true")
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	rss, ok := maxRssBytes(c.ProcessState)
+	if !ok || rss < 1024 {
+		t.Fatalf("expected the peak memory in bytes, got %d", rss)
+	}
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		t.Fatal(err)
+	}
+	self := int64(ru.Maxrss) * 1024
+	if runtime.GOOS == "darwin" {
+		self = int64(ru.Maxrss)
+	}
+	// A trivial command uses little memory of its own, so at most it
+	// reports the memory it had before it stopped being a copy of jpar.
+	if rss > self+8<<20 {
+		t.Errorf("expected at most %d bytes for a trivial command, got %d", self+8<<20, rss)
+	}
+}
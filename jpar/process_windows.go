@@ -0,0 +1,79 @@
+package jpar
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_SHELL = "cmd.exe"
+
+// Windows has no signals, but Go defines these names for portability.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP",
+	syscall.SIGINT: "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL: "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS: "SIGBUS",
+	syscall.SIGFPE: "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// processGroup returns the attributes which start a command in its own
+// process group, so console interrupts are left to jpar.
+func processGroup(o *Options) *syscall.SysProcAttr {
+	if o.NoProcessGroup {
+		return nil
+	}
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcess terminates a command, whatever the signal, and unless
+// process groups are disabled it terminates its descendants as well.
+func signalProcess(o *Options, p *os.Process, sig syscall.Signal) error {
+	if o.NoProcessGroup {
+		return p.Kill()
+	}
+	if err := killProcessGroup(p.Pid); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// killProcessGroup terminates a process and its descendants.  This is
+// best effort: descendants cannot be found once the process has exited.
+func killProcessGroup(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// rawStatus returns a wait status as the number reported in returncode,
+// which on Windows is the exit code.
+func rawStatus(stat syscall.WaitStatus) uint32 {
+	return stat.ExitCode
+}
+
+// exitedStatus returns the wait status of a process which exited with
+// the given code.
+func exitedStatus(code int) syscall.WaitStatus {
+	return syscall.WaitStatus{ExitCode: uint32(code)}
+}
+
+// childCpuTime returns zero, since Windows does not account for the time
+// used by child processes.
+func childCpuTime() time.Duration {
+	return 0
+}
+
+// maxRssBytes reports nothing, since Windows does not record the peak
+// memory of a process which has exited.
+func maxRssBytes(ps *os.ProcessState) (int64, bool) {
+	return 0, false
+}
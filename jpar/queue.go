@@ -0,0 +1,150 @@
+package jpar
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// DEFAULT_PRIORITY_QUEUE is how many jobs are read ahead of the workers
+// so that they can be reordered by priority, unless the queue size is
+// given.
+const DEFAULT_PRIORITY_QUEUE = 1000
+
+// recordPriority returns the priority of a record, read from a numeric
+// field.  Records without the field have priority zero.
+func recordPriority(field string, v interface{}) (float64, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok || m[field] == nil {
+		return 0, nil
+	}
+	switch x := m[field].(type) {
+	case float64:
+		return x, nil
+	case string:
+		p, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid priority in field %s: %s", field, err)
+		}
+		return p, nil
+	}
+	return 0, fmt.Errorf("priority field %s must be a number", field)
+}
+
+// prioritize passes jobs from in to out, holding up to size of them so
+// that the job with the highest priority is always sent first.  Jobs of
+// equal priority keep their order.  It returns once in is closed and
+// every job has been sent.
+func prioritize(in <-chan Job, out chan<- Job, size int, stats *queueStats) {
+	h := &jobHeap{}
+	for in != nil || h.Len() > 0 {
+		recv := in
+		if h.Len() >= size {
+			recv = nil
+		}
+		var send chan<- Job
+		var next Job
+		if h.Len() > 0 {
+			send = out
+			next = (*h)[0]
+		}
+		select {
+		case job, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			stats.queued(h.Len())
+			heap.Push(h, job)
+		case send <- next:
+			heap.Pop(h)
+			stats.set(h.Len())
+		}
+	}
+}
+
+// queueStats tracks how many jobs wait in the queue for a worker.
+type queueStats struct {
+	mu sync.Mutex
+	size int
+	depth int
+	max int
+	total int
+	count int
+}
+
+func newQueueStats(size int) *queueStats {
+	return &queueStats{size: size}
+}
+
+// queued records a job joining a queue which already held depth jobs.
+func (q *queueStats) queued(depth int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.total = q.total + depth
+	q.count = q.count + 1
+	q.depth = depth + 1
+	if q.depth > q.max {
+		q.max = q.depth
+	}
+}
+
+// set records the current depth of the queue.
+func (q *queueStats) set(depth int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.depth = depth
+}
+
+// current returns the number of jobs waiting in the queue.
+func (q *queueStats) current() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.depth
+}
+
+// record returns the queue statistics reported in the summary.  The
+// mean depth is the number of jobs found waiting by each new job.
+func (q *queueStats) record() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	mean := 0.0
+	if q.count > 0 {
+		mean = float64(q.total) / float64(q.count)
+	}
+	return map[string]interface{}{
+		"size": q.size,
+		"max_depth": q.max,
+		"mean_depth": mean,
+	}
+}
+
+// jobHeap orders jobs by descending priority, then by sequence number.
+type jobHeap []Job
+
+func (h jobHeap) Len() int {
+	return len(h)
+}
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].Seq < h[j].Seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	*h = append(*h, x.(Job))
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return job
+}
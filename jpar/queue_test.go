@@ -0,0 +1,69 @@
+package jpar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordPriority(t *testing.T) {
+	cases := []struct {
+		v interface{}
+		want float64
+		fails bool
+	}{
+		{map[string]interface{}{"p": 3.5}, 3.5, false},
+		{map[string]interface{}{"p": "-2"}, -2, false},
+		{map[string]interface{}{}, 0, false},
+		{"x", 0, false},
+		{map[string]interface{}{"p": "high"}, 0, true},
+		{map[string]interface{}{"p": true}, 0, true},
+	}
+	for _, c := range cases {
+		got, err := recordPriority("p", c.v)
+		if (err != nil) != c.fails || got != c.want {
+			t.Errorf("recordPriority(%v) = %v, %v", c.v, got, err)
+		}
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	in := make(chan Job)
+	out := make(chan Job)
+	stats := newQueueStats(10)
+	go prioritize(in, out, 10, stats)
+	for i, p := range []float64{1, 5, 0, 5, 3} {
+		in <- Job{Seq: i, Priority: p}
+	}
+	close(in)
+	want := []int{1, 3, 4, 0, 2}
+	for _, seq := range want {
+		if job := <-out; job.Seq != seq {
+			t.Errorf("got job %d, want %d", job.Seq, seq)
+		}
+	}
+	r := stats.record()
+	if r["max_depth"] != 5 || r["mean_depth"] != 2.0 {
+		t.Errorf("unexpected queue stats %v", r)
+	}
+}
+
+func TestPrioritizeBounded(t *testing.T) {
+	in := make(chan Job)
+	out := make(chan Job)
+	go prioritize(in, out, 2, newQueueStats(2))
+	in <- Job{Seq: 0}
+	in <- Job{Seq: 1}
+	select {
+	case in <- Job{Seq: 2}:
+		t.Fatal("a full queue should not accept more jobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-out
+	in <- Job{Seq: 2}
+	close(in)
+	for _, seq := range []int{1, 2} {
+		if job := <-out; job.Seq != seq {
+			t.Errorf("got job %d, want %d", job.Seq, seq)
+		}
+	}
+}
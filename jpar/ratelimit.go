@@ -0,0 +1,57 @@
+package jpar
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRate parses a rate such as "10/s", "300/m", or "1/5s" into the
+// interval between consecutive events.
+func ParseRate(s string) (time.Duration, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("rate %s must have the form N/UNIT", s)
+	}
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("rate %s must start with a positive number", s)
+	}
+	unit := parts[1]
+	if unit == "" || unit[0] < '0' || unit[0] > '9' {
+		unit = "1" + unit
+	}
+	d, err := time.ParseDuration(unit)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("rate %s has an invalid unit", s)
+	}
+	return time.Duration(float64(d) / n), nil
+}
+
+// tokenBucket returns a channel which yields one token per interval,
+// holding at most burst unused tokens.  The bucket starts full.  Tokens
+// stop being added once ctx is done.
+func tokenBucket(ctx context.Context, interval time.Duration, burst int) chan struct{} {
+	tokens := make(chan struct{}, burst)
+	for i := 0; i < burst; i++ {
+		tokens <- struct{}{}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return tokens
+}
@@ -0,0 +1,28 @@
+package jpar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]time.Duration{
+		"10/s":  100 * time.Millisecond,
+		"300/m": 200 * time.Millisecond,
+		"1/5s":  5 * time.Second,
+		"2/h":   30 * time.Minute,
+	}
+	for in, want := range cases {
+		got, err := ParseRate(in)
+		if err != nil {
+			t.Errorf("ParseRate(%s): %s", in, err)
+		} else if got != want {
+			t.Errorf("ParseRate(%s) = %s, want %s", in, got, want)
+		}
+	}
+	for _, in := range []string{"10", "0/s", "x/s", "10/parsec"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%s): expected error", in)
+		}
+	}
+}
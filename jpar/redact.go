@@ -0,0 +1,210 @@
+package jpar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const REDACTED = "[REDACTED]"
+
+// withRedaction returns the options with the secrets loaded and the
+// redaction patterns compiled, and with logs masked by them.
+func withRedaction(o *Options) (*Options, error) {
+	if len(o.Secrets) == 0 && len(o.Redact) == 0 {
+		return o, nil
+	}
+	c := *o
+	if len(o.Secrets) > 0 {
+		secrets, err := loadSecrets(o.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		c.secrets = secrets
+		c.redact = c.redact.withValues(secrets)
+	}
+	if len(o.Redact) > 0 {
+		patterns, err := compileRedactions(o.Redact)
+		if err != nil {
+			return nil, err
+		}
+		if c.redact == nil {
+			c.redact = &redactor{}
+		}
+		c.redact.patterns = patterns
+	}
+	if c.Logger != nil {
+		c.Logger = slog.New(&redactingHandler{c.Logger.Handler(), c.redact})
+	}
+	return &c, nil
+}
+
+// compileRedactions compiles the patterns given with --redact.
+func compileRedactions(exprs []string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, e := range exprs {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse redaction %s: %s", e, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// redactor masks sensitive text: literal values, such as secrets, and
+// text matching patterns.  A nil redactor masks nothing.
+type redactor struct {
+	values []string
+	patterns []*regexp.Regexp
+}
+
+// withValues returns a redactor which masks the values as well.
+func (rd *redactor) withValues(values map[string]string) *redactor {
+	n := &redactor{}
+	if rd != nil {
+		*n = *rd
+	}
+	n.values = append([]string{}, n.values...)
+	for _, v := range values {
+		if v != "" {
+			n.values = append(n.values, v)
+		}
+	}
+	// Longer values go first, so a secret containing another is masked
+	// whole.
+	sort.Slice(n.values, func(i, j int) bool { return len(n.values[i]) > len(n.values[j]) })
+	return n
+}
+
+func (rd *redactor) string(s string) string {
+	if rd == nil {
+		return s
+	}
+	for _, v := range rd.values {
+		s = strings.Replace(s, v, REDACTED, -1)
+	}
+	for _, re := range rd.patterns {
+		s = re.ReplaceAllLiteralString(s, REDACTED)
+	}
+	return s
+}
+
+// value masks every string within a value, returning a copy.
+func (rd *redactor) value(v interface{}) interface{} {
+	if rd == nil {
+		return v
+	}
+	switch x := v.(type) {
+	case string:
+		return rd.string(x)
+	case []string:
+		l := make([]string, len(x))
+		for i, e := range x {
+			l[i] = rd.string(e)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(x))
+		for i, e := range x {
+			l[i] = rd.value(e)
+		}
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = rd.value(e)
+		}
+		return m
+	case map[string]string:
+		m := make(map[string]string, len(x))
+		for k, e := range x {
+			m[k] = rd.string(e)
+		}
+		return m
+	}
+	return v
+}
+
+// forRecord returns a redactor which also masks the values of a
+// record's fields named with --redact-field.
+func (rd *redactor) forRecord(fields []string, record interface{}) *redactor {
+	values := map[string]string{}
+	for _, f := range fields {
+		v, ok := lookupVariable([]interface{}{record}, f)
+		if !ok || v == nil {
+			continue
+		}
+		s, isString := v.(string)
+		if !isString {
+			b, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			s = string(b)
+		}
+		values[f] = s
+	}
+	if len(values) == 0 {
+		return rd
+	}
+	return rd.withValues(values)
+}
+
+// result masks a result in place.
+func (rd *redactor) result(r map[string]interface{}) {
+	if rd == nil {
+		return
+	}
+	for k, v := range r {
+		r[k] = rd.value(v)
+	}
+}
+
+// redactingHandler masks the messages and attributes of logs.
+type redactingHandler struct {
+	slog.Handler
+	redact *redactor
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	masked := slog.NewRecord(rec.Time, rec.Level, h.redact.string(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, masked)
+}
+
+func (h *redactingHandler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.redact.string(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, g := range v.Group() {
+			attrs = append(attrs, h.attr(g))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		return slog.Any(a.Key, h.redact.value(v.Any()))
+	}
+	return a
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := []slog.Attr{}
+	for _, a := range attrs {
+		masked = append(masked, h.attr(a))
+	}
+	return &redactingHandler{h.Handler.WithAttrs(masked), h.redact}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{h.Handler.WithGroup(name), h.redact}
+}
@@ -0,0 +1,84 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	rd := (*redactor)(nil).withValues(map[string]string{"a": "abc", "b": "abcdef", "c": ""})
+	r := map[string]interface{}{
+		"command": []string{"curl", "-H", "token: abcdef"},
+		"stdout": "abc and abcdef",
+		"e": map[string]interface{}{"list": []interface{}{"xabcx", 3.0}},
+	}
+	rd.result(r)
+	if r["command"].([]string)[2] != "token: [REDACTED]" {
+		t.Errorf("expected the command to be masked, got %v", r["command"])
+	}
+	if r["stdout"] != "[REDACTED] and [REDACTED]" {
+		t.Errorf("expected stdout to be masked, got %v", r["stdout"])
+	}
+	if l := r["e"].(map[string]interface{})["list"].([]interface{}); l[0] != "x[REDACTED]x" || l[1] != 3.0 {
+		t.Errorf("expected nested values to be masked, got %v", l)
+	}
+	if (*redactor)(nil).string("abc") != "abc" {
+		t.Error("expected a nil redactor to mask nothing")
+	}
+}
+
+func TestRedactorPatterns(t *testing.T) {
+	patterns, err := compileRedactions([]string{`ghp_[a-z0-9]+`, `\d{3}-\d{2}-\d{4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := &redactor{patterns: patterns}
+	if got := rd.string("token ghp_abc123 for 123-45-6789"); got != "token [REDACTED] for [REDACTED]" {
+		t.Errorf("expected matches to be masked, got %s", got)
+	}
+	if _, err := compileRedactions([]string{"("}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	record := map[string]interface{}{"customer": map[string]interface{}{"email": "a@example.com", "id": 7.0}}
+	r := rd.forRecord([]string{"customer.email", "customer.id", "missing"}, record)
+	if got := r.string("mailed a@example.com about 7"); got != "mailed [REDACTED] about [REDACTED]" {
+		t.Errorf("expected the record's fields to be masked, got %s", got)
+	}
+	if rd.forRecord([]string{"missing"}, record) != rd {
+		t.Error("expected the same redactor when no field is found")
+	}
+}
+
+func TestRunnerRedact(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "user {{user}} key {{key}}"}
+	o.Redact = []string{`key-[0-9]+`}
+	o.RedactFields = []string{"user"}
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"user":"alice","key":"key-42"}`), &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "alice") || strings.Contains(out.String(), "key-42") {
+		t.Errorf("expected the user and key to be masked, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"stdout":"user [REDACTED] key [REDACTED]\n"`) {
+		t.Errorf("expected masked output, got %s", out.String())
+	}
+	o.Redact = []string{"("}
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{}`), &out); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var logs bytes.Buffer
+	rd := (*redactor)(nil).withValues(map[string]string{"t": "s3cret"})
+	lg := slog.New(&redactingHandler{slog.NewJSONHandler(&logs, nil), rd})
+	lg.With("fixed", "s3cret").Info("sending s3cret", "token", "s3cret", "args", []string{"x", "s3cret"}, slog.Group("g", "v", "s3cret"))
+	if strings.Contains(logs.String(), "s3cret") || strings.Count(logs.String(), REDACTED) != 5 {
+		t.Errorf("expected every secret in the log to be masked, got %s", logs.String())
+	}
+}
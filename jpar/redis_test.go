@@ -0,0 +1,44 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestOpenRedis(t *testing.T) {
+	r, err := openRedis("redis://u:p@localhost:6380/jobs?db=2&type=stream&group=g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := r.client.Options()
+	if o.Addr != "localhost:6380" || o.Username != "u" || o.Password != "p" || o.DB != 2 {
+		t.Errorf("got options %+v", o)
+	}
+	if r.key != "jobs" || r.params.Get("type") != REDIS_STREAM || r.params.Get("group") != "g" {
+		t.Errorf("got key %s and parameters %v", r.key, r.params)
+	}
+	r.client.Close()
+	r, err = openRedis("redis://localhost/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.params.Get("type") != REDIS_LIST {
+		t.Errorf("expected a list by default, got %s", r.params.Get("type"))
+	}
+	r.client.Close()
+	for _, addr := range []string{"redis://localhost", "redis://localhost/jobs?type=set", "redis://localhost/jobs?db=x"} {
+		if _, err := openRedis(addr); err == nil {
+			t.Errorf("%s: expected an error", addr)
+		}
+	}
+}
+
+func TestRedisRecord(t *testing.T) {
+	x := redisRecord(`{"n":1}`, nil)
+	if x.Err != nil || x.Value.(map[string]interface{})["n"] != 1.0 {
+		t.Errorf("got %+v", x)
+	}
+	x = redisRecord(`{"n":`, func(bool) {})
+	if x.Err == nil || x.Text != `{"n":` || x.Ack == nil {
+		t.Errorf("expected a parse error which can be acknowledged, got %+v", x)
+	}
+}
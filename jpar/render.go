@@ -0,0 +1,36 @@
+package jpar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runRenderJob writes the body expanded for a record to the path
+// expanded from --render-to, instead of running a command.  Missing
+// directories are created.
+func runRenderJob(cmd *CommandTemplate, job interface{}, meta map[string]interface{}, r map[string]interface{}) map[string]interface{} {
+	delete(r, "command")
+	path := render(cmd.RenderTo, job, meta)
+	r["path"] = path
+	if path == "" {
+		r["error"] = "the render path is empty"
+		return r
+	}
+	body := render(cmd.Body, job, meta)
+	start := time.Now()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(body), 0644)
+	}
+	r["duration_ms"] = time.Since(start).Milliseconds()
+	if err != nil {
+		r["error"] = fmt.Sprintf("cannot render %s: %s", path, err)
+		return r
+	}
+	r["bytes"] = len(body)
+	r["returncode"] = uint32(0)
+	r["outcome"] = OUTCOME_SUCCESS
+	return r
+}
@@ -0,0 +1,57 @@
+package jpar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunRenderJob(t *testing.T) {
+	dir := t.TempDir()
+	o := &Options{}
+	cmd := &CommandTemplate{
+		RenderTo: parseTemplate(t, dir+"/{{host}}/app.conf"),
+		Body: parseTemplate(t, "port={{port}}\n"),
+	}
+	job := map[string]interface{}{"host": "a", "port": 80}
+	r := runJob(context.Background(), o, cmd, job, nil)
+	path := filepath.Join(dir, "a", "app.conf")
+	if r["outcome"] != OUTCOME_SUCCESS || r["path"] != path || r["bytes"] != 8 {
+		t.Fatalf("unexpected result %v", r)
+	}
+	if _, ok := r["command"]; ok {
+		t.Errorf("expected no command, got %v", r["command"])
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "port=80\n" {
+		t.Errorf("unexpected file %q, %v", b, err)
+	}
+	os.WriteFile(filepath.Join(dir, "b"), nil, 0644)
+	job["host"] = "b"
+	r = runJob(context.Background(), o, cmd, job, nil)
+	if r["outcome"] != OUTCOME_FAILURE || r["error"] == nil {
+		t.Errorf("expected a failure when the directory is a file, got %v", r)
+	}
+}
+
+func TestRenderToOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(o *Options)
+		valid bool
+	}{
+		{"body", func(o *Options) { o.Body = "x" }, true},
+		{"no body", func(o *Options) {}, false},
+		{"command", func(o *Options) { o.Body = "x"; o.Args = []string{"echo"} }, false},
+		{"http", func(o *Options) { o.Body = "x"; o.HTTP = true }, false},
+	}
+	for _, tc := range tests {
+		o := NewOptions()
+		o.RenderTo = "out/{{id}}"
+		tc.opts(o)
+		if err := validateOptions(o); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
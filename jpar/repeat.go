@@ -0,0 +1,58 @@
+package jpar
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// runRepeated runs a job n times, one run after another, and adds the
+// statistics of the runs to the result under repeat.  The result is that
+// of the first run which failed, or of the last run when none did.
+func runRepeated(ctx context.Context, n int, run func() map[string]interface{}) map[string]interface{} {
+	if n <= 1 {
+		return run()
+	}
+	durations := []int64{}
+	failures := 0
+	var r, failed map[string]interface{}
+	for i := 0; i < n && (i == 0 || ctx.Err() == nil); i++ {
+		start := time.Now()
+		r = run()
+		durations = append(durations, int64(time.Since(start)))
+		if jobFailed(r) {
+			failures = failures + 1
+			if failed == nil {
+				failed = r
+			}
+		}
+	}
+	if failed != nil {
+		r = failed
+	}
+	r["repeat"] = repeatStats(durations, failures)
+	return r
+}
+
+// repeatStats summarizes the durations of repeated runs, in
+// milliseconds with microsecond precision.
+func repeatStats(durations []int64, failures int) map[string]interface{} {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	ms := func(d int64) float64 {
+		return float64(time.Duration(d).Microseconds()) / 1000
+	}
+	var total int64
+	for _, d := range durations {
+		total = total + d
+	}
+	return map[string]interface{}{
+		"runs": len(durations),
+		"failures": failures,
+		"failure_rate": float64(failures) / float64(len(durations)),
+		"min_ms": ms(durations[0]),
+		"median_ms": ms(percentile(durations, 50)),
+		"mean_ms": ms(total / int64(len(durations))),
+		"p95_ms": ms(percentile(durations, 95)),
+		"max_ms": ms(durations[len(durations)-1]),
+	}
+}
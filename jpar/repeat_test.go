@@ -0,0 +1,48 @@
+package jpar
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunRepeated(t *testing.T) {
+	runs := 0
+	r := runRepeated(context.Background(), 4, func() map[string]interface{} {
+		runs = runs + 1
+		outcome := OUTCOME_SUCCESS
+		if runs == 2 {
+			outcome = OUTCOME_FAILURE
+		}
+		return map[string]interface{}{"outcome": outcome, "run": runs}
+	})
+	if runs != 4 {
+		t.Errorf("expected 4 runs, got %d", runs)
+	}
+	if r["run"] != 2 || r["outcome"] != OUTCOME_FAILURE {
+		t.Errorf("expected the result of the failed run, got %v", r)
+	}
+	stats := r["repeat"].(map[string]interface{})
+	if stats["runs"] != 4 || stats["failures"] != 1 || stats["failure_rate"] != 0.25 {
+		t.Errorf("unexpected statistics %v", stats)
+	}
+	r = runRepeated(context.Background(), 1, func() map[string]interface{} {
+		return map[string]interface{}{"outcome": OUTCOME_SUCCESS}
+	})
+	if _, ok := r["repeat"]; ok {
+		t.Error("expected no statistics for a single run")
+	}
+}
+
+func TestRepeatStats(t *testing.T) {
+	durations := []int64{}
+	for _, ms := range []int64{5, 1, 3, 2, 4} {
+		durations = append(durations, ms*1000000)
+	}
+	stats := repeatStats(durations, 0)
+	want := map[string]float64{"min_ms": 1, "median_ms": 3, "mean_ms": 3, "p95_ms": 5, "max_ms": 5}
+	for k, v := range want {
+		if stats[k] != v {
+			t.Errorf("%s: got %v, want %v", k, stats[k], v)
+		}
+	}
+}
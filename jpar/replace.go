@@ -0,0 +1,42 @@
+package jpar
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// replaceCommand substitutes the placeholder in each word of a command
+// given with --replace, as xargs -I does.  The words are not templates,
+// so braces in them are passed through untouched.
+func replaceCommand(o *Options, words []string, job interface{}) []string {
+	value := replaceValue(o, job)
+	if quotesValues(o) {
+		value = shellQuoter(o.ShellPath)(value)
+	}
+	r := []string{}
+	for _, w := range words {
+		r = append(r, strings.Replace(w, o.Replace, value, -1))
+	}
+	return r
+}
+
+// replaceValue is the text which replaces the placeholder: the record,
+// or the field named by --replace-field.  Strings are used as they are,
+// and other values are encoded as JSON.
+func replaceValue(o *Options, job interface{}) string {
+	v := job
+	if o.ReplaceField != "" {
+		var ok bool
+		if v, ok = lookupVariable([]interface{}{job}, o.ReplaceField); !ok {
+			return ""
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
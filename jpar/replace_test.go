@@ -0,0 +1,32 @@
+package jpar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplaceCommand(t *testing.T) {
+	job := map[string]interface{}{"id": "a b", "n": 2.0, "obj": map[string]interface{}{"k": "v"}}
+	cases := []struct {
+		field string
+		shell bool
+		words []string
+		want []string
+	}{
+		{"id", false, []string{"echo", "{{x}}", "id={}"}, []string{"echo", "{{x}}", "id=a b"}},
+		{"n", false, []string{"{}{}"}, []string{"22"}},
+		{"obj.k", false, []string{"{}"}, []string{"v"}},
+		{"missing", false, []string{"[{}]"}, []string{"[]"}},
+		{"", false, []string{"{}"}, []string{`{"id":"a b","n":2,"obj":{"k":"v"}}`}},
+		{"id", true, []string{"echo", "{}"}, []string{"echo", "'a b'"}},
+	}
+	for _, c := range cases {
+		o := NewOptions()
+		o.Replace = "{}"
+		o.ReplaceField = c.field
+		o.Shell = c.shell
+		if got := replaceCommand(o, c.words, job); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %v: got %q, want %q", c.field, c.words, got, c.want)
+		}
+	}
+}
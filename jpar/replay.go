@@ -0,0 +1,25 @@
+package jpar
+
+// replayRecord reads a result written by an earlier run.  It returns the
+// input record held in e, which is nil when it was not echoed, and the
+// command which was run.  ok is false when the result is not replayed
+// because only failures are.
+func replayRecord(o *Options, v interface{}) (interface{}, []string, bool) {
+	r, isResult := v.(map[string]interface{})
+	if !isResult {
+		return v, nil, !o.ReplayFailures
+	}
+	if o.ReplayFailures && !jobFailed(r) {
+		return nil, nil, false
+	}
+	command := []string{}
+	args, _ := r["command"].([]interface{})
+	for _, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return r["e"], nil, true
+		}
+		command = append(command, s)
+	}
+	return r["e"], command, true
+}
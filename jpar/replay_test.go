@@ -0,0 +1,53 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayRecord(t *testing.T) {
+	e := map[string]interface{}{"n": 1.0}
+	failed := map[string]interface{}{"command": []interface{}{"false"}, "e": e, "outcome": OUTCOME_FAILURE}
+	succeeded := map[string]interface{}{"command": []interface{}{"echo", "1"}, "outcome": OUTCOME_SUCCESS}
+	o := &Options{}
+	v, command, ok := replayRecord(o, failed)
+	if !ok || !reflect.DeepEqual(v, e) || !reflect.DeepEqual(command, []string{"false"}) {
+		t.Errorf("unexpected replay %v %v %v", v, command, ok)
+	}
+	v, command, ok = replayRecord(o, succeeded)
+	if !ok || v != nil || !reflect.DeepEqual(command, []string{"echo", "1"}) {
+		t.Errorf("unexpected replay %v %v %v", v, command, ok)
+	}
+	o.ReplayFailures = true
+	if _, _, ok := replayRecord(o, succeeded); ok {
+		t.Error("expected only failures to be replayed")
+	}
+	if _, command, ok := replayRecord(o, map[string]interface{}{"command": []interface{}{}, "outcome": OUTCOME_FAILURE}); !ok || len(command) != 0 {
+		t.Errorf("expected a failure without a command, got %v %v", command, ok)
+	}
+}
+
+func TestRunnerReplay(t *testing.T) {
+	o := NewOptions()
+	o.Replay = true
+	o.KeepOrder = true
+	o.EchoInput = ECHO_INPUT_ALWAYS
+	input := `{"command":["echo","{{n}}"],"e":{"n":1},"outcome":"FAILURE"}{"command":[],"outcome":"FAILURE"}`
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 results, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], `"e":{"n":1}`) || !strings.Contains(lines[0], `"stdout":"{{n}}\n"`) {
+		t.Errorf("expected the recorded command to run unexpanded, got %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"reason":"no command recorded"`) {
+		t.Errorf("expected a skipped result, got %s", lines[1])
+	}
+}
@@ -0,0 +1,120 @@
+package jpar
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const REPORT_JUNIT string = "junit"
+const REPORT_HTML string = "html"
+
+// reportFormat collects results for a report which is written once the
+// run has finished.
+type reportFormat interface {
+	add(seq int, r map[string]interface{})
+	write(w io.Writer) error
+}
+
+// reportFile is a report given with --report FORMAT=PATH.
+type reportFile struct {
+	path string
+	format reportFormat
+}
+
+// parseReports parses reports given as FORMAT=PATH.
+func parseReports(reports []string) ([]*reportFile, error) {
+	files := []*reportFile{}
+	for _, r := range reports {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("report %s must have the form FORMAT=PATH", r)
+		}
+		f := &reportFile{path: parts[1]}
+		switch parts[0] {
+		case REPORT_JUNIT:
+			f.format = newJunitReport()
+		case REPORT_HTML:
+			f.format = newHtmlReport()
+		default:
+			return nil, fmt.Errorf("unknown report format %s", parts[0])
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// addReports passes a result to every report.  Output events are not
+// results.
+func addReports(reports []*reportFile, x Output) {
+	r, ok := x.Value.(map[string]interface{})
+	if !ok || x.Event {
+		return
+	}
+	for _, f := range reports {
+		f.format.add(x.Seq, r)
+	}
+}
+
+// writeReports writes every report, returning the first error.
+func writeReports(reports []*reportFile) error {
+	var first error
+	for _, f := range reports {
+		err := writeReport(f)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func writeReport(f *reportFile) error {
+	w, err := os.Create(f.path)
+	if err != nil {
+		return fmt.Errorf("cannot create report %s: %s", f.path, err)
+	}
+	if err := f.format.write(w); err != nil {
+		w.Close()
+		return fmt.Errorf("cannot write report %s: %s", f.path, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("cannot write report %s: %s", f.path, err)
+	}
+	return nil
+}
+
+// reportName names a job in a report by its key, or by its tags, or
+// failing those by its sequence number.
+func reportName(seq int, r map[string]interface{}) string {
+	if key, ok := r["key"].(string); ok && key != "" {
+		return key
+	}
+	if tags, ok := r["tags"].(map[string]interface{}); ok && len(tags) > 0 {
+		names := []string{}
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := []string{}
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("%s=%v", name, tags[name]))
+		}
+		return strings.Join(parts, " ")
+	}
+	return fmt.Sprintf("job %d", seq)
+}
+
+// resultSeconds is the duration of a job in seconds.
+func resultSeconds(r map[string]interface{}) float64 {
+	switch d := r["duration_ms"].(type) {
+	case int64:
+		return float64(d) / 1000
+	case int:
+		return float64(d) / 1000
+	case float64:
+		return d / 1000
+	}
+	return 0
+}
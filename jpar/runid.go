@@ -0,0 +1,33 @@
+package jpar
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newRunId returns a random version 4 UUID which identifies a run.
+func newRunId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// identifyResult returns a copy of a result with the run which
+// produced it and the result's sequence number within it, so results
+// from several runs can be told apart.  The result itself is left alone,
+// as the worker which produced it may still be reading it.
+func identifyResult(v interface{}, runId string, seq int) interface{} {
+	r, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	identified := make(map[string]interface{}, len(r)+2)
+	for k, x := range r {
+		identified[k] = x
+	}
+	identified["job_id"] = seq
+	identified["run_id"] = runId
+	return identified
+}
@@ -0,0 +1,75 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewRunId(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	id := newRunId()
+	if !uuid.MatchString(id) {
+		t.Errorf("expected a version 4 UUID, got %s", id)
+	}
+	if newRunId() == id {
+		t.Error("expected each run to get a different id")
+	}
+}
+
+// withoutIds removes the run_id and job_id from a result written as a
+// line of JSON, so tests of other fields need not know them.
+func withoutIds(t *testing.T, line string) string {
+	r := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &r); err != nil {
+		return line
+	}
+	delete(r, "run_id")
+	delete(r, "job_id")
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// runIds runs a command for each record, returning the run_id and
+// job_id of each result.
+func runIds(t *testing.T, o *Options, input string) ([]string, map[float64]bool) {
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	runs := []string{}
+	jobs := map[float64]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		r := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, r["run_id"].(string))
+		jobs[r["job_id"].(float64)] = true
+	}
+	return runs, jobs
+}
+
+func TestRunnerResultIds(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	o.When = ".n != 2"
+	o.EmitSkipped = true
+	runs, jobs := runIds(t, o, `{"n":1} {"n":2} {"n":3}`)
+	if len(runs) != 3 || runs[0] != runs[1] || runs[1] != runs[2] {
+		t.Errorf("expected every result to have the same run_id, got %v", runs)
+	}
+	if !jobs[0] || !jobs[1] || !jobs[2] {
+		t.Errorf("expected job_ids 0, 1, and 2, got %v", jobs)
+	}
+	again, _ := runIds(t, o, `{"n":1}`)
+	if again[0] == runs[0] {
+		t.Error("expected another run to have another run_id")
+	}
+}
@@ -0,0 +1,1171 @@
+package jpar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Runner executes a command template once for every input record,
+// running up to Options.Parallelism commands at a time.
+type Runner struct {
+	opts *Options
+}
+
+func NewRunner(opts *Options) *Runner {
+	return &Runner{opts: opts}
+}
+
+// Run reads records from input, runs a command for each of them, and
+// writes the results to output.  Cancelling ctx shuts the run down
+// gracefully: no more input is read and running commands are sent
+// SIGTERM.  Run then returns ctx.Err().
+func (runner *Runner) Run(ctx context.Context, input io.Reader, output io.Writer) error {
+	o := runner.opts
+	if err := validateOptions(o); err != nil {
+		return err
+	}
+	o, err := withRedaction(o)
+	if err != nil {
+		return err
+	}
+	renames, err := parseRenames(o.Rename)
+	if err != nil {
+		return err
+	}
+	output = resultWriter(o, renames, output)
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		return err
+	}
+	filter, err := compileFilter(o.Filter)
+	if err != nil {
+		return err
+	}
+	when, err := compileCondition(o.When)
+	if err != nil {
+		return err
+	}
+	outputFilter, err := compileOutputFilter(o.OutputFilter)
+	if err != nil {
+		return err
+	}
+	var j chan JsonRead
+	if len(o.Inputs) > 0 {
+		j, err = readInputFiles(o, o.Inputs, input)
+	} else {
+		j, err = readInput(o, input)
+	}
+	if err != nil {
+		return err
+	}
+	var state *StateFile
+	if o.StateFile != "" {
+		state, err = OpenStateFile(o.StateFile)
+		if err != nil {
+			return err
+		}
+		defer state.Close()
+	}
+	useKeys := o.Key != "" || state != nil
+	lg := logger(o)
+	// seen maps the dedupe key of each record to its position.
+	var seen map[string]int
+	if o.Dedupe || o.DedupeKey != "" {
+		seen = map[string]int{}
+	}
+	sampler := newInputSampler(o)
+	hook := newWebhook(o)
+	var summary *runSummary
+	if o.Summary || (hook != nil && o.WebhookOn == WEBHOOK_ON_SUMMARY) {
+		summary = newRunSummary()
+	}
+	reports, err := parseReports(o.Reports)
+	if err != nil {
+		return err
+	}
+	systemLog, err := openResultLog(o)
+	if err != nil {
+		return err
+	}
+	if systemLog != nil {
+		defer systemLog.Close()
+	}
+	tracer, err := startTracing(o, "jpar run")
+	if err != nil {
+		return err
+	}
+	metrics, err := openStatsd(o)
+	if err != nil {
+		return err
+	}
+	defer metrics.Close()
+	// Cancelling ctx stops input from being read.  Running commands
+	// receive SIGTERM, and they are killed if they are still running
+	// after the grace period.  Halting on error cancels in the same way.
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	// Signals and commands sent to the control socket pause and resume
+	// the run, and change the number of workers.
+	ctl := newRunControl(o.Parallelism)
+	ctl.keyed = useKeys
+	defer notifyControl(lg, ctl)()
+	if o.Control != "" {
+		l, err := listenControl(ctx, lg, o.Control, ctl)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+	}
+	halted := false
+	// inputErr is set when --strict-input aborts the run.
+	var inputErr error
+	// stateErr is set when the state file cannot be updated, which aborts
+	// the run rather than losing track of completed jobs.
+	var stateErr error
+	ran := 0
+	failed := 0
+	runId := newRunId()
+	lg.Debug("run started", "run_id", runId)
+	jobs := make(chan Job)
+	// Jobs are sent to the workers through queue.  With --queue-size or
+	// --priority-field it holds jobs read ahead of the workers, and the
+	// most important run first.
+	queue := jobs
+	var queueDepth *queueStats
+	size := o.QueueSize
+	if size == 0 && o.PriorityField != "" {
+		size = DEFAULT_PRIORITY_QUEUE
+	}
+	if size > 0 {
+		queue = make(chan Job)
+		queueDepth = newQueueStats(size)
+		go prioritize(queue, jobs, size, queueDepth)
+		if summary != nil {
+			summary.queue = queueDepth
+		}
+	}
+	results := make(chan Output)
+	inputDone := make(chan struct{})
+	workerDone := make(chan struct{})
+	outputDone := make(chan struct{})
+	// With --keep-order each record holds a slot in the window from
+	// dispatch until its result is written, which bounds the number of
+	// results waiting in the reorder buffer.
+	var window chan struct{}
+	if o.KeepOrder {
+		window = make(chan struct{}, o.ReorderBuffer)
+	}
+	// With --rate each job launch consumes a token.
+	var tokens chan struct{}
+	if o.Rate > 0 {
+		tokens = tokenBucket(ctx, o.Rate, o.RateBurst)
+	}
+	// pending counts dispatched jobs whose final result has not yet been
+	// produced, including jobs waiting to be requeued.
+	var pending sync.WaitGroup
+	// With --group-by jobs wait in per-group queues until their group
+	// has room.
+	var groups *groupLimiter
+	if cmd.GroupBy != nil {
+		groups = newGroupLimiter(o.GroupParallelism)
+	}
+	// With --dag jobs wait in the dependency graph until the jobs they
+	// depend on have succeeded.
+	var graph *dependencyGraph
+	if o.Dag {
+		graph = newDependencyGraph()
+	}
+	var finish func(job Job, r map[string]interface{})
+	// start hands a job whose dependencies have succeeded to the workers
+	// once its group has room.
+	start := func(job Job) {
+		if groups != nil {
+			job.Group = render(cmd.GroupBy, job.Value, nil)
+			if !groups.acquire(job) {
+				return
+			}
+		}
+		go func() {
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				finish(job, nil)
+			}
+		}()
+	}
+	// settle tells the dependency graph how a job ended.  Waiting jobs
+	// which can now run are started, and those which never will are
+	// failed in turn.
+	var settle func(job Job, r map[string]interface{})
+	settle = func(job Job, r map[string]interface{}) {
+		if graph == nil || job.Id == "" {
+			return
+		}
+		ready, failed := graph.complete(job.Id, r != nil && !jobFailed(r))
+		for _, next := range ready {
+			lg.Debug("job dependencies met", "seq", next.Seq, "id", next.Id)
+			start(next)
+		}
+		for _, f := range failed {
+			fr := dependencyFailure(f)
+			results <- Output{Value: fr, Seq: f.Job.Seq, Ack: f.Job.Ack}
+			pending.Done()
+			settle(f.Job, fr)
+		}
+	}
+	// finish is called once a job's final result has been produced, or
+	// with a nil result when it was never run.  It hands the job's group
+	// over to the next queued job of the group.
+	finish = func(job Job, r map[string]interface{}) {
+		pending.Done()
+		settle(job, r)
+		if groups == nil {
+			return
+		}
+		next, ok := groups.release(job.Group)
+		if !ok {
+			return
+		}
+		go func() {
+			select {
+			case queue <- next:
+			case <-ctx.Done():
+				finish(next, nil)
+			}
+		}()
+	}
+	// requeue feeds a failed job back to the workers after the retry
+	// delay.  If shutdown begins first, its last result is final.
+	requeue := func(job Job, last map[string]interface{}) {
+		lg.Info("requeueing job", "seq", job.Seq, "attempt", job.Attempt)
+		go func() {
+			select {
+			case <-time.After(retryDelay(o, job.Attempt)):
+				select {
+				case queue <- job:
+					return
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+			}
+			finishAttempts(o, last, job.Attempt, job.History)
+			results <- Output{Value: last, Seq: job.Seq, Ack: job.Ack}
+			finish(job, last)
+		}()
+	}
+	// With --tui a dashboard is drawn on the terminal until the jobs
+	// have finished.  Quitting it shuts the run down.
+	var dash *dashboard
+	closeDashboard := func() {}
+	if o.Tui {
+		dash = newDashboard(o, ctl, queueDepth, cancel)
+		stop, err := openDashboard(lg, dash)
+		if err != nil {
+			return err
+		}
+		var once sync.Once
+		closeDashboard = func() {
+			once.Do(stop)
+		}
+		defer closeDashboard()
+	}
+	// Launch workers
+	ctl.launch(func(id int) {
+		go worker(ctx, o, id, cmd, jobs, results, workerDone, finish, requeue, ctl)
+	})
+	// Display results from workers
+	go func() {
+		// Feed input to workers
+		seq := 0
+		// reserve claims a slot in the reorder window.  It and the
+		// functions below return false once shutdown has begun.
+		reserve := func() bool {
+			if window == nil {
+				return true
+			}
+			select {
+			case window <- struct{}{}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// replayCommand is the recorded command of the record being
+		// dispatched when replaying results.
+		var replayCommand []string
+		// ack acknowledges the record being read, once each result made
+		// from it has been produced.
+		var ack *recordAck
+		dispatch := func(v interface{}) bool {
+			if !reserve() {
+				return false
+			}
+			if tokens != nil {
+				select {
+				case <-tokens:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			job := Job{Value: v, Seq: seq, Command: replayCommand, Ack: ack}
+			if o.PriorityField != "" {
+				p, err := recordPriority(o.PriorityField, v)
+				if err != nil {
+					lg.Warn("cannot read priority", "seq", seq, "error", err.Error())
+				}
+				job.Priority = p
+			}
+			if useKeys {
+				job.Key = jobKey(cmd, v)
+			}
+			pending.Add(1)
+			ack.add()
+			if graph != nil {
+				job.Id = recordId(o, v)
+				job.DependsOn = recordDependencies(o, v)
+				ready, err := graph.add(job)
+				if err != nil {
+					if errors.Is(err, errDuplicateId) {
+						job.Id = ""
+					}
+					r := dependencyFailure(dagFailure{Job: job, Error: err.Error()})
+					results <- Output{Value: r, Seq: seq, Ack: ack}
+					pending.Done()
+					settle(job, r)
+					seq = seq + 1
+					return true
+				}
+				if !ready {
+					lg.Debug("job waiting for dependencies", "seq", seq, "id", job.Id)
+					seq = seq + 1
+					return true
+				}
+			}
+			if groups != nil {
+				job.Group = render(cmd.GroupBy, v, nil)
+				if !groups.acquire(job) {
+					lg.Debug("job queued for group", "seq", seq, "group", job.Group)
+					seq = seq + 1
+					return true
+				}
+			}
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				finish(job, nil)
+				return false
+			}
+			if queueDepth != nil {
+				lg.Debug("job queued", "seq", seq, "key", job.Key, "queue_depth", queueDepth.current())
+			} else {
+				lg.Debug("job dispatched", "seq", seq, "key", job.Key)
+			}
+			seq = seq + 1
+			return true
+		}
+		emit := func(r map[string]interface{}) bool {
+			if !reserve() {
+				return false
+			}
+			ack.add()
+			results <- Output{Value: r, Seq: seq, Ack: ack}
+			seq = seq + 1
+			return true
+		}
+		// With --batch records are collected into batches, which are
+		// dispatched when full, when their timeout expires, or at the end
+		// of the input.
+		var batch []interface{}
+		var batchTimer *time.Timer
+		var batchExpired <-chan time.Time
+		flushBatch := func() bool {
+			if len(batch) == 0 {
+				return true
+			}
+			v := map[string]interface{}{"items": batch}
+			batch = nil
+			if batchTimer != nil {
+				batchTimer.Stop()
+				batchTimer = nil
+				batchExpired = nil
+			}
+			return dispatch(v)
+		}
+		submit := func(v interface{}) bool {
+			if o.Batch == 0 {
+				return dispatch(v)
+			}
+			batch = append(batch, v)
+			if len(batch) >= o.Batch {
+				return flushBatch()
+			}
+			if len(batch) == 1 && o.BatchTimeout > 0 {
+				batchTimer = time.NewTimer(o.BatchTimeout)
+				batchExpired = batchTimer.C
+			}
+			return true
+		}
+	feed:
+		for {
+			// Reaching here, the last record has been fully read.
+			ack.seal()
+			ack = nil
+			if sampler != nil && sampler.full() {
+				flushBatch()
+				break feed
+			}
+			var x JsonRead
+			var ok bool
+			select {
+			case x, ok = <-j:
+				if !ok {
+					flushBatch()
+					break feed
+				}
+			case <-batchExpired:
+				if !flushBatch() {
+					break feed
+				}
+				continue
+			case <-ctx.Done():
+				break feed
+			}
+			if summary != nil {
+				summary.read = summary.read + 1
+			}
+			ack = newRecordAck(x.Ack, x.Reply)
+			if x.Err != nil {
+				lg.Warn("cannot parse input", "file", x.File, "line", x.Line, "offset", x.Offset, "error", x.Err.Error())
+				if o.StrictInput {
+					inputErr = errors.New(parseError(x))
+					cancel()
+					break feed
+				}
+				if !emit(parseErrorResult(x)) {
+					break feed
+				}
+				continue
+			}
+			if sampler != nil && !sampler.keep() {
+				continue
+			}
+			replayCommand = nil
+			if o.Replay {
+				var keep bool
+				x.Value, replayCommand, keep = replayRecord(o, x.Value)
+				if !keep {
+					continue
+				}
+				if len(replayCommand) == 0 {
+					if !emit(skippedResult(x.Value, "no command recorded")) {
+						break feed
+					}
+					continue
+				}
+			}
+			values := []interface{}{x.Value}
+			if filter != nil {
+				var err error
+				values, err = applyFilter(filter, x.Value)
+				if err != nil {
+					r := skippedResult(x.Value, "")
+					r["error"] = fmt.Sprintf("filter error: %s", err)
+					lg.Warn("filter failed", "error", err.Error())
+					r["outcome"] = OUTCOME_FAILURE
+					if !emit(r) {
+						break feed
+					}
+					continue
+				}
+				if len(values) == 0 && o.EmitSkipped {
+					if !emit(skippedResult(x.Value, "filtered")) {
+						break feed
+					}
+				}
+			}
+			if o.Matrix {
+				expanded := []interface{}{}
+				for _, v := range values {
+					expanded = append(expanded, expandMatrix(v)...)
+				}
+				values = expanded
+			}
+			for _, v := range values {
+				if when != nil {
+					met, err := conditionMet(when, v)
+					if err != nil {
+						r := skippedResult(v, "")
+						r["error"] = fmt.Sprintf("condition error: %s", err)
+						lg.Warn("condition failed", "error", err.Error())
+						r["outcome"] = OUTCOME_FAILURE
+						if !emit(r) {
+							break feed
+						}
+						continue
+					}
+					if !met {
+						if !emit(skippedResult(v, "condition not met")) {
+							break feed
+						}
+						continue
+					}
+				}
+				if seen != nil {
+					k := dedupeKey(cmd, v)
+					if first, ok := seen[k]; ok {
+						r := skippedResult(v, "duplicate")
+						r["duplicate_of"] = first
+						if !emit(r) {
+							break feed
+						}
+						continue
+					}
+					seen[k] = seq
+				}
+				if state != nil && state.Completed(jobKey(cmd, v)) {
+					r := skippedResult(v, "already completed")
+					if !emit(r) {
+						break feed
+					}
+					// Completed jobs count as succeeded for their dependents.
+					settle(Job{Id: recordId(o, v)}, r)
+					continue
+				}
+				if !submit(v) {
+					break feed
+				}
+			}
+		}
+		if graph != nil {
+			for _, f := range graph.close() {
+				r := dependencyFailure(f)
+				results <- Output{Value: r, Seq: f.Job.Seq, Ack: f.Job.Ack}
+				pending.Done()
+				settle(f.Job, r)
+			}
+		}
+		inputDone <- struct{}{}
+	}()
+	// Wait for input to complete.
+	go func() {
+		next := 0
+		pending := map[int][]interface{}{}
+		for x := range results {
+			if x.Done {
+				break
+			}
+			if x.Event {
+				writeResult(output, o.OutputFormat, x.Value)
+				continue
+			}
+			x.Value = identifyResult(x.Value, runId, x.Seq)
+			if !jobSkipped(x.Value) {
+				ran = ran + 1
+			}
+			if summary != nil {
+				summary.add(x.Value)
+			}
+			if dash != nil {
+				dash.add(x.Value, time.Now())
+			}
+			addReports(reports, x)
+			logResult(o, systemLog, x)
+			tracer.job(x)
+			metrics.job(x)
+			// Records from a queue which fail once shutdown has begun are
+			// left unacknowledged, so they are delivered again.
+			if ctx.Err() == nil || !jobFailed(x.Value) {
+				x.Ack.done(jobFailed(x.Value))
+			}
+			if jobFailed(x.Value) {
+				failed = failed + 1
+				if o.FailuresOutput != nil {
+					writeFailure(o, x.Value)
+				}
+				// Cancelling a job on purpose does not halt the run.
+				if o.HaltOnError && !halted && !jobCancelled(x.Value) {
+					halted = true
+					lg.Warn("halting after a job failed", "seq", x.Seq)
+					cancel()
+				}
+			}
+			if state != nil && stateErr == nil && !jobSkipped(x.Value) && !jobFailed(x.Value) {
+				key, _ := x.Value.(map[string]interface{})["key"].(string)
+				if err := state.Record(key); err != nil {
+					stateErr = fmt.Errorf("cannot update state file: %s", err)
+					lg.Error("aborting the run", "error", stateErr.Error())
+					cancel()
+				}
+			}
+			shaped := shapeResult(o.OutputFields, renames, echoInput(o, x.Value))
+			// Requests are always answered, even with hidden results.
+			x.Ack.respond(shaped)
+			hook.result(shaped)
+			// Hidden results still take their turn in the order.
+			var values []interface{}
+			if showResult(o.Only, x.Value) {
+				values = filterResult(outputFilter, shaped)
+			}
+			if !o.KeepOrder {
+				for _, v := range values {
+					writeResult(output, o.OutputFormat, v)
+				}
+			} else {
+				// Hold results until every earlier record has been written.
+				pending[x.Seq] = values
+				for {
+					values, ok := pending[next]
+					if !ok {
+						break
+					}
+					delete(pending, next)
+					for _, v := range values {
+						writeResult(output, o.OutputFormat, v)
+					}
+					<-window
+					next = next + 1
+				}
+			}
+		}
+		outputDone <- struct{}{}
+	}()
+	waitForTermination(inputDone, 1)
+	lg.Debug("input finished")
+	if parent.Err() != nil {
+		lg.Info("shutting down")
+	}
+	// Wait for requeued jobs to run out of retries.
+	pending.Wait()
+	if queue != jobs {
+		close(queue)
+	}
+	// Tell workers that there is no more work.  Workers will
+	// now quit.
+	workers := ctl.stop()
+	for i := 0; i < workers; i++ {
+		jobs <- Job{Done: true}
+	}
+	// Wait for workers to complete their current tasks.
+	waitForTermination(workerDone, workers)
+	// Tell output routine that there is nothing left. Output
+	// routine will now quit.
+	results <- Output{Done: true}
+	waitForTermination(outputDone, 1)
+	closeDashboard()
+	if t, ok := output.(*tapOutput); ok {
+		t.finish()
+	}
+	reportErr := writeReports(reports)
+	if summary != nil {
+		record := summary.record()
+		if o.Summary {
+			w := o.SummaryOutput
+			if w == nil {
+				w = output
+			}
+			writeResult(w, o.OutputFormat, record)
+		}
+		hook.summary(record)
+	}
+	hook.wait()
+	if err := tracer.finish(ran, failed); err != nil {
+		lg.Warn(err.Error())
+	}
+	if inputErr != nil {
+		return inputErr
+	}
+	if stateErr != nil {
+		return stateErr
+	}
+	if reportErr != nil {
+		return reportErr
+	}
+	if halted {
+		return &ExitError{Code: failureExitCode(failed), Message: "halted after a job failed"}
+	}
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	return exitStatus(o.ExitStatus, ran, failed)
+}
+
+// parseErrorResult is the result for input which cannot be parsed.
+func parseErrorResult(x JsonRead) map[string]interface{} {
+	r := map[string]interface{}{}
+	r["cmd"] = []string{}
+	r["error"] = parseError(x)
+	if x.Line > 0 {
+		r["line"] = x.Line
+		r["offset"] = x.Offset
+	}
+	if x.Text != "" {
+		r["text"] = x.Text
+	}
+	if x.File != "" {
+		r["file"] = x.File
+	}
+	r["returncode"] = RETURNCODE_FAILURE
+	r["stdout"] = ""
+	r["stderr"] = ""
+	r["outcome"] = OUTCOME_FAILURE
+	return r
+}
+
+// validateOptions rejects options which are invalid or cannot be used
+// together.
+func validateOptions(o *Options) error {
+	if o.Parallelism < 1 {
+		return errors.New("at least one worker required")
+	}
+	if o.Retries < 0 {
+		return errors.New("retries cannot be negative")
+	}
+	stdins := 0
+	for _, given := range []bool{o.StdinJson, o.StdinField != "", o.StdinFile != ""} {
+		if given {
+			stdins = stdins + 1
+		}
+	}
+	if stdins > 1 {
+		return errors.New("--stdin-json, --stdin-field, and --stdin-file are mutually exclusive")
+	}
+	if o.Heartbeat < 0 {
+		return errors.New("heartbeat interval cannot be negative")
+	}
+	if o.Repeat < 0 {
+		return errors.New("repeat count cannot be negative")
+	}
+	if o.Repeat > 1 && (o.Coprocess || o.RequeueFailures) {
+		return errors.New("--repeat cannot be used with --coprocess or --requeue-failures")
+	}
+	if len(o.Then) > 0 && (o.Coprocess || o.RequeueFailures) {
+		return errors.New("--then cannot be used with --coprocess or --requeue-failures")
+	}
+	if o.Coprocess && sendsStdin(o) {
+		return errors.New("--coprocess sends records on stdin, so --stdin-json, --stdin-field, and --stdin-file cannot be used")
+	}
+	switch o.ExitStatus {
+	case EXIT_STATUS_ANY_FAILURE, EXIT_STATUS_ALL_FAILURE, EXIT_STATUS_NEVER:
+	default:
+		return fmt.Errorf("unknown exit status policy %s", o.ExitStatus)
+	}
+	if o.Rate > 0 && o.RateBurst < 1 {
+		return errors.New("rate burst must be at least one")
+	}
+	if o.HTTP && (o.Shell || o.K8s || o.DockerImage != "" || o.Coprocess) {
+		return errors.New("--http cannot be combined with other ways of running commands")
+	}
+	if o.RenderTo != "" && (o.HTTP || o.Shell || o.K8s || o.DockerImage != "" || o.Coprocess || o.Replace != "" || len(o.Args) > 0) {
+		return errors.New("--render-to runs no commands, so it cannot be combined with a command or other ways of running one")
+	}
+	if o.RenderTo != "" && o.Body == "" {
+		return errors.New("--render-to requires --body")
+	}
+	if o.K8s && o.K8sImage == "" {
+		return errors.New("--k8s requires --k8s-image")
+	}
+	if o.K8s && sendsStdin(o) {
+		return errors.New("kubernetes jobs cannot be given stdin")
+	}
+	if o.IoNice != "" {
+		if _, _, err := parseIoNice(o.IoNice); err != nil {
+			return err
+		}
+	}
+	if usesCgroup(o) && runtime.GOOS != "linux" {
+		return errors.New("--cgroup-mem and --cgroup-cpu are only supported on Linux")
+	}
+	if usesCgroup(o) && (o.HTTP || o.K8s || o.DockerImage != "" || o.Coprocess) {
+		return errors.New("--cgroup-mem and --cgroup-cpu only confine commands run by jpar itself")
+	}
+	runsAs := o.User != "" || o.Group != "" || o.UserField != ""
+	if runsAs && runtime.GOOS == "windows" {
+		return errors.New("commands cannot be run as another user on Windows")
+	}
+	if runsAs && (o.HTTP || o.K8s || o.DockerImage != "") {
+		return errors.New("--user, --uid, --gid, and --user-field only apply to commands run by jpar itself")
+	}
+	if o.Sandbox != "" && runtime.GOOS != "linux" {
+		return errors.New("--sandbox is only supported on Linux")
+	}
+	if o.Sandbox != "" && (o.HTTP || o.K8s || o.DockerImage != "" || o.Coprocess) {
+		return errors.New("--sandbox only applies to commands run by jpar itself")
+	}
+	if o.Sandbox != "" {
+		if info, err := os.Stat(o.Sandbox); err != nil || !info.IsDir() {
+			return fmt.Errorf("sandbox %s is not a directory", o.Sandbox)
+		}
+		if _, err := sandboxBinds(o); err != nil {
+			return err
+		}
+	}
+	if o.Tui && runtime.GOOS == "windows" {
+		return errors.New("--tui is not supported on Windows")
+	}
+	if o.UserField != "" && o.Coprocess {
+		return errors.New("--user-field cannot be used with --coprocess")
+	}
+	if o.CgroupMem != "" {
+		if _, err := parseCgroupMem(o.CgroupMem); err != nil {
+			return err
+		}
+	}
+	if o.CgroupCpu != "" {
+		if _, err := parseCgroupCpu(o.CgroupCpu); err != nil {
+			return err
+		}
+	}
+	if o.Batch < 0 {
+		return errors.New("batch size cannot be negative")
+	}
+	if o.Batch > 0 && o.StateFile != "" {
+		return errors.New("--state-file cannot be used with --batch")
+	}
+	if o.Replay && (o.Batch > 0 || o.Dag || len(o.Then) > 0 || o.Coprocess) {
+		return errors.New("replay cannot be used with --batch, --dag, --then, or --coprocess")
+	}
+	for _, in := range o.Inputs {
+		if (isAmqp(in) || isRedis(in) || isNats(in)) && o.Batch > 0 {
+			return errors.New("--batch cannot be used with amqp, redis, or nats input")
+		}
+	}
+	if o.Dag && o.Batch > 0 {
+		return errors.New("--dag cannot be used with --batch")
+	}
+	if o.GroupBy != "" && o.GroupParallelism < 1 {
+		return errors.New("group parallelism must be at least one")
+	}
+	if o.Head < 0 || o.Skip < 0 {
+		return errors.New("--head and --skip cannot be negative")
+	}
+	if o.Sample < 0 || o.Sample > 1 {
+		return errors.New("sample must be a fraction between 0 and 1")
+	}
+	if o.QueueSize < 0 {
+		return errors.New("queue size cannot be negative")
+	}
+	if o.KeepOrder && o.ReorderBuffer < 1 {
+		return errors.New("reorder buffer must hold at least one result")
+	}
+	switch o.OutputFormat {
+	case OUTPUT_FORMAT_NDJSON, OUTPUT_FORMAT_CONCAT, OUTPUT_FORMAT_PRETTY, OUTPUT_FORMAT_CSV, OUTPUT_FORMAT_TAP:
+	default:
+		return fmt.Errorf("unknown output format %s", o.OutputFormat)
+	}
+	if (o.OutputFormat == OUTPUT_FORMAT_CSV || o.OutputFormat == OUTPUT_FORMAT_TAP) && (o.StreamOutput || o.Heartbeat > 0 || (o.Summary && o.SummaryOutput == nil)) {
+		return fmt.Errorf("%s output cannot hold --stream-output or --heartbeat events or a --summary, unless it is written elsewhere with --summary-fd", o.OutputFormat)
+	}
+	if o.ReplaceField != "" && o.Replace == "" {
+		return errors.New("--replace-field requires --replace")
+	}
+	if _, err := parseReports(o.Reports); err != nil {
+		return err
+	}
+	switch o.LogResults {
+	case "", LOG_RESULTS_SYSLOG, LOG_RESULTS_JOURNALD:
+	default:
+		return fmt.Errorf("unknown result log %s", o.LogResults)
+	}
+	switch o.WebhookOn {
+	case WEBHOOK_ON_RESULT, WEBHOOK_ON_FAILURE, WEBHOOK_ON_SUMMARY:
+	default:
+		return fmt.Errorf("unknown webhook event %s", o.WebhookOn)
+	}
+	if o.Webhook != "" && (o.WebhookRetries < 0 || o.WebhookConcurrency < 1) {
+		return errors.New("webhook retries cannot be negative, and concurrency must be at least one")
+	}
+	switch o.ParseStdout {
+	case PARSE_STDOUT_NONE, PARSE_STDOUT_JSON:
+	default:
+		return fmt.Errorf("unknown stdout format %s", o.ParseStdout)
+	}
+	switch o.MissingVar {
+	case MISSING_VAR_ERROR, MISSING_VAR_EMPTY, MISSING_VAR_SKIP:
+	default:
+		return fmt.Errorf("unknown missing variable policy %s", o.MissingVar)
+	}
+	if _, err := compileRedactions(o.Redact); err != nil {
+		return err
+	}
+	switch o.Escape {
+	case ESCAPE_NONE, ESCAPE_SHELL, ESCAPE_STRICT:
+	default:
+		return fmt.Errorf("unknown escape policy %s", o.Escape)
+	}
+	switch o.EchoInput {
+	case ECHO_INPUT_NEVER, ECHO_INPUT_ALWAYS, ECHO_INPUT_ON_FAILURE:
+	default:
+		return fmt.Errorf("unknown echo input policy %s", o.EchoInput)
+	}
+	switch o.Only {
+	case "", ONLY_FAILURES, ONLY_SUCCESSES:
+	default:
+		return fmt.Errorf("--only must be %s or %s", ONLY_FAILURES, ONLY_SUCCESSES)
+	}
+	switch o.FailuresFormat {
+	case FAILURES_FORMAT_ORIGINAL, FAILURES_FORMAT_RESULT:
+	default:
+		return fmt.Errorf("unknown failures format %s", o.FailuresFormat)
+	}
+	return nil
+}
+
+// exitStatus applies the exit status policy to the job counts.
+func exitStatus(policy string, ran int, failed int) error {
+	switch policy {
+	case EXIT_STATUS_NEVER:
+		return nil
+	case EXIT_STATUS_ALL_FAILURE:
+		if ran == 0 || failed < ran {
+			return nil
+		}
+	default:
+		if failed == 0 {
+			return nil
+		}
+	}
+	return &ExitError{
+		Code: failureExitCode(failed),
+		Message: fmt.Sprintf("%d of %d jobs failed", failed, ran),
+	}
+}
+
+func failureExitCode(failed int) int {
+	if failed > EXIT_MAX_FAILURES {
+		return EXIT_MAX_FAILURES + 1
+	}
+	return failed
+}
+
+// writeResult writes a single result record framed according to the
+// output format.
+func writeResult(w io.Writer, format string, v interface{}) {
+	if c, ok := w.(*csvOutput); ok {
+		c.writeRow(v)
+		return
+	}
+	if t, ok := w.(*tapOutput); ok {
+		t.writePoint(v)
+		return
+	}
+	var out []byte
+	var err error
+	if format == OUTPUT_FORMAT_PRETTY {
+		out, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		out, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Panicf("Cannot marshal: %v", v)
+	}
+	if format != OUTPUT_FORMAT_CONCAT {
+		out = append(out, '\n')
+	}
+	w.Write(out)
+}
+
+// writeFailure writes a failed job to the failures output.  Its input
+// records are written one per line, so they can be run again, unless the
+// whole result is wanted.  Unparseable input has no record to write.
+func writeFailure(o *Options, v interface{}) {
+	w := o.FailuresOutput
+	if o.FailuresFormat == FAILURES_FORMAT_RESULT {
+		writeResult(w, OUTPUT_FORMAT_NDJSON, v)
+		return
+	}
+	e, ok := v.(map[string]interface{})["e"]
+	if !ok {
+		return
+	}
+	if o.Batch > 0 {
+		if items, ok := e.(map[string]interface{})["items"].([]interface{}); ok {
+			for _, item := range items {
+				writeResult(w, OUTPUT_FORMAT_NDJSON, item)
+			}
+			return
+		}
+	}
+	writeResult(w, OUTPUT_FORMAT_NDJSON, e)
+}
+
+// showResult reports whether a result is written under the --only
+// policy.  Skipped records count as successes.
+func showResult(only string, v interface{}) bool {
+	switch only {
+	case ONLY_FAILURES:
+		return jobFailed(v)
+	case ONLY_SUCCESSES:
+		return !jobFailed(v)
+	}
+	return true
+}
+
+// jobFailed reports whether a result records a job which could not be
+// run, timed out, exited non-zero, or was cancelled.
+func jobFailed(v interface{}) bool {
+	r, ok := v.(map[string]interface{})
+	return ok && (r["outcome"] == OUTCOME_FAILURE || r["outcome"] == OUTCOME_TIMEOUT || r["outcome"] == OUTCOME_CANCELLED)
+}
+
+// jobCancelled reports whether a result records a job cancelled through
+// the control socket.
+func jobCancelled(v interface{}) bool {
+	r, ok := v.(map[string]interface{})
+	return ok && r["outcome"] == OUTCOME_CANCELLED
+}
+
+// jobSkipped reports whether a result records a record that was not run.
+func jobSkipped(v interface{}) bool {
+	r, ok := v.(map[string]interface{})
+	return ok && r["outcome"] == OUTCOME_SKIPPED
+}
+
+func waitForTermination(done chan struct{}, count int) {
+	completed := 0
+	for _ = range done {
+		completed = completed + 1
+		if completed == count {
+			return
+		}
+	}
+}
+
+func worker(
+	ctx context.Context,
+	o *Options,
+	id int,
+	cmd *CommandTemplate,
+	jobs chan Job,
+	completed chan Output,
+	done chan struct{},
+	finish func(Job, map[string]interface{}),
+	requeue func(Job, map[string]interface{}),
+	ctl *runControl) {
+	var co *coprocessWorker
+	if o.Coprocess {
+		co = &coprocessWorker{o: o, cmd: cmd}
+	}
+	lg := logger(o)
+	lg.Debug("worker started", "worker", id)
+	for {
+		// Workers beyond the number wanted retire once they are idle.
+		if ctl.retire() {
+			if co != nil {
+				co.stop(ctx)
+			}
+			lg.Debug("worker retired", "worker", id)
+			return
+		}
+		var job Job
+		select {
+		case job = <-jobs:
+		case <-ctl.shrinking():
+			continue
+		}
+		if job.Done {
+			if co != nil {
+				co.stop(ctx)
+			}
+			lg.Debug("worker stopped", "worker", id)
+			done <- struct{}{}
+			return
+		}
+		// A paused run lets running jobs finish but starts no more.  If
+		// the run is cancelled meanwhile, the job is cancelled too.
+		ctl.hold(ctx)
+		var r map[string]interface{}
+		meta := jobMeta(job.Seq, id)
+		if len(o.secrets) > 0 {
+			meta["_secrets"] = secretsMeta(o)
+		}
+		cmd := cmd
+		if job.Command != nil {
+			cmd = cmd.literal(job.Command)
+		}
+		redact := o.redact.forRecord(o.RedactFields, job.Value)
+		dropped := ctl.isDropped(job)
+		runCtx, untrack := ctl.track(ctx, job)
+		if o.StreamOutput {
+			seq := job.Seq
+			runCtx = withOutputEvents(runCtx, func(stream string, line string) {
+				completed <- Output{Value: outputEvent(seq, stream, redact.string(line)), Event: true}
+			})
+		}
+		var missing []string
+		if o.MissingVar != MISSING_VAR_EMPTY && job.Command == nil {
+			missing = missingVariables(o, job.Value, meta)
+		}
+		unsafe := ""
+		if o.Escape == ESCAPE_STRICT && job.Command == nil {
+			unsafe = unsafeField(job.Value, "")
+		}
+		stopHeartbeat := startHeartbeat(o.Heartbeat, job.Seq, completed)
+		if dropped {
+			r = cancelledResult(job.Value)
+		} else if len(missing) > 0 {
+			r = missingResult(o.MissingVar, job.Value, missing)
+		} else if unsafe != "" {
+			r = unsafeResult(job.Value, unsafe)
+		} else if o.DryRun {
+			r = runSteps(cmd, func(c *CommandTemplate) map[string]interface{} {
+				return dryRunJob(o, c, job.Value, meta)
+			})
+		} else if co != nil {
+			r = co.run(runCtx, job.Value, meta)
+		} else if o.RequeueFailures {
+			// Each attempt goes through the job queue, so a failing job
+			// does not hold a worker while it waits to be retried.
+			r = runJob(runCtx, o, cmd, job.Value, meta)
+			job.Attempt = job.Attempt + 1
+			if o.AttemptHistory {
+				job.History = append(job.History, attemptRecord(r))
+			}
+			if job.Attempt <= o.Retries && shouldRetry(r) && runCtx.Err() == nil {
+				stopHeartbeat()
+				untrack()
+				requeue(job, r)
+				continue
+			}
+			finishAttempts(o, r, job.Attempt, job.History)
+		} else {
+			r = runRepeated(runCtx, o.Repeat, func() map[string]interface{} {
+				return runSteps(cmd, func(c *CommandTemplate) map[string]interface{} {
+					return runJobWithRetries(runCtx, o, c, job.Value, meta)
+				})
+			})
+		}
+		stopHeartbeat()
+		if untrack() && r["outcome"] != OUTCOME_SUCCESS {
+			r["outcome"] = OUTCOME_CANCELLED
+		}
+		if o.ParseStdout == PARSE_STDOUT_JSON {
+			parseStdout(r)
+		}
+		if job.Key != "" {
+			r["key"] = job.Key
+		}
+		if job.Group != "" {
+			r["group"] = job.Group
+		}
+		if len(cmd.Tags) > 0 {
+			r["tags"] = renderTags(cmd, job.Value, meta)
+		}
+		if o.Debug {
+			r["worker-id"] = id
+		}
+		redact.result(r)
+		lg.Debug("job finished", "seq", job.Seq, "worker", id, "outcome", r["outcome"], "duration_ms", r["duration_ms"])
+		completed <- Output{Value: r, Seq: job.Seq, Ack: job.Ack}
+		finish(job, r)
+	}
+}
+
@@ -0,0 +1,322 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteResultFraming(t *testing.T) {
+	cases := map[string]string{
+		OUTPUT_FORMAT_NDJSON: "{\"a\":1}\n{\"a\":1}\n",
+		OUTPUT_FORMAT_CONCAT: "{\"a\":1}{\"a\":1}",
+		OUTPUT_FORMAT_PRETTY: "{\n  \"a\": 1\n}\n{\n  \"a\": 1\n}\n",
+	}
+	for format, want := range cases {
+		var b bytes.Buffer
+		writeResult(&b, format, map[string]int{"a": 1})
+		writeResult(&b, format, map[string]int{"a": 1})
+		if b.String() != want {
+			t.Errorf("format %s: got %q, want %q", format, b.String(), want)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	cases := []struct {
+		policy      string
+		ran, failed int
+		want        int
+	}{
+		{EXIT_STATUS_ANY_FAILURE, 10, 0, 0},
+		{EXIT_STATUS_ANY_FAILURE, 10, 3, 3},
+		{EXIT_STATUS_ANY_FAILURE, 500, 200, EXIT_MAX_FAILURES + 1},
+		{EXIT_STATUS_ALL_FAILURE, 10, 3, 0},
+		{EXIT_STATUS_ALL_FAILURE, 3, 3, 3},
+		{EXIT_STATUS_ALL_FAILURE, 0, 0, 0},
+		{EXIT_STATUS_NEVER, 3, 3, 0},
+	}
+	for _, c := range cases {
+		code := 0
+		if err := exitStatus(c.policy, c.ran, c.failed); err != nil {
+			code = err.(*ExitError).Code
+		}
+		if code != c.want {
+			t.Errorf("exitStatus(%s, %d, %d) = %d, want %d", c.policy, c.ran, c.failed, code, c.want)
+		}
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	o.KeepOrder = true
+	var out bytes.Buffer
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"n":1}{"n":2}{"n":3}`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	for _, want := range []string{"1\n", "2\n", "3\n"} {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r["stdout"] != want {
+			t.Errorf("expected stdout %q, got %q", want, r["stdout"])
+		}
+	}
+}
+
+func TestRunnerRequeueFailures(t *testing.T) {
+	o := NewOptions()
+	o.EchoInput = ECHO_INPUT_ALWAYS
+	o.Args = []string{"sh", "-c", "exit {{rc}}"}
+	o.Parallelism = 1
+	o.Retries = 2
+	o.RetryDelay = time.Millisecond
+	o.RequeueFailures = true
+	o.ExitStatus = EXIT_STATUS_NEVER
+	var out bytes.Buffer
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"rc":1}{"rc":0}`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := map[float64]float64{}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		attempts[r["e"].(map[string]interface{})["rc"].(float64)] = r["attempts"].(float64)
+	}
+	if attempts[1] != 3 || attempts[0] != 1 {
+		t.Errorf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestRunnerGroupBy(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"sleep", "0.1"}
+	o.GroupBy = "{{host}}"
+	var out bytes.Buffer
+	start := time.Now()
+	input := `{"host":"a"}{"host":"a"}{"host":"a"}{"host":"b"}{"host":"c"}`
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 300*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Errorf("group a should run serially while other groups run alongside it, took %s", elapsed)
+	}
+	if n := strings.Count(out.String(), `"group":"a"`); n != 3 {
+		t.Errorf("got %d results for group a, want 3", n)
+	}
+}
+
+func TestRunnerDedupe(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	o.KeepOrder = true
+	o.DedupeKey = "{{n}}"
+	var out bytes.Buffer
+	input := `{"n":1,"x":1}{"n":2}{"n":1,"x":2}`
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 results, got %q", out.String())
+	}
+	var r map[string]interface{}
+	json.Unmarshal([]byte(lines[2]), &r)
+	if r["outcome"] != OUTCOME_SKIPPED || r["duplicate_of"] != 0.0 {
+		t.Errorf("expected a duplicate of record 0, got %v", r)
+	}
+}
+
+func TestRunnerBatch(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{#items}}{{n}} {{/items}}"}
+	o.KeepOrder = true
+	o.Batch = 2
+	var out bytes.Buffer
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"n":1}{"n":2}{"n":3}`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := []string{}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		stdout = append(stdout, r["stdout"].(string))
+	}
+	if len(stdout) != 2 || stdout[0] != "1 2 \n" || stdout[1] != "3 \n" {
+		t.Errorf("unexpected batches %q", stdout)
+	}
+}
+
+func TestRunnerBatchTimeout(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"cat"}
+	o.StdinJson = true
+	o.Batch = 10
+	o.BatchTimeout = 50 * time.Millisecond
+	in, w := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- NewRunner(o).Run(context.Background(), in, &out)
+	}()
+	w.Write([]byte(`{"n":1}`))
+	time.Sleep(200 * time.Millisecond)
+	w.Write([]byte(`{"n":2}`))
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"stdout":"[{\"n\":1}]"`) {
+		t.Errorf("expected the first record in its own batch, got %s", out.String())
+	}
+}
+
+func TestRunnerFailures(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"sh", "-c", "exit {{rc}}"}
+	o.Parallelism = 1
+	o.ExitStatus = EXIT_STATUS_NEVER
+	var failures bytes.Buffer
+	o.FailuresOutput = &failures
+	o.InputFormat = INPUT_FORMAT_JSONL
+	input := "{\"rc\":0}\n{\"rc\":1}\nnot json\n{\"rc\":2}\n"
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got := failures.String(); got != "{\"rc\":1}\n{\"rc\":2}\n" {
+		t.Errorf("unexpected failures %q", got)
+	}
+	failures.Reset()
+	o.FailuresFormat = FAILURES_FORMAT_RESULT
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"rc":3}`), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(failures.String(), `"exit_code":3`) {
+		t.Errorf("expected the failed result, got %q", failures.String())
+	}
+}
+
+func TestRunnerStrictInput(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{n}}"}
+	input := "{\"n\":1}\n{\"n\": x}\n{\"n\":3}"
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err == nil {
+		t.Fatal("expected the parse error to fail the run")
+	}
+	if !strings.Contains(out.String(), `"line":2,"offset":8`) || !strings.Contains(out.String(), `"text":"{\"n\": x}"`) {
+		t.Errorf("expected the position and text of the bad record, got %s", out.String())
+	}
+	o.StrictInput = true
+	out.Reset()
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out)
+	if err == nil || !strings.HasPrefix(err.Error(), "parse error on line 2 (offset 8)") {
+		t.Fatalf("expected the parse error, got %v", err)
+	}
+	if strings.Contains(out.String(), "parse error") {
+		t.Errorf("expected no result for the bad record, got %s", out.String())
+	}
+}
+
+func TestRunnerTags(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"true"}
+	o.Tags = []string{"host={{host}}", "n={{n}}"}
+	var out bytes.Buffer
+	err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"host":"web1","n":2}`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"tags":{"host":"web1","n":"2"}`) {
+		t.Errorf("expected tags in the result, got %s", out.String())
+	}
+	o.Tags = []string{"host"}
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{}`), io.Discard); err == nil {
+		t.Error("expected an error for a tag without a template")
+	}
+}
+
+func TestRunnerMissingVar(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{host}}", "{{user|default:root}}"}
+	o.Parallelism = 1
+	input := `{"host":"web1"}` + "\n" + `{"name":"db1"}`
+	cases := map[string]string{
+		MISSING_VAR_EMPTY: `"outcome":"SUCCESS"`,
+		MISSING_VAR_ERROR: `"error":"undefined variables: host","outcome":"FAILURE"`,
+		MISSING_VAR_SKIP: `"outcome":"SKIPPED","reason":"undefined variables: host"`,
+	}
+	for policy, want := range cases {
+		o.MissingVar = policy
+		var out bytes.Buffer
+		NewRunner(o).Run(context.Background(), strings.NewReader(input), &out)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], `"stdout":"web1 root\n"`) || !strings.Contains(withoutIds(t, lines[1]), want) {
+			t.Errorf("%s: expected %s for the second record, got %s", policy, want, out.String())
+		}
+	}
+	o.MissingVar = "ignore"
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), io.Discard); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestRunnerEscapeStrict(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{f}}"}
+	o.Parallelism = 1
+	o.Escape = ESCAPE_STRICT
+	var out bytes.Buffer
+	NewRunner(o).Run(context.Background(), strings.NewReader(`{"f":"a.txt"}{"f":"a.txt; rm -rf ~"}`), &out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"stdout":"a.txt\n"`) || !strings.Contains(withoutIds(t, lines[1]), `"error":"field f contains characters refused by --escape strict","outcome":"FAILURE"`) {
+		t.Errorf("expected the second record to be refused, got %s", out.String())
+	}
+	o.Escape = "quote"
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{}`), io.Discard); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestShowResult(t *testing.T) {
+	ok := map[string]interface{}{"outcome": OUTCOME_SUCCESS}
+	failed := map[string]interface{}{"outcome": OUTCOME_TIMEOUT}
+	skipped := map[string]interface{}{"outcome": OUTCOME_SKIPPED}
+	cases := []struct {
+		only string
+		v map[string]interface{}
+		want bool
+	}{
+		{"", failed, true},
+		{ONLY_FAILURES, ok, false},
+		{ONLY_FAILURES, failed, true},
+		{ONLY_FAILURES, skipped, false},
+		{ONLY_SUCCESSES, ok, true},
+		{ONLY_SUCCESSES, failed, false},
+		{ONLY_SUCCESSES, skipped, true},
+	}
+	for _, c := range cases {
+		if got := showResult(c.only, c.v); got != c.want {
+			t.Errorf("showResult(%q, %v) = %v, want %v", c.only, c.v["outcome"], got, c.want)
+		}
+	}
+}
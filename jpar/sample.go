@@ -0,0 +1,61 @@
+package jpar
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// inputSampler chooses which input records are used, skipping the
+// first Skip records, keeping a random fraction of the rest, and
+// stopping after Head records have been kept.
+type inputSampler struct {
+	skip int
+	head int
+	fraction float64
+	rng *rand.Rand
+	seen int
+	kept int
+}
+
+// newInputSampler returns nil when every record is used.
+func newInputSampler(o *Options) *inputSampler {
+	if o.Skip == 0 && o.Head == 0 && o.Sample == 0 {
+		return nil
+	}
+	return &inputSampler{
+		skip: o.Skip,
+		head: o.Head,
+		fraction: o.Sample,
+		rng: rand.New(rand.NewSource(o.Seed)),
+	}
+}
+
+// keep reports whether the next record is used.
+func (s *inputSampler) keep() bool {
+	s.seen = s.seen + 1
+	if s.seen <= s.skip {
+		return false
+	}
+	if s.fraction > 0 && s.rng.Float64() >= s.fraction {
+		return false
+	}
+	s.kept = s.kept + 1
+	return true
+}
+
+// full reports whether no more records are needed.
+func (s *inputSampler) full() bool {
+	return s.head > 0 && s.kept >= s.head
+}
+
+// ParseSample parses a sampling rate written as a percentage, such as
+// 10% or 0.5%, into a fraction.
+func ParseSample(s string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, fmt.Errorf("sample %s must be a percentage above 0 and at most 100", s)
+	}
+	return p / 100, nil
+}
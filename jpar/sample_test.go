@@ -0,0 +1,52 @@
+package jpar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInputSampler(t *testing.T) {
+	if newInputSampler(&Options{}) != nil {
+		t.Error("expected no sampler by default")
+	}
+	s := newInputSampler(&Options{Skip: 2, Head: 3})
+	kept := []int{}
+	for i := 0; i < 10 && !s.full(); i++ {
+		if s.keep() {
+			kept = append(kept, i)
+		}
+	}
+	if !reflect.DeepEqual(kept, []int{2, 3, 4}) {
+		t.Errorf("unexpected records kept %v", kept)
+	}
+	sample := func() []int {
+		s := newInputSampler(&Options{Sample: 0.1, Seed: 42})
+		kept := []int{}
+		for i := 0; i < 1000; i++ {
+			if s.keep() {
+				kept = append(kept, i)
+			}
+		}
+		return kept
+	}
+	first := sample()
+	if len(first) < 50 || len(first) > 150 {
+		t.Errorf("expected about 100 records, got %d", len(first))
+	}
+	if !reflect.DeepEqual(first, sample()) {
+		t.Error("expected the same seed to choose the same records")
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	for s, want := range map[string]float64{"10%": 0.1, "100": 1, "0.5%": 0.005} {
+		if got, err := ParseSample(s); err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"0%", "101%", "x%"} {
+		if _, err := ParseSample(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package jpar
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SANDBOX_INIT is the hidden command with which jpar re-executes itself
+// to set up a sandbox before running a command in it.
+const SANDBOX_INIT = "__sandbox-init"
+
+// EXIT_SANDBOX_FAILED is the exit status of a command whose sandbox
+// could not be set up, like a shell's for a missing command.
+const EXIT_SANDBOX_FAILED = 127
+
+// DEFAULT_SANDBOX_BINDS are bound into every sandbox which does not
+// already provide them, so that ordinary commands can run.
+var DEFAULT_SANDBOX_BINDS = []string{"/bin:ro", "/lib:ro", "/lib64:ro", "/usr:ro", "/dev/null", "/dev/zero", "/dev/urandom"}
+
+// sandboxBind is a host path made visible inside a sandbox.
+type sandboxBind struct {
+	Src string
+	Dst string
+	ReadOnly bool
+	// Default binds are skipped when the sandbox has its own copy.
+	Default bool
+}
+
+// sandboxSpec is passed to the sandbox init process, describing the
+// sandbox and the command to run in it.
+type sandboxSpec struct {
+	Root string
+	Binds []sandboxBind
+	Cwd string
+	Args []string
+	// Uid, Gid, and Groups are switched to inside the sandbox, when
+	// SetUser is true.
+	SetUser bool
+	Uid uint32
+	Gid uint32
+	Groups []uint32
+	SetGroups bool
+}
+
+// parseSandboxBind parses a bind written SRC[:DST][:ro|rw], like a
+// docker volume.  DST defaults to SRC.
+func parseSandboxBind(s string) (sandboxBind, error) {
+	parts := strings.Split(s, ":")
+	b := sandboxBind{Src: parts[0], Dst: parts[0]}
+	if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		b.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	switch len(parts) {
+	case 1:
+	case 2:
+		b.Dst = parts[1]
+	default:
+		return b, fmt.Errorf("sandbox bind %s must be SRC[:DST][:ro]", s)
+	}
+	if !filepath.IsAbs(b.Src) || !filepath.IsAbs(b.Dst) {
+		return b, fmt.Errorf("sandbox bind %s must use absolute paths", s)
+	}
+	b.Src = filepath.Clean(b.Src)
+	b.Dst = filepath.Clean(b.Dst)
+	return b, nil
+}
+
+// sandboxBinds returns the default binds followed by those given in the
+// options.
+func sandboxBinds(o *Options) ([]sandboxBind, error) {
+	binds := []sandboxBind{}
+	for _, s := range DEFAULT_SANDBOX_BINDS {
+		b, _ := parseSandboxBind(s)
+		b.Default = true
+		binds = append(binds, b)
+	}
+	for _, s := range o.SandboxBinds {
+		b, err := parseSandboxBind(s)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, b)
+	}
+	return binds, nil
+}
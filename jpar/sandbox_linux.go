@@ -0,0 +1,336 @@
+package jpar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Mount flags which a read-only remount must keep, since the kernel
+// refuses to clear them inside a user namespace.
+const SANDBOX_LOCKED_FLAGS = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME
+
+// PR_SET_NO_NEW_PRIVS is the prctl option which stops a process and its
+// children from gaining privileges when they execute a program.
+const PR_SET_NO_NEW_PRIVS = 38
+
+// LINUX_CAPABILITY_VERSION_3 is the version of the capset interface
+// which holds 64 capabilities.
+const LINUX_CAPABILITY_VERSION_3 = 0x20080522
+
+// sandboxCommand changes a command so that it runs in the sandbox.  jpar
+// re-executes itself in a new mount namespace, where it binds the
+// allowed paths into the sandbox, makes it the root filesystem, drops
+// every capability, and then runs the command.  Without root a user namespace is made as well, in which jpar
+// appears to be root.
+func sandboxCommand(o *Options, c *exec.Cmd, cwd string) error {
+	binds, err := sandboxBinds(o)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(o.Sandbox)
+	if err != nil {
+		return fmt.Errorf("cannot locate sandbox: %s", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate jpar for the sandbox: %s", err)
+	}
+	spec := sandboxSpec{Root: root, Binds: binds, Cwd: cwd, Args: c.Args}
+	attr := c.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	// Changing user before the sandbox is set up would leave it unable
+	// to mount anything, so it is done afterwards.
+	if cred := attr.Credential; cred != nil {
+		spec.SetUser = true
+		spec.Uid, spec.Gid, spec.Groups = cred.Uid, cred.Gid, cred.Groups
+		spec.SetGroups = !cred.NoSetGroups
+		attr.Credential = nil
+	}
+	attr.Cloneflags = attr.Cloneflags | syscall.CLONE_NEWNS
+	if os.Geteuid() != 0 {
+		attr.Cloneflags = attr.Cloneflags | syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	c.Path = self
+	c.Args = []string{self, SANDBOX_INIT, string(data)}
+	// The command was looked up inside the sandbox, not on the host.
+	c.Err = nil
+	c.Dir = ""
+	c.SysProcAttr = attr
+	return nil
+}
+
+// SandboxInit sets up a sandbox and runs a command in it, replacing the
+// jpar process.  It is run as the hidden SANDBOX_INIT command, and only
+// returns by exiting.
+func SandboxInit(argv []string) {
+	err := sandboxInit(argv)
+	fmt.Fprintf(os.Stderr, "cannot run command in sandbox: %s\n", err)
+	os.Exit(EXIT_SANDBOX_FAILED)
+}
+
+func sandboxInit(argv []string) error {
+	if len(argv) != 1 {
+		return errors.New("expected a sandbox description")
+	}
+	spec := sandboxSpec{}
+	if err := json.Unmarshal([]byte(argv[0]), &spec); err != nil {
+		return fmt.Errorf("cannot read sandbox description: %s", err)
+	}
+	if len(spec.Args) == 0 {
+		return errors.New("expected a command to run in the sandbox")
+	}
+	// Capabilities and the no_new_privs flag belong to a thread, and the
+	// command inherits them from the thread which executes it.
+	runtime.LockOSThread()
+	// Mounts made in the sandbox must not reach the host.
+	if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("cannot make mounts private: %s", err)
+	}
+	// The root filesystem can only be moved to a mount point, so the
+	// sandbox is bound onto itself.
+	if err := syscall.Mount(spec.Root, spec.Root, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("cannot mount sandbox %s: %s", spec.Root, err)
+	}
+	for _, b := range spec.Binds {
+		if err := bindIntoSandbox(spec.Root, b); err != nil {
+			return err
+		}
+	}
+	if err := enterSandbox(spec.Root); err != nil {
+		return err
+	}
+	cwd := spec.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	if err := os.Chdir(cwd); err != nil {
+		return fmt.Errorf("cannot use working directory %s: %s", cwd, err)
+	}
+	if err := dropBoundingSet(); err != nil {
+		return err
+	}
+	if spec.SetUser {
+		if spec.SetGroups {
+			groups := []int{}
+			for _, g := range spec.Groups {
+				groups = append(groups, int(g))
+			}
+			if err := syscall.Setgroups(groups); err != nil {
+				return fmt.Errorf("cannot set groups: %s", err)
+			}
+		}
+		if err := syscall.Setgid(int(spec.Gid)); err != nil {
+			return fmt.Errorf("cannot set gid: %s", err)
+		}
+		if err := syscall.Setuid(int(spec.Uid)); err != nil {
+			return fmt.Errorf("cannot set uid: %s", err)
+		}
+	}
+	if err := clearCapabilities(); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot forbid new privileges: %s", errno)
+	}
+	prog, err := exec.LookPath(spec.Args[0])
+	if err != nil {
+		return fmt.Errorf("cannot locate command %s: %s", spec.Args[0], err)
+	}
+	return syscall.Exec(prog, spec.Args, os.Environ())
+}
+
+// enterSandbox makes the sandbox the root filesystem.  Changing root
+// alone leaves the host's filesystem mounted, where a command able to
+// change root again could reach it, so the host's root is moved out of
+// the way and detached.
+func enterSandbox(root string) error {
+	if err := os.Chdir(root); err != nil {
+		return fmt.Errorf("cannot change root to %s: %s", root, err)
+	}
+	// Given the same directory twice, pivot_root stacks the old root on
+	// top of the new one, from where it can be unmounted.
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("cannot change root to %s: %s", root, err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("cannot detach the host filesystem: %s", err)
+	}
+	return os.Chdir("/")
+}
+
+// dropBoundingSet removes every capability from the bounding set, so
+// that no program the command executes can gain one, even as root.
+func dropBoundingSet() error {
+	for c := 0; ; c++ {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0)
+		// Capabilities past the last one the kernel knows are invalid.
+		if errno == syscall.EINVAL {
+			return nil
+		}
+		if errno != 0 {
+			return fmt.Errorf("cannot drop capabilities: %s", errno)
+		}
+	}
+}
+
+// clearCapabilities removes every capability the process holds.
+func clearCapabilities() error {
+	header := struct {
+		version uint32
+		pid int32
+	}{version: LINUX_CAPABILITY_VERSION_3}
+	data := [2]struct {
+		effective uint32
+		permitted uint32
+		inheritable uint32
+	}{}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("cannot clear capabilities: %s", errno)
+	}
+	return nil
+}
+
+// bindIntoSandbox makes a host path visible inside the sandbox, creating
+// the mount point when it is missing.  Default binds are skipped when
+// the host lacks them, or when the sandbox has its own copy, so that a
+// sandbox holding a whole root filesystem keeps its own binaries.
+func bindIntoSandbox(root string, b sandboxBind) error {
+	info, err := os.Stat(b.Src)
+	if err != nil {
+		if b.Default {
+			return nil
+		}
+		return fmt.Errorf("cannot bind %s: %s", b.Src, err)
+	}
+	target := filepath.Join(root, b.Dst)
+	if b.Default && providedBySandbox(target) {
+		return nil
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var f *os.File
+		f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create mount point %s: %s", target, err)
+	}
+	if err := syscall.Mount(b.Src, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("cannot bind %s: %s", b.Src, err)
+	}
+	if !b.ReadOnly {
+		return nil
+	}
+	// A remount only changes the mount it names, so mounts beneath the
+	// bind are remounted one by one.
+	mounts, err := mountsUnder("/proc/self/mountinfo", target)
+	if err != nil {
+		return fmt.Errorf("cannot make %s read-only: %s", b.Src, err)
+	}
+	for _, m := range mounts {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m, &st); err != nil {
+			return fmt.Errorf("cannot read mount flags of %s: %s", m, err)
+		}
+		flags := uintptr(st.Flags) & SANDBOX_LOCKED_FLAGS
+		if err := syscall.Mount("none", m, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_REC|flags, ""); err != nil {
+			return fmt.Errorf("cannot make %s read-only: %s", b.Src, err)
+		}
+	}
+	return nil
+}
+
+// mountsUnder lists the mount points at or beneath a path, from a mount
+// table in the format of /proc/self/mountinfo.
+func mountsUnder(mountinfo string, path string) ([]string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	mounts := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		m := unescapeMountPath(fields[4])
+		if seen[m] || (m != path && !strings.HasPrefix(m, path+"/")) {
+			continue
+		}
+		seen[m] = true
+		mounts = append(mounts, m)
+	}
+	if len(mounts) == 0 {
+		mounts = append(mounts, path)
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath decodes a path from the mount table, where spaces,
+// tabs, newlines, and backslashes are written as octal escapes.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				out.WriteByte(byte(c))
+				i = i + 3
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// providedBySandbox reports whether a path exists in the sandbox as more
+// than an empty mount point.
+func providedBySandbox(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	switch {
+	case info.IsDir():
+		f, err := os.Open(path)
+		if err != nil {
+			return true
+		}
+		defer f.Close()
+		_, err = f.Readdirnames(1)
+		return err != io.EOF
+	case info.Mode().IsRegular():
+		return info.Size() > 0
+	}
+	return true
+}
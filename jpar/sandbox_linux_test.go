@@ -0,0 +1,134 @@
+package jpar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// SANDBOX_ESCAPE makes the test binary try to leave the sandbox it runs in.
+const SANDBOX_ESCAPE = "__sandbox-escape"
+
+// TestMain lets the test binary act as jpar when it starts a sandbox, and
+// as a command trying to escape one.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == SANDBOX_INIT {
+		SandboxInit(os.Args[2:])
+	}
+	if len(os.Args) > 2 && os.Args[1] == SANDBOX_ESCAPE {
+		escapeSandbox(os.Args[2])
+	}
+	os.Exit(m.Run())
+}
+
+// escapeSandbox changes root to a directory inside the sandbox, leaving
+// its working directory outside it, and climbs from there to the root of
+// the host to look for a path.
+func escapeSandbox(path string) {
+	os.Mkdir("/inner", 0755)
+	if err := syscall.Chroot("/inner"); err != nil {
+		fmt.Printf("cannot change root: %s\n", err)
+	}
+	for i := 0; i < 64; i++ {
+		os.Chdir("..")
+	}
+	syscall.Chroot(".")
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("escaped to %s\n", path)
+	}
+	os.Exit(0)
+}
+
+func TestRunJobSandbox(t *testing.T) {
+	root := t.TempDir()
+	data := t.TempDir()
+	os.WriteFile(filepath.Join(data, "in.txt"), []byte("hello\n"), 0644)
+	o := &Options{Sandbox: root, SandboxBinds: []string{data + ":/data:ro"}}
+	cmd := parseCmd(t, "sh", "-c", "cat in.txt; ls /; touch /data/out.txt")
+	cmd.Cwd = parseTemplate(t, "/data")
+	r := runJob(context.Background(), o, cmd, nil, nil)
+	if strings.Contains(r["stderr"].(string), "cannot make mounts private") {
+		t.Skip("mount namespaces are not available")
+	}
+	stdout, _ := r["stdout"].(string)
+	if !strings.HasPrefix(stdout, "hello\n") {
+		t.Fatalf("expected the bound file to be readable, got %v", r)
+	}
+	for _, name := range strings.Fields(stdout)[1:] {
+		if name != "bin" && name != "data" && name != "dev" && name != "lib" && name != "lib64" && name != "usr" {
+			t.Errorf("expected only bound paths in the sandbox, found %s", name)
+		}
+	}
+	if r["outcome"] != OUTCOME_FAILURE || !strings.Contains(r["stderr"].(string), "Read-only") {
+		t.Errorf("expected the read-only bind to reject writes, got %v", r)
+	}
+	if _, err := os.Stat(filepath.Join(data, "out.txt")); err == nil {
+		t.Error("expected no file written through the read-only bind")
+	}
+	r = runJob(context.Background(), o, parseCmd(t, "no-such-command"), nil, nil)
+	if r["exit_code"] != EXIT_SANDBOX_FAILED || !strings.Contains(r["stderr"].(string), "cannot locate command") {
+		t.Errorf("expected the sandbox to report the missing command, got %v", r)
+	}
+}
+
+func TestRunJobSandboxEscape(t *testing.T) {
+	root := t.TempDir()
+	host := filepath.Join(t.TempDir(), "host.txt")
+	os.WriteFile(host, []byte("host\n"), 0644)
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &Options{Sandbox: root, SandboxBinds: []string{self + ":/escape:ro"}}
+	r := runJob(context.Background(), o, parseCmd(t, "/escape", SANDBOX_ESCAPE, host), nil, nil)
+	if strings.Contains(r["stderr"].(string), "cannot make mounts private") {
+		t.Skip("mount namespaces are not available")
+	}
+	stdout, _ := r["stdout"].(string)
+	if r["outcome"] != OUTCOME_SUCCESS || strings.Contains(stdout, "escaped") {
+		t.Errorf("expected a nested chroot not to reach the host, got %v", r)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/mnt/a": "/mnt/a",
+		"/mnt/a\\040b": "/mnt/a b",
+		"/mnt/a\\040": "/mnt/a ",
+		"/mnt/a\\134": "/mnt/a\\",
+		"/mnt/a\\04": "/mnt/a\\04",
+	} {
+		if got := unescapeMountPath(path); got != want {
+			t.Errorf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestMountsUnder(t *testing.T) {
+	dir := t.TempDir()
+	bind := filepath.Join(dir, "data")
+	os.Mkdir(bind, 0755)
+	path := filepath.Join(dir, "mountinfo")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"22 1 0:21 / /proc rw,nosuid shared:12 - proc proc rw",
+		"40 22 0:30 / " + bind + " rw - ext4 /dev/sda1 rw",
+		"41 40 0:31 / " + bind + "/a\\040 rw - tmpfs tmpfs rw",
+		"42 40 0:32 / " + bind + "2 rw - tmpfs tmpfs rw",
+	}, "\n")), 0644)
+	mounts, err := mountsUnder(path, bind)
+	want := []string{bind, bind + "/a "}
+	if err != nil || !reflect.DeepEqual(mounts, want) {
+		t.Errorf("expected %q, got %q: %v", want, mounts, err)
+	}
+}
+
+func TestSandboxInitWithoutCommand(t *testing.T) {
+	if err := sandboxInit([]string{`{"Root":"/nonexistent"}`}); err == nil || !strings.Contains(err.Error(), "expected a command") {
+		t.Errorf("expected an error for a sandbox without a command, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package jpar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// sandboxCommand fails, since sandboxes are only supported on Linux.
+func sandboxCommand(o *Options, c *exec.Cmd, cwd string) error {
+	return errors.New("--sandbox is only supported on Linux")
+}
+
+// SandboxInit exits at once, since sandboxes are only supported on
+// Linux.
+func SandboxInit(argv []string) {
+	fmt.Fprintln(os.Stderr, "cannot run command in sandbox: --sandbox is only supported on Linux")
+	os.Exit(EXIT_SANDBOX_FAILED)
+}
@@ -0,0 +1,29 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestParseSandboxBind(t *testing.T) {
+	cases := []struct {
+		in string
+		want sandboxBind
+	}{
+		{"/data", sandboxBind{Src: "/data", Dst: "/data"}},
+		{"/data:ro", sandboxBind{Src: "/data", Dst: "/data", ReadOnly: true}},
+		{"/srv/data/:/data", sandboxBind{Src: "/srv/data", Dst: "/data"}},
+		{"/srv/data:/data:rw", sandboxBind{Src: "/srv/data", Dst: "/data"}},
+		{"/srv/data:/data:ro", sandboxBind{Src: "/srv/data", Dst: "/data", ReadOnly: true}},
+	}
+	for _, c := range cases {
+		got, err := parseSandboxBind(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("parseSandboxBind(%s) = %+v, %v, want %+v", c.in, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"data", "/a:b", "/a:/b:/c", "/a:/b:rx"} {
+		if _, err := parseSandboxBind(s); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
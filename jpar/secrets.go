@@ -0,0 +1,63 @@
+package jpar
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var secretName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loadSecrets reads the secrets given as NAME=env:VAR or NAME=file:PATH.
+// A trailing newline is removed from secrets read from files.
+func loadSecrets(specs []string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || !secretName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("secret %s must have the form NAME=env:VAR or NAME=file:PATH", spec)
+		}
+		name, source := parts[0], parts[1]
+		switch {
+		case strings.HasPrefix(source, "env:"):
+			v, ok := os.LookupEnv(strings.TrimPrefix(source, "env:"))
+			if !ok {
+				return nil, fmt.Errorf("secret %s: %s is not set", name, strings.TrimPrefix(source, "env:"))
+			}
+			secrets[name] = v
+		case strings.HasPrefix(source, "file:"):
+			b, err := os.ReadFile(strings.TrimPrefix(source, "file:"))
+			if err != nil {
+				return nil, fmt.Errorf("cannot read secret %s: %s", name, err)
+			}
+			secrets[name] = strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+		default:
+			return nil, fmt.Errorf("secret %s must come from env:VAR or file:PATH", name)
+		}
+	}
+	return secrets, nil
+}
+
+// secretsMeta returns the secrets as they are seen by templates under
+// _secrets.
+func secretsMeta(o *Options) map[string]interface{} {
+	m := map[string]interface{}{}
+	for name, v := range o.secrets {
+		m[name] = v
+	}
+	return m
+}
+
+// secretsEnv returns the environment entries which pass the secrets to
+// commands.
+func secretsEnv(o *Options) []string {
+	env := []string{}
+	for name, v := range o.secrets {
+		env = append(env, name+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
@@ -0,0 +1,48 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSecrets(t *testing.T) {
+	t.Setenv("JPAR_TEST_TOKEN", "s3cret")
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("from-file\n"), 0600)
+	secrets, err := loadSecrets([]string{"TOKEN=env:JPAR_TEST_TOKEN", "KEY=file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["TOKEN"] != "s3cret" || secrets["KEY"] != "from-file" {
+		t.Errorf("unexpected secrets %v", secrets)
+	}
+	for _, spec := range []string{"TOKEN", "TOKEN=JPAR_TEST_TOKEN", "TOKEN=env:JPAR_TEST_MISSING", "KEY=file:/no/such/file", "BAD-NAME=env:JPAR_TEST_TOKEN"} {
+		if _, err := loadSecrets([]string{spec}); err == nil {
+			t.Errorf("expected an error for %s", spec)
+		}
+	}
+}
+
+func TestRunnerSecrets(t *testing.T) {
+	t.Setenv("JPAR_TEST_TOKEN", "s3cret")
+	var logs bytes.Buffer
+	o := NewOptions()
+	o.Args = []string{"sh", "-c", "echo $TOKEN {{_secrets.TOKEN}} {{n}}"}
+	o.Secrets = []string{"TOKEN=env:JPAR_TEST_TOKEN"}
+	o.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(`{"n":1}`), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"stdout":"[REDACTED] [REDACTED] 1\n"`) || !strings.Contains(out.String(), `"echo $TOKEN [REDACTED] 1"`) {
+		t.Errorf("expected the secret to be passed and masked, got %s", out.String())
+	}
+	if strings.Contains(out.String()+logs.String(), "s3cret") {
+		t.Errorf("expected no secret in results or logs, got %s%s", out.String(), logs.String())
+	}
+}
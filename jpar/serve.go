@@ -0,0 +1,541 @@
+package jpar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/itchyny/gojq"
+)
+
+// Listen listens on an address given as unix:///path/to/socket or
+// tcp://host:port.
+func Listen(addr string) (net.Listener, error) {
+	network := ""
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network = "unix"
+	case strings.HasPrefix(addr, "tcp://"):
+		network = "tcp"
+	default:
+		return nil, fmt.Errorf("listen address %s must start with unix:// or tcp://", addr)
+	}
+	l, err := net.Listen(network, strings.TrimPrefix(addr, network+"://"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %s", addr, err)
+	}
+	return l, nil
+}
+
+// resultSink receives the results of the records it submits to the
+// pool, along with their sequence numbers.
+type resultSink interface {
+	write(seq int, v interface{})
+	// add is called when a job is submitted, and done after its result.
+	add()
+	done()
+}
+
+// SERVE_CONN_BUFFER is how many results may wait to be written to a
+// connection before its client is taken to have stopped reading.
+const SERVE_CONN_BUFFER = 1024
+
+// serveConn is a connection which sends records to the server.
+type serveConn struct {
+	conn net.Conn
+	// results is the connection, framing results in the output format.
+	results io.Writer
+	format string
+	// queue holds the results waiting to be written by the connection's
+	// own writer, so a client which stops reading holds up nobody else.
+	// written is closed once the writer has finished.
+	queue chan interface{}
+	written chan struct{}
+	// mu guards dropped, which is set once the client has fallen so far
+	// behind that it is disconnected, and pending counts the jobs whose
+	// results have not been written.
+	mu sync.Mutex
+	dropped bool
+	pending sync.WaitGroup
+}
+
+func newServeConn(o *Options, renames map[string]string, conn net.Conn) *serveConn {
+	c := &serveConn{
+		conn: conn,
+		results: resultWriter(o, renames, conn),
+		format: o.OutputFormat,
+		queue: make(chan interface{}, SERVE_CONN_BUFFER),
+		written: make(chan struct{}),
+	}
+	go c.writeResults()
+	return c
+}
+
+// write queues a result for the client.  A client whose queue is full
+// has stopped reading, and is disconnected rather than allowed to stall
+// the pool.
+func (c *serveConn) write(seq int, v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return
+	}
+	select {
+	case c.queue <- v:
+	default:
+		c.dropped = true
+		c.conn.Close()
+	}
+}
+
+// writeResults writes the queued results to the client until the queue
+// is closed.  Writes to a dropped client fail, which empties the queue.
+func (c *serveConn) writeResults() {
+	defer close(c.written)
+	for v := range c.queue {
+		writeResult(c.results, c.format, v)
+	}
+}
+
+// flush waits for the queued results to be written, and reports whether
+// the client was dropped.
+func (c *serveConn) flush() bool {
+	close(c.queue)
+	<-c.written
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+func (c *serveConn) add() {
+	c.pending.Add(1)
+}
+
+func (c *serveConn) done() {
+	c.pending.Done()
+}
+
+// closeRead stops reading records while results can still be written.
+func (c *serveConn) closeRead() {
+	if r, ok := c.conn.(interface{ CloseRead() error }); ok {
+		r.CloseRead()
+		return
+	}
+	c.conn.Close()
+}
+
+// servePool is the pool of workers kept running by Serve.
+type servePool struct {
+	o *Options
+	ctx context.Context
+	cmd *CommandTemplate
+	renames map[string]string
+	filter *gojq.Code
+	when *gojq.Code
+	outputFilter *gojq.Code
+	hook *webhook
+	systemLog resultLog
+	tracer *jobTracer
+	metrics *statsdMetrics
+	tokens chan struct{}
+	jobs chan Job
+	gate *pauseGate
+	// runId identifies the results of this server.
+	runId string
+	// route maps the sequence number of each job to the sink which
+	// submitted its record.  Sequence numbers count every result the
+	// server has produced.
+	mu sync.Mutex
+	route map[int]resultSink
+	seq int
+	stats poolStats
+}
+
+// poolStats counts the jobs of the pool.
+type poolStats struct {
+	Submitted int
+	Waiting int
+	Running int
+	Completed int
+	Failed int
+	Connections int
+}
+
+// next allocates a sequence number.
+func (p *servePool) next() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seq := p.seq
+	p.seq = p.seq + 1
+	return seq
+}
+
+// accept submits the jobs for a record from sink, answering records
+// which are filtered out or fail their condition directly.  It returns
+// the sequence numbers of the record's results, and false once shutdown
+// has begun or ctx is done.
+func (p *servePool) accept(ctx context.Context, sink resultSink, v interface{}) ([]int, bool) {
+	o := p.o
+	seqs := []int{}
+	reply := func(r map[string]interface{}) {
+		seq := p.next()
+		seqs = append(seqs, seq)
+		sink.write(seq, identifyResult(r, p.runId, seq))
+	}
+	values := []interface{}{v}
+	if p.filter != nil {
+		var err error
+		values, err = applyFilter(p.filter, v)
+		if err != nil {
+			r := skippedResult(v, "")
+			r["error"] = fmt.Sprintf("filter error: %s", err)
+			r["outcome"] = OUTCOME_FAILURE
+			reply(r)
+			return seqs, true
+		}
+		if len(values) == 0 && o.EmitSkipped {
+			reply(skippedResult(v, "filtered"))
+		}
+	}
+	if o.Matrix {
+		expanded := []interface{}{}
+		for _, v := range values {
+			expanded = append(expanded, expandMatrix(v)...)
+		}
+		values = expanded
+	}
+	for _, v := range values {
+		if p.when != nil {
+			met, err := conditionMet(p.when, v)
+			if err != nil {
+				r := skippedResult(v, "")
+				r["error"] = fmt.Sprintf("condition error: %s", err)
+				r["outcome"] = OUTCOME_FAILURE
+				reply(r)
+				continue
+			}
+			if !met {
+				reply(skippedResult(v, "condition not met"))
+				continue
+			}
+		}
+		seq, ok := p.submit(ctx, sink, v)
+		if !ok {
+			return seqs, false
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs, true
+}
+
+// submit hands a record to the workers once the pool is not paused.
+// It returns false once shutdown has begun or ctx is done.
+func (p *servePool) submit(ctx context.Context, sink resultSink, v interface{}) (int, bool) {
+	p.count(&p.stats.Waiting, 1)
+	defer p.count(&p.stats.Waiting, -1)
+	// Waiting ends at shutdown as well.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+	if p.tokens != nil {
+		select {
+		case <-p.tokens:
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+	if !p.gate.wait(ctx) {
+		return 0, false
+	}
+	p.mu.Lock()
+	job := Job{Value: v, Seq: p.seq}
+	p.route[p.seq] = sink
+	p.seq = p.seq + 1
+	p.mu.Unlock()
+	if p.o.Key != "" {
+		job.Key = jobKey(p.cmd, v)
+	}
+	sink.add()
+	select {
+	case p.jobs <- job:
+		p.count(&p.stats.Submitted, 1)
+		p.count(&p.stats.Running, 1)
+		return job.Seq, true
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.route, job.Seq)
+		p.mu.Unlock()
+		sink.done()
+		return 0, false
+	}
+}
+
+// count adds n to one of the pool's statistics.
+func (p *servePool) count(stat *int, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*stat = *stat + n
+}
+
+// deliver sends the results of the workers to the sinks which submitted
+// their records.
+func (p *servePool) deliver(results chan Output) {
+	o := p.o
+	for x := range results {
+		if x.Done {
+			return
+		}
+		p.mu.Lock()
+		sink := p.route[x.Seq]
+		if !x.Event {
+			delete(p.route, x.Seq)
+			p.stats.Running = p.stats.Running - 1
+			p.stats.Completed = p.stats.Completed + 1
+			if jobFailed(x.Value) {
+				p.stats.Failed = p.stats.Failed + 1
+			}
+		}
+		p.mu.Unlock()
+		if x.Event {
+			sink.write(x.Seq, x.Value)
+			continue
+		}
+		x.Value = identifyResult(x.Value, p.runId, x.Seq)
+		if jobFailed(x.Value) && o.FailuresOutput != nil {
+			writeFailure(o, x.Value)
+		}
+		logResult(o, p.systemLog, x)
+		p.tracer.job(x)
+		p.metrics.job(x)
+		shaped := shapeResult(o.OutputFields, p.renames, echoInput(o, x.Value))
+		p.hook.result(shaped)
+		if showResult(o.Only, x.Value) {
+			for _, v := range filterResult(p.outputFilter, shaped) {
+				sink.write(x.Seq, v)
+			}
+		}
+		sink.done()
+	}
+}
+
+// record returns the pool's statistics as a record.
+func (p *servePool) record() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"workers": p.o.Parallelism,
+		"paused": p.gate.paused(),
+		"submitted": p.stats.Submitted,
+		"waiting": p.stats.Waiting,
+		"running": p.stats.Running,
+		"completed": p.stats.Completed,
+		"failed": p.stats.Failed,
+		"connections": p.stats.Connections,
+	}
+}
+
+// handle reads the records sent on a connection.
+func (p *servePool) handle(c *serveConn, read func(io.Reader) chan JsonRead) {
+	lg := logger(p.o)
+	records := read(c.conn)
+	for x := range records {
+		if x.Err != nil {
+			lg.Warn("cannot parse input", "remote", c.conn.RemoteAddr().String(), "error", x.Err.Error())
+			seq := p.next()
+			c.write(seq, identifyResult(parseErrorResult(x), p.runId, seq))
+			continue
+		}
+		if _, ok := p.accept(p.ctx, c, x.Value); !ok {
+			break
+		}
+	}
+	c.pending.Wait()
+	if c.flush() {
+		lg.Warn("dropped a connection which stopped reading its results", "remote", c.conn.RemoteAddr().String())
+	}
+	if t, ok := c.results.(*tapOutput); ok {
+		t.finish()
+	}
+	c.conn.Close()
+	// The reader stops once the connection is closed.
+	for _ = range records {
+	}
+}
+
+// Serve keeps a pool of workers running, and accepts records from the
+// connections made to l and through the gRPC API served on g.  Either
+// listener may be nil.  Each connection sends records in the input
+// format, and receives the results of its own records as they finish.
+// The connection is closed once the client has closed its side for
+// writing and its jobs have finished.  Cancelling ctx stops accepting
+// records and shuts the pool down as Run does.  Serve then returns
+// ctx.Err().
+func (runner *Runner) Serve(ctx context.Context, l net.Listener, g net.Listener) error {
+	o := runner.opts
+	if err := validateOptions(o); err != nil {
+		return err
+	}
+	if l == nil && g == nil {
+		return errors.New("serve requires a listener")
+	}
+	o, err := withRedaction(o)
+	if err != nil {
+		return err
+	}
+	// These options work on the whole input, which a server never has.
+	if o.Batch > 0 || o.Dag || o.GroupBy != "" || o.KeepOrder || o.RequeueFailures || o.StateFile != "" ||
+		o.Dedupe || o.DedupeKey != "" || o.Replay || o.Summary || len(o.Reports) > 0 || (o.Webhook != "" && o.WebhookOn == WEBHOOK_ON_SUMMARY) || o.HaltOnError ||
+		o.Head > 0 || o.Skip > 0 || o.Sample > 0 || o.QueueSize > 0 || o.PriorityField != "" || len(o.Inputs) > 0 {
+		return errors.New("serve cannot be used with options which need the whole input, such as --batch, --dag, or --keep-order")
+	}
+	renames, err := parseRenames(o.Rename)
+	if err != nil {
+		return err
+	}
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		return err
+	}
+	filter, err := compileFilter(o.Filter)
+	if err != nil {
+		return err
+	}
+	when, err := compileCondition(o.When)
+	if err != nil {
+		return err
+	}
+	outputFilter, err := compileOutputFilter(o.OutputFilter)
+	if err != nil {
+		return err
+	}
+	read, err := inputReader(o)
+	if err != nil {
+		return err
+	}
+	systemLog, err := openResultLog(o)
+	if err != nil {
+		return err
+	}
+	if systemLog != nil {
+		defer systemLog.Close()
+	}
+	tracer, err := startTracing(o, "jpar serve")
+	if err != nil {
+		return err
+	}
+	metrics, err := openStatsd(o)
+	if err != nil {
+		return err
+	}
+	defer metrics.Close()
+	lg := logger(o)
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	p := &servePool{
+		o: o,
+		ctx: ctx,
+		cmd: cmd,
+		renames: renames,
+		filter: filter,
+		when: when,
+		outputFilter: outputFilter,
+		hook: newWebhook(o),
+		systemLog: systemLog,
+		tracer: tracer,
+		metrics: metrics,
+		jobs: make(chan Job),
+		gate: &pauseGate{},
+		runId: newRunId(),
+		route: map[int]resultSink{},
+	}
+	if o.Rate > 0 {
+		p.tokens = tokenBucket(ctx, o.Rate, o.RateBurst)
+	}
+	results := make(chan Output)
+	workerDone := make(chan struct{})
+	outputDone := make(chan struct{})
+	for i := 0; i < o.Parallelism; i++ {
+		go worker(ctx, o, i, cmd, p.jobs, results, workerDone, func(Job, map[string]interface{}) {}, nil, nil)
+	}
+	go func() {
+		p.deliver(results)
+		outputDone <- struct{}{}
+	}()
+	var api *grpcPool
+	if g != nil {
+		api = newGrpcPool(p)
+		go api.serve(g)
+		lg.Info("serving grpc", "address", g.Addr().String())
+	}
+	// open holds the connections which are still being read.
+	var conns sync.WaitGroup
+	open := map[*serveConn]bool{}
+	var acceptErr error
+	if l == nil {
+		<-ctx.Done()
+	} else {
+		go func() {
+			<-ctx.Done()
+			l.Close()
+		}()
+		lg.Info("serving", "address", l.Addr().String())
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					acceptErr = err
+					cancel()
+				}
+				break
+			}
+			c := newServeConn(o, renames, conn)
+			lg.Debug("connection opened", "remote", conn.RemoteAddr().String())
+			p.mu.Lock()
+			open[c] = true
+			p.stats.Connections = p.stats.Connections + 1
+			p.mu.Unlock()
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				p.handle(c, read)
+				p.mu.Lock()
+				delete(open, c)
+				p.stats.Connections = p.stats.Connections - 1
+				p.mu.Unlock()
+				lg.Debug("connection closed", "remote", c.conn.RemoteAddr().String())
+			}()
+		}
+	}
+	lg.Info("shutting down")
+	// Running jobs are stopped, and their results are still sent to the
+	// clients which are waiting for them.
+	p.mu.Lock()
+	for c := range open {
+		c.closeRead()
+	}
+	p.mu.Unlock()
+	conns.Wait()
+	if api != nil {
+		api.stop()
+	}
+	for i := 0; i < o.Parallelism; i++ {
+		p.jobs <- Job{Done: true}
+	}
+	waitForTermination(workerDone, o.Parallelism)
+	results <- Output{Done: true}
+	waitForTermination(outputDone, 1)
+	p.hook.wait()
+	if err := tracer.finish(p.stats.Completed, p.stats.Failed); err != nil {
+		lg.Warn(err.Error())
+	}
+	if acceptErr != nil {
+		return fmt.Errorf("cannot accept connection: %s", acceptErr)
+	}
+	return parent.Err()
+}
@@ -0,0 +1,126 @@
+package jpar
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	for _, addr := range []string{"localhost:0", "http://localhost:0"} {
+		if _, err := Listen(addr); err == nil {
+			t.Errorf("%s: expected an error", addr)
+		}
+	}
+	l, err := Listen("unix://" + filepath.Join(t.TempDir(), "jpar.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
+
+func TestServe(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"echo", "{{client}}-{{n}}"}
+	o.When = ".n > 0"
+	o.Parallelism = 2
+	l, err := Listen("unix://" + filepath.Join(t.TempDir(), "jpar.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- NewRunner(o).Serve(ctx, l, nil)
+	}()
+	var wg sync.WaitGroup
+	for _, client := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(client string) {
+			defer wg.Done()
+			conn, err := net.Dial("unix", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			for _, n := range []string{"0", "1", "2"} {
+				conn.Write([]byte(`{"client":"` + client + `","n":` + n + "}\n"))
+			}
+			conn.(*net.UnixConn).CloseWrite()
+			results := map[string]bool{}
+			skipped := 0
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.Contains(line, `"outcome":"SKIPPED"`) {
+					skipped = skipped + 1
+				}
+				for _, want := range []string{client + "-1", client + "-2"} {
+					if strings.Contains(line, `"stdout":"`+want+`\n"`) {
+						results[want] = true
+					}
+				}
+			}
+			if len(results) != 2 || skipped != 1 {
+				t.Errorf("client %s: expected its own two results and one skipped record, got %v and %d skipped", client, results, skipped)
+			}
+		}(client)
+	}
+	wg.Wait()
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected the server to stop when cancelled, got %v", err)
+	}
+	o.Dag = true
+	if err := NewRunner(o).Serve(context.Background(), l, nil); err == nil {
+		t.Error("expected an error for --dag")
+	}
+}
+
+func TestServeStalledClient(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"head", "-c", "{{size}}", "/dev/zero"}
+	o.Parallelism = 2
+	l, err := Listen("unix://" + filepath.Join(t.TempDir(), "jpar.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- NewRunner(o).Serve(ctx, l, nil)
+	}()
+	// The stalled client's results are far more than the socket holds,
+	// and it never reads them.
+	stalled, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		stalled.Write([]byte(`{"size":"1000000"}` + "\n"))
+	}
+	time.Sleep(500 * time.Millisecond)
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(`{"size":"3"}` + "\n"))
+	conn.(*net.UnixConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() || !strings.Contains(scanner.Text(), `"stdout":"\u0000\u0000\u0000"`) {
+		t.Errorf("expected a result despite the stalled client, got %q: %v", scanner.Text(), scanner.Err())
+	}
+	conn.Close()
+	stalled.Close()
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected the server to stop when cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package jpar
+
+import (
+	"strings"
+)
+
+const SHELL_POSIX = "posix"
+const SHELL_CMD = "cmd"
+const SHELL_POWERSHELL = "powershell"
+
+// shellKind identifies the kind of shell by its program name, since
+// cmd.exe and PowerShell take scripts and quoting differently from
+// POSIX shells.
+func shellKind(path string) string {
+	name := strings.ToLower(path[strings.LastIndexAny(path, `/\`)+1:])
+	switch strings.TrimSuffix(name, ".exe") {
+	case "cmd":
+		return SHELL_CMD
+	case "powershell", "pwsh":
+		return SHELL_POWERSHELL
+	}
+	return SHELL_POSIX
+}
+
+// shellCommand returns the command which runs a script with a shell.
+func shellCommand(path, script string) []string {
+	switch shellKind(path) {
+	case SHELL_CMD:
+		return []string{path, "/C", script}
+	case SHELL_POWERSHELL:
+		return []string{path, "-NoProfile", "-Command", script}
+	}
+	return []string{path, "-c", script}
+}
+
+// shellQuoter returns the function quoting literal words for a shell.
+func shellQuoter(path string) func(string) string {
+	switch shellKind(path) {
+	case SHELL_CMD:
+		return cmdQuote
+	case SHELL_POWERSHELL:
+		return powershellQuote
+	}
+	return shellQuote
+}
+
+// shellQuote quotes a string so that the shell treats it as a single
+// literal word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// cmdQuote quotes a string as a single word for cmd.exe.  cmd.exe still
+// expands %VARIABLES% inside quotes, so PowerShell is safer for values
+// which cannot be trusted.
+func cmdQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// powershellQuote quotes a string as a literal PowerShell string.
+func powershellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// shellQuoteValues returns a copy of a decoded JSON value with every
+// string shell quoted.  Numbers, booleans, and nulls cannot contain shell
+// syntax and are left alone.
+func shellQuoteValues(v interface{}, quote func(string) string) interface{} {
+	switch x := v.(type) {
+	case string:
+		return quote(x)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = shellQuoteValues(e, quote)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(x))
+		for i, e := range x {
+			l[i] = shellQuoteValues(e, quote)
+		}
+		return l
+	}
+	return v
+}
+
+// shellQuoteMeta quotes the values of the job metadata.
+func shellQuoteMeta(meta map[string]interface{}, quote func(string) string) map[string]interface{} {
+	if meta == nil {
+		return nil
+	}
+	return shellQuoteValues(meta, quote).(map[string]interface{})
+}
@@ -0,0 +1,41 @@
+package jpar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":         "''",
+		"a b":      "'a b'",
+		"it's":     `'it'\''s'`,
+		"$(rm -r)": "'$(rm -r)'",
+	}
+	for in, want := range cases {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	cases := []struct {
+		path string
+		want []string
+		quoted string
+	}{
+		{"/bin/sh", []string{"/bin/sh", "-c", "x"}, `'it'\''s'`},
+		{`C:\Windows\System32\cmd.exe`, []string{`C:\Windows\System32\cmd.exe`, "/C", "x"}, `"it's"`},
+		{"pwsh", []string{"pwsh", "-NoProfile", "-Command", "x"}, "'it''s'"},
+	}
+	for _, c := range cases {
+		got := shellCommand(c.path, "x")
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("shellCommand(%s) = %q, want %q", c.path, got, c.want)
+		}
+		if q := shellQuoter(c.path)("it's"); q != c.quoted {
+			t.Errorf("quoted for %s = %s, want %s", c.path, q, c.quoted)
+		}
+	}
+}
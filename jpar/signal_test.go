@@ -0,0 +1,34 @@
+package jpar
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"TERM": syscall.SIGTERM,
+		"SIGINT": syscall.SIGINT,
+		"kill": syscall.SIGKILL,
+		"1": syscall.SIGHUP,
+	}
+	for s, want := range cases {
+		got, err := ParseSignal(s)
+		if err != nil {
+			t.Errorf("ParseSignal(%s): %s", s, err)
+		} else if got != want {
+			t.Errorf("ParseSignal(%s) = %d, want %d", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "NOPE", "-1"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Errorf("ParseSignal(%q) should fail", s)
+		}
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	if got := signalName(syscall.SIGTERM); got != "SIGTERM" {
+		t.Errorf("got %s, want SIGTERM", got)
+	}
+}
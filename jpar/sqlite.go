@@ -0,0 +1,131 @@
+package jpar
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const DEFAULT_SQLITE_TABLE = "runs"
+
+// sqliteColumns are the result fields stored in columns of their own.
+// Every other field is kept in the whole result, under record.
+var sqliteColumns = []string{"key", "outcome", "returncode", "duration_ms", "command", "e", "stdout", "stderr", "result", "error", "tags"}
+
+var sqliteTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isSqlite reports whether an output is a SQLite database.
+func isSqlite(dest string) bool {
+	return strings.HasPrefix(dest, "sqlite://")
+}
+
+// sqliteOutput inserts each result written to it as a row of a table,
+// which is created if it does not exist.  The input record, the command,
+// tags, and the parsed output are stored as JSON.
+type sqliteOutput struct {
+	db *sql.DB
+	insert *sql.Stmt
+}
+
+// openSqliteOutput opens a database given as sqlite://PATH?table=NAME.
+func openSqliteOutput(dest string) (*sqliteOutput, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dest, "sqlite://"), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse sqlite address %s: %s", dest, err)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("sqlite address %s requires a path", dest)
+	}
+	table := params.Get("table")
+	if table == "" {
+		table = DEFAULT_SQLITE_TABLE
+	}
+	if !sqliteTableName.MatchString(table) {
+		return nil, fmt.Errorf("sqlite table %s must be a plain name", table)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sqlite database %s: %s", path, err)
+	}
+	schema := fmt.Sprintf(`PRAGMA journal_mode=WAL;
+CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT,
+	outcome TEXT,
+	returncode INTEGER,
+	duration_ms INTEGER,
+	command TEXT,
+	input TEXT,
+	stdout TEXT,
+	stderr TEXT,
+	result TEXT,
+	error TEXT,
+	tags TEXT,
+	record TEXT NOT NULL,
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create sqlite table %s: %s", table, err)
+	}
+	insert, err := db.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (key, outcome, returncode, duration_ms, command, input, stdout, stderr, result, error, tags, record) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		table))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot prepare sqlite insert: %s", err)
+	}
+	return &sqliteOutput{db: db, insert: insert}, nil
+}
+
+// Write stores a result.  Records which are not results, such as output
+// events and the summary, are not stored.
+func (w *sqliteOutput) Write(p []byte) (int, error) {
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	var r map[string]interface{}
+	if err := d.Decode(&r); err != nil {
+		return 0, fmt.Errorf("sqlite output requires results written as json: %s", err)
+	}
+	if _, ok := r["outcome"]; !ok {
+		return len(p), nil
+	}
+	values := []interface{}{}
+	for _, col := range sqliteColumns {
+		values = append(values, sqliteValue(r[col]))
+	}
+	values = append(values, strings.TrimSpace(string(p)))
+	if _, err := w.insert.Exec(values...); err != nil {
+		return 0, fmt.Errorf("cannot insert result: %s", err)
+	}
+	return len(p), nil
+}
+
+// sqliteValue converts a field of a result into a column value.
+// Strings and numbers are stored as they are, and other values as JSON.
+func sqliteValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, string:
+		return x
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (w *sqliteOutput) Close() error {
+	w.insert.Close()
+	return w.db.Close()
+}
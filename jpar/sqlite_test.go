@@ -0,0 +1,49 @@
+package jpar
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	w, err := OpenOutput("sqlite://" + path + "?table=jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []interface{}{
+		map[string]interface{}{"outcome": OUTCOME_SUCCESS, "returncode": uint32(0), "duration_ms": 12, "command": []string{"echo", "a"}, "e": map[string]interface{}{"id": "a"}, "result": map[string]interface{}{"n": 1}},
+		map[string]interface{}{"outcome": OUTCOME_FAILURE, "returncode": RETURNCODE_FAILURE, "error": "cannot locate command"},
+		outputEvent(0, "stdout", "a"),
+	}
+	for _, r := range results {
+		writeResult(w, OUTPUT_FORMAT_NDJSON, r)
+	}
+	w.Close()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM jobs").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("expected two rows, got %d, %v", n, err)
+	}
+	var rc, duration int
+	var command, input, result string
+	row := db.QueryRow("SELECT returncode, duration_ms, command, input, result FROM jobs WHERE outcome = 'SUCCESS'")
+	if err := row.Scan(&rc, &duration, &command, &input, &result); err != nil {
+		t.Fatal(err)
+	}
+	if rc != 0 || duration != 12 || command != `["echo","a"]` || input != `{"id":"a"}` || result != `{"n":1}` {
+		t.Errorf("unexpected row %d %d %s %s %s", rc, duration, command, input, result)
+	}
+	var failure string
+	if err := db.QueryRow("SELECT error FROM jobs WHERE returncode = ?", RETURNCODE_FAILURE).Scan(&failure); err != nil || failure != "cannot locate command" {
+		t.Errorf("unexpected error column %q, %v", failure, err)
+	}
+	if _, err := OpenOutput("sqlite://" + path + "?table=a;b"); err == nil {
+		t.Error("expected an error for a table name which is not plain")
+	}
+}
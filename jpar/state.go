@@ -0,0 +1,98 @@
+package jpar
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// StateFile is a durable log of the keys of successfully completed
+// jobs.  Each line holds one key encoded as a JSON string.
+type StateFile struct {
+	file *os.File
+	// mu guards done, which is read while records are fed to the workers
+	// and written as their results come back.
+	mu sync.Mutex
+	done map[string]bool
+}
+
+// OpenStateFile loads the keys recorded by earlier runs and opens the
+// file for appending.
+func OpenStateFile(path string) (*StateFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open state file: %s", err)
+	}
+	s := &StateFile{file: f, done: map[string]bool{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var key string
+		if err := json.Unmarshal(scanner.Bytes(), &key); err != nil {
+			// A partial line is left behind if jpar dies mid-write.
+			continue
+		}
+		s.done[key] = true
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot read state file: %s", err)
+	}
+	return s, nil
+}
+
+func (s *StateFile) Completed(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done[key]
+}
+
+// Record durably marks a key as completed.
+func (s *StateFile) Record(key string) error {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.done[key] = true
+	s.mu.Unlock()
+	return s.file.Sync()
+}
+
+func (s *StateFile) Close() error {
+	return s.file.Close()
+}
+
+// recordHash identifies a record by the hash of its JSON encoding.  Map
+// keys are encoded in sorted order, so equal records hash equally.
+func recordHash(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// jobKey returns the key identifying a record.
+func jobKey(cmd *CommandTemplate, v interface{}) string {
+	if cmd.Key != nil {
+		return render(cmd.Key, v, nil)
+	}
+	return recordHash(v)
+}
+
+// dedupeKey returns the key which identifies duplicate records.
+func dedupeKey(cmd *CommandTemplate, v interface{}) string {
+	if cmd.Dedupe != nil {
+		return render(cmd.Dedupe, v, nil)
+	}
+	return recordHash(v)
+}
@@ -0,0 +1,48 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	s, err := OpenStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record("a\nb"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = OpenStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.Completed("a\nb") || s.Completed("c") {
+		t.Errorf("unexpected completed keys %v", s.done)
+	}
+}
+
+func TestRunnerResume(t *testing.T) {
+	o := NewOptions()
+	o.Args = []string{"sh", "-c", "exit {{rc}}"}
+	o.StateFile = filepath.Join(t.TempDir(), "state")
+	o.ExitStatus = EXIT_STATUS_NEVER
+	input := `{"rc":0}{"rc":1}`
+	var out bytes.Buffer
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := NewRunner(o).Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), `"outcome":"SKIPPED"`); n != 1 {
+		t.Errorf("expected the successful record to be skipped once, got %d:\n%s", n, out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package jpar
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// statsdMetrics sends a counter and a timing for every job to a StatsD
+// server over UDP.  Metrics are tagged with the job's outcome and its
+// --tag labels, in the DogStatsD format.
+type statsdMetrics struct {
+	conn net.Conn
+}
+
+// openStatsd returns nil when no server is given.
+func openStatsd(o *Options) (*statsdMetrics, error) {
+	if o.Statsd == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", o.Statsd)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to statsd %s: %s", o.Statsd, err)
+	}
+	return &statsdMetrics{conn}, nil
+}
+
+// job sends the metrics of a finished job.  Losing them is not an
+// error, as UDP gives no guarantee anyway.
+func (s *statsdMetrics) job(x Output) {
+	r, ok := x.Value.(map[string]interface{})
+	if s == nil || !ok || x.Event {
+		return
+	}
+	s.conn.Write([]byte(statsdLines(r)))
+}
+
+// statsdLines are the metrics of a job: jpar.jobs counts jobs, and
+// jpar.job.duration times those which ran.
+func statsdLines(r map[string]interface{}) string {
+	outcome, _ := r["outcome"].(string)
+	tags := []string{"outcome:" + statsdTag(outcome)}
+	if labels, ok := r["tags"].(map[string]interface{}); ok {
+		names := []string{}
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tags = append(tags, statsdTag(name)+":"+statsdTag(fieldValue(labels[name])))
+		}
+	}
+	suffix := "|#" + strings.Join(tags, ",")
+	lines := "jpar.jobs:1|c" + suffix
+	if !jobSkipped(r) {
+		lines = lines + fmt.Sprintf("\njpar.job.duration:%g|ms", resultSeconds(r)*1000) + suffix
+	}
+	return lines
+}
+
+// statsdTag replaces the characters which separate tags and metrics.
+func statsdTag(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case '|', ',', '#', ':', '\n':
+			return '_'
+		}
+		return c
+	}, s)
+}
+
+func (s *statsdMetrics) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
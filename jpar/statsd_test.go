@@ -0,0 +1,48 @@
+package jpar
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStatsd(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	o := NewOptions()
+	o.Statsd = l.LocalAddr().String()
+	s, err := openStatsd(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tests := []struct {
+		r map[string]interface{}
+		want string
+	}{
+		{
+			map[string]interface{}{"outcome": OUTCOME_FAILURE, "duration_ms": int64(1520), "tags": map[string]interface{}{"region": "eu", "db": "a,b"}},
+			"jpar.jobs:1|c|#outcome:FAILURE,db:a_b,region:eu\njpar.job.duration:1520|ms|#outcome:FAILURE,db:a_b,region:eu",
+		},
+		{
+			map[string]interface{}{"outcome": OUTCOME_SKIPPED},
+			"jpar.jobs:1|c|#outcome:SKIPPED",
+		},
+	}
+	buf := make([]byte, 1024)
+	for _, tc := range tests {
+		s.job(Output{Value: tc.r})
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != tc.want {
+			t.Errorf("expected %q, got %q", tc.want, buf[:n])
+		}
+	}
+	var none *statsdMetrics
+	none.job(Output{Value: tests[0].r})
+	none.Close()
+}
@@ -0,0 +1,161 @@
+package jpar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// parseSteps parses the commands run after the main command, one per
+// --then template.  With --shell each template is a script, and
+// otherwise it is split into words.
+func parseSteps(o *Options, cmd *CommandTemplate) ([]*CommandTemplate, error) {
+	steps := []*CommandTemplate{}
+	for _, src := range o.Then {
+		words := []string{src}
+		if !o.Shell {
+			var err error
+			if words, err = splitWords(src); err != nil {
+				return nil, err
+			}
+		}
+		if len(words) == 0 {
+			return nil, errors.New("--then requires a command")
+		}
+		step := *cmd
+		step.Args = nil
+		step.Then = nil
+		if cmd.Raw != nil {
+			step.Raw = words
+		}
+		if err := parseProgram(o, &step, words[0]); err != nil {
+			return nil, err
+		}
+		if cmd.Raw != nil {
+			steps = append(steps, &step)
+			continue
+		}
+		for _, w := range words {
+			t, err := parseMustache(w)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse command template %s: %s", src, err)
+			}
+			step.Args = append(step.Args, t)
+		}
+		steps = append(steps, &step)
+	}
+	return steps, nil
+}
+
+// splitWords splits a command into words at whitespace, as a shell
+// would.  Single and double quotes group words and are removed, and
+// whitespace inside template tags does not split words.
+func splitWords(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case strings.HasPrefix(s[i:], "{{"):
+			end := strings.Index(s[i:], "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated tag in %s", s)
+			}
+			end = i + end + 2
+			// Triple mustaches end with a third brace.
+			if end < len(s) && s[end] == '}' {
+				end = end + 1
+			}
+			word.WriteString(s[i:end])
+			inWord = true
+			i = end - 1
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// runSteps runs the main command and then each step in turn, stopping
+// at the first which fails.  With steps, the result is that of the last
+// step run, holding the results of every step in steps, their total
+// duration and CPU time, and their peak memory.
+func runSteps(cmd *CommandTemplate, run func(*CommandTemplate) map[string]interface{}) map[string]interface{} {
+	r := run(cmd)
+	if len(cmd.Then) == 0 {
+		return r
+	}
+	steps := []interface{}{stepResult(r)}
+	for _, step := range cmd.Then {
+		if jobFailed(r) {
+			break
+		}
+		r = run(step)
+		steps = append(steps, stepResult(r))
+	}
+	final := map[string]interface{}{}
+	for k, v := range r {
+		final[k] = v
+	}
+	var duration int64
+	for _, s := range steps {
+		if d, ok := s.(map[string]interface{})["duration_ms"].(int64); ok {
+			duration = duration + d
+		}
+	}
+	final["duration_ms"] = duration
+	for _, k := range []string{"user_cpu_ms", "sys_cpu_ms"} {
+		var total int64
+		for _, s := range steps {
+			if d, ok := s.(map[string]interface{})[k].(int64); ok {
+				total = total + d
+				final[k] = total
+			}
+		}
+	}
+	var rss int64
+	for _, s := range steps {
+		if m, ok := s.(map[string]interface{})["max_rss_bytes"].(int64); ok {
+			rss = max(rss, m)
+		}
+	}
+	if rss > 0 {
+		final["max_rss_bytes"] = rss
+	}
+	final["steps"] = steps
+	return final
+}
+
+// stepResult is the result of one step, without the input record which
+// the whole result already holds.
+func stepResult(r map[string]interface{}) map[string]interface{} {
+	s := map[string]interface{}{}
+	for k, v := range r {
+		if k != "e" {
+			s[k] = v
+		}
+	}
+	return s
+}
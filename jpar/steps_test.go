@@ -0,0 +1,55 @@
+package jpar
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	cases := []struct {
+		s string
+		want []string
+	}{
+		{"load {{id}}", []string{"load", "{{id}}"}},
+		{"  load   --to 'a b' \"c\"d ", []string{"load", "--to", "a b", "cd"}},
+		{"load {{ id }} {{{ raw }}}x", []string{"load", "{{ id }}", "{{{ raw }}}x"}},
+		{"echo ''", []string{"echo", ""}},
+	}
+	for _, c := range cases {
+		got, err := splitWords(c.s)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitWords(%q): got %q, %v, want %q", c.s, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"echo 'a", "echo {{id"} {
+		if _, err := splitWords(s); err == nil {
+			t.Errorf("splitWords(%q): expected an error", s)
+		}
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	o := &Options{Args: []string{"echo", "{{n}}"}, Then: []string{"sh -c 'exit {{rc}}'", "echo done"}}
+	cmd, err := parseCommandTemplate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(job interface{}) map[string]interface{} {
+		return runSteps(cmd, func(c *CommandTemplate) map[string]interface{} {
+			return runJob(context.Background(), o, c, job, nil)
+		})
+	}
+	r := run(map[string]interface{}{"n": 1, "rc": 0})
+	steps := r["steps"].([]interface{})
+	if r["outcome"] != OUTCOME_SUCCESS || r["stdout"] != "done\n" || len(steps) != 3 {
+		t.Errorf("expected every step to run, got %v", r)
+	}
+	if _, ok := steps[0].(map[string]interface{})["e"]; ok {
+		t.Error("expected steps without the input record")
+	}
+	r = run(map[string]interface{}{"n": 1, "rc": 3})
+	if r["outcome"] != OUTCOME_FAILURE || r["exit_code"] != 3 || len(r["steps"].([]interface{})) != 2 {
+		t.Errorf("expected the failed step to end the job, got %v", r)
+	}
+}
@@ -0,0 +1,55 @@
+package jpar
+
+import (
+	"bytes"
+	"context"
+)
+
+type outputEventsKey struct{}
+
+// withOutputEvents returns a context in which jobs pass each line of
+// their output to emit as it arrives.
+func withOutputEvents(ctx context.Context, emit func(stream string, line string)) context.Context {
+	return context.WithValue(ctx, outputEventsKey{}, emit)
+}
+
+func outputEvents(ctx context.Context) func(stream string, line string) {
+	emit, _ := ctx.Value(outputEventsKey{}).(func(string, string))
+	return emit
+}
+
+// outputEvent is the record written for a line of a job's output.
+func outputEvent(seq int, stream string, line string) map[string]interface{} {
+	return map[string]interface{}{
+		"event": stream,
+		"job": seq,
+		"data": line,
+	}
+}
+
+// lineEvents passes every complete line written to it to emit.
+type lineEvents struct {
+	emit func(string)
+	partial []byte
+}
+
+func (l *lineEvents) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.emit(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits a final line which has no newline.
+func (l *lineEvents) flush() {
+	if len(l.partial) > 0 {
+		l.emit(string(l.partial))
+		l.partial = nil
+	}
+}
@@ -0,0 +1,37 @@
+package jpar
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLineEvents(t *testing.T) {
+	lines := []string{}
+	l := &lineEvents{emit: func(s string) { lines = append(lines, s) }}
+	l.Write([]byte("a\nb"))
+	l.Write([]byte("c\n\nd"))
+	l.flush()
+	want := []string{"a", "bc", "", "d"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestRunJobOutputEvents(t *testing.T) {
+	var mu sync.Mutex
+	events := []string{}
+	ctx := withOutputEvents(context.Background(), func(stream string, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, stream+":"+line)
+	})
+	r := runJob(ctx, &Options{}, parseCmd(t, "sh", "-c", "echo a; echo b >&2"), nil, nil)
+	if r["outcome"] != OUTCOME_SUCCESS {
+		t.Fatalf("unexpected result %v", r)
+	}
+	if len(events) != 2 {
+		t.Errorf("got events %q, want stdout:a and stderr:b", events)
+	}
+}
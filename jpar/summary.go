@@ -0,0 +1,84 @@
+package jpar
+
+import (
+	"sort"
+	"time"
+)
+
+// runSummary accumulates the totals reported by --summary.
+type runSummary struct {
+	start time.Time
+	cpuStart time.Duration
+	read int
+	succeeded int
+	failed int
+	timedOut int
+	cancelled int
+	skipped int
+	durations []int64
+	// queue is set when jobs wait in a queue for workers.
+	queue *queueStats
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{start: time.Now(), cpuStart: childCpuTime()}
+}
+
+// add counts a result.
+func (s *runSummary) add(v interface{}) {
+	r, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	switch {
+	case jobSkipped(r):
+		s.skipped = s.skipped + 1
+	case r["outcome"] == OUTCOME_TIMEOUT:
+		s.timedOut = s.timedOut + 1
+	case jobCancelled(r):
+		s.cancelled = s.cancelled + 1
+	case jobFailed(r):
+		s.failed = s.failed + 1
+	default:
+		s.succeeded = s.succeeded + 1
+	}
+	if d, ok := r["duration_ms"].(int64); ok {
+		s.durations = append(s.durations, d)
+	}
+}
+
+// record returns the summary record written at the end of the run.
+func (s *runSummary) record() map[string]interface{} {
+	sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+	durations := map[string]interface{}{}
+	if len(s.durations) > 0 {
+		durations["p50"] = percentile(s.durations, 50)
+		durations["p90"] = percentile(s.durations, 90)
+		durations["p99"] = percentile(s.durations, 99)
+		durations["max"] = s.durations[len(s.durations)-1]
+	}
+	summary := map[string]interface{}{
+		"read": s.read,
+		"succeeded": s.succeeded,
+		"failed": s.failed,
+		"timed_out": s.timedOut,
+		"cancelled": s.cancelled,
+		"skipped": s.skipped,
+		"wall_ms": time.Since(s.start).Milliseconds(),
+		"cpu_ms": (childCpuTime() - s.cpuStart).Milliseconds(),
+		"duration_ms": durations,
+	}
+	if s.queue != nil {
+		summary["queue"] = s.queue.record()
+	}
+	return map[string]interface{}{"summary": summary}
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []int64, p int) int64 {
+	i := (len(sorted)*p + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return sorted[i-1]
+}
@@ -0,0 +1,34 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	cases := map[int]int64{50: 5, 90: 9, 99: 10, 0: 1}
+	for p, want := range cases {
+		if got := percentile(values, p); got != want {
+			t.Errorf("percentile(%d) = %d, want %d", p, got, want)
+		}
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	s := newRunSummary()
+	s.read = 4
+	s.add(map[string]interface{}{"outcome": OUTCOME_SUCCESS, "returncode": uint32(0), "duration_ms": int64(10)})
+	s.add(map[string]interface{}{"outcome": OUTCOME_FAILURE, "returncode": uint32(256), "duration_ms": int64(20)})
+	s.add(map[string]interface{}{"outcome": OUTCOME_TIMEOUT, "duration_ms": int64(30)})
+	s.add(map[string]interface{}{"outcome": OUTCOME_SKIPPED})
+	r := s.record()["summary"].(map[string]interface{})
+	want := map[string]int{"read": 4, "succeeded": 1, "failed": 1, "timed_out": 1, "skipped": 1}
+	for k, n := range want {
+		if r[k] != n {
+			t.Errorf("%s = %v, want %d", k, r[k], n)
+		}
+	}
+	if d := r["duration_ms"].(map[string]interface{}); d["max"] != int64(30) {
+		t.Errorf("max duration = %v, want 30", d["max"])
+	}
+}
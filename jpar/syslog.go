@@ -0,0 +1,95 @@
+package jpar
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const LOG_RESULTS_SYSLOG string = "syslog"
+const LOG_RESULTS_JOURNALD string = "journald"
+
+// resultLog records the outcome of every job in the system log.
+type resultLog interface {
+	log(seq int, r map[string]interface{}) error
+	Close() error
+}
+
+// openResultLog returns nil when results are not logged.
+func openResultLog(o *Options) (resultLog, error) {
+	switch o.LogResults {
+	case "":
+		return nil, nil
+	case LOG_RESULTS_SYSLOG:
+		return openSyslog()
+	case LOG_RESULTS_JOURNALD:
+		return openJournald()
+	}
+	return nil, fmt.Errorf("unknown result log %s", o.LogResults)
+}
+
+// logResult records a result, leaving out output events.  Results which
+// cannot be logged are reported by the run's logger.
+func logResult(o *Options, l resultLog, x Output) {
+	r, ok := x.Value.(map[string]interface{})
+	if l == nil || !ok || x.Event {
+		return
+	}
+	if err := l.log(x.Seq, r); err != nil {
+		logger(o).Warn("cannot log result", "seq", x.Seq, "error", err.Error())
+	}
+}
+
+// resultField is a field of a result as it is logged.
+type resultField struct {
+	name string
+	value string
+}
+
+// resultFields lists the fields of a result which are logged: its
+// sequence number, key, outcome, exit code, duration, command, error,
+// and tags, which are named tag_NAME.  Output is left out.
+func resultFields(seq int, r map[string]interface{}) []resultField {
+	fields := []resultField{{"seq", strconv.Itoa(seq)}}
+	for _, name := range []string{"key", "outcome", "exit_code", "duration_ms", "command", "error", "reason"} {
+		if v, ok := r[name]; ok && v != nil {
+			fields = append(fields, resultField{name, fieldValue(v)})
+		}
+	}
+	if tags, ok := r["tags"].(map[string]interface{}); ok {
+		names := []string{}
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fields = append(fields, resultField{"tag_" + name, fieldValue(tags[name])})
+		}
+	}
+	return fields
+}
+
+// fieldValue writes strings as they are, and other values as JSON.
+func fieldValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// resultMessage is the text of a log entry for a result, with its
+// fields written as name=value, quoted when needed.
+func resultMessage(fields []resultField) string {
+	parts := []string{"job"}
+	for _, f := range fields {
+		v := f.value
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		parts = append(parts, f.name+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
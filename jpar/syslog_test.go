@@ -0,0 +1,53 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultMessage(t *testing.T) {
+	r := map[string]interface{}{
+		"outcome": OUTCOME_FAILURE, "exit_code": 1, "command": []string{"rm", "a b"}, "error": "exited with status 1",
+		"stdout": "ignored", "tags": map[string]interface{}{"host": "web1", "az": "b"},
+	}
+	want := `job seq=4 outcome=FAILURE exit_code=1 command="[\"rm\",\"a b\"]" error="exited with status 1" tag_az=b tag_host=web1`
+	if got := resultMessage(resultFields(4, r)); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestJournald(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	j, err := dialJournald(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err := j.log(0, map[string]interface{}{"outcome": OUTCOME_FAILURE, "error": "line 1\nline 2", "tags": map[string]interface{}{"dc-1": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buf[:n]
+	for _, want := range []string{"PRIORITY=3\n", "SYSLOG_IDENTIFIER=jpar\n", "JPAR_OUTCOME=FAILURE\n", "JPAR_TAG_DC_1=x\n", "JPAR_SEQ=0\n"} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+	var size bytes.Buffer
+	binary.Write(&size, binary.LittleEndian, uint64(len("line 1\nline 2")))
+	if !bytes.Contains(got, []byte("JPAR_ERROR\n"+size.String()+"line 1\nline 2\n")) {
+		t.Errorf("expected the error as a binary field in %q", got)
+	}
+}
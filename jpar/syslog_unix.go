@@ -0,0 +1,34 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogResults sends results to the local syslog daemon, at the error
+// priority for failed jobs and at the info priority for others.
+type syslogResults struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (resultLog, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "jpar")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to syslog: %s", err)
+	}
+	return &syslogResults{w}, nil
+}
+
+func (s *syslogResults) log(seq int, r map[string]interface{}) error {
+	msg := resultMessage(resultFields(seq, r))
+	if jobFailed(r) {
+		return s.w.Err(msg)
+	}
+	return s.w.Info(msg)
+}
+
+func (s *syslogResults) Close() error {
+	return s.w.Close()
+}
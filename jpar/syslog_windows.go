@@ -0,0 +1,10 @@
+package jpar
+
+import (
+	"errors"
+)
+
+// openSyslog reports that Windows has no syslog.
+func openSyslog() (resultLog, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
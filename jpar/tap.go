@@ -0,0 +1,94 @@
+package jpar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tapOutput is an output which results are written to as Test Anything
+// Protocol lines.  Each result is a test point, with the details of
+// failures in a YAML block, and the plan is written once the results
+// are finished.
+type tapOutput struct {
+	io.Writer
+	count int
+}
+
+// tapDiagnostics are the fields shown for a failed job.
+type tapDiagnostics struct {
+	Outcome string `yaml:"outcome,omitempty"`
+	ExitCode interface{} `yaml:"exit_code,omitempty"`
+	Error string `yaml:"error,omitempty"`
+	DurationMs interface{} `yaml:"duration_ms,omitempty"`
+	Stderr string `yaml:"stderr,omitempty"`
+}
+
+func newTapOutput(w io.Writer) *tapOutput {
+	io.WriteString(w, "TAP version 13\n")
+	return &tapOutput{Writer: w}
+}
+
+func (t *tapOutput) writePoint(v interface{}) {
+	r, ok := v.(map[string]interface{})
+	if !ok || r["outcome"] == nil {
+		// Values which are not results become comments.
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(t.Writer, "# %s\n", b)
+		return
+	}
+	t.count = t.count + 1
+	line := fmt.Sprintf("ok %d", t.count)
+	if jobFailed(r) {
+		line = "not " + line
+	}
+	if name := tapName(r); name != "" {
+		line = line + " - " + name
+	}
+	if jobSkipped(r) {
+		reason, _ := r["reason"].(string)
+		line = strings.TrimSpace(line + " # SKIP " + reason)
+	}
+	io.WriteString(t.Writer, line+"\n")
+	if !jobFailed(r) {
+		return
+	}
+	d := tapDiagnostics{ExitCode: r["exit_code"], DurationMs: r["duration_ms"]}
+	d.Outcome, _ = r["outcome"].(string)
+	d.Error, _ = r["error"].(string)
+	d.Stderr, _ = r["stderr"].(string)
+	var b strings.Builder
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(d); err != nil {
+		return
+	}
+	block := "  ---\n"
+	for _, l := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		block = block + "  " + l + "\n"
+	}
+	io.WriteString(t.Writer, block+"  ...\n")
+}
+
+// finish writes the plan.
+func (t *tapOutput) finish() {
+	fmt.Fprintf(t.Writer, "1..%d\n", t.count)
+}
+
+// tapName describes a test point by the job's key, or its tags, or its
+// command.  Hash marks would start a directive, so they are escaped.
+func tapName(r map[string]interface{}) string {
+	name := ""
+	if key, ok := r["key"].(string); ok && key != "" {
+		name = key
+	} else if tags, ok := r["tags"].(map[string]interface{}); ok && len(tags) > 0 {
+		name = reportName(0, r)
+	} else {
+		name = strings.Join(resultCommand(r), " ")
+	}
+	name = strings.ReplaceAll(name, "\n", " ")
+	return strings.ReplaceAll(name, "#", `\#`)
+}
@@ -0,0 +1,41 @@
+package jpar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTapOutput(t *testing.T) {
+	var b bytes.Buffer
+	o := NewOptions()
+	o.OutputFormat = OUTPUT_FORMAT_TAP
+	w := resultWriter(o, nil, &b)
+	results := []interface{}{
+		map[string]interface{}{"outcome": OUTCOME_SUCCESS, "command": []string{"echo", "#1"}},
+		map[string]interface{}{"outcome": OUTCOME_FAILURE, "key": "b", "exit_code": 2, "error": "exited with status 2", "stderr": "no route\nto host\n"},
+		map[string]interface{}{"outcome": OUTCOME_SKIPPED, "reason": "condition not met"},
+		"text",
+	}
+	for _, r := range results {
+		writeResult(w, o.OutputFormat, r)
+	}
+	w.(*tapOutput).finish()
+	want := `TAP version 13
+ok 1 - echo \#1
+not ok 2 - b
+  ---
+  outcome: FAILURE
+  exit_code: 2
+  error: exited with status 2
+  stderr: |
+    no route
+    to host
+  ...
+ok 3 # SKIP condition not met
+# "text"
+1..3
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,276 @@
+package jpar
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jmyounker/mustache"
+)
+
+// Template helpers are sections such as {{#shq}}{{path}}{{/shq}} which
+// transform the text they enclose.  Before parsing, their tags are
+// replaced with markers which mustache copies to its output like any
+// other text, so the enclosed text is expanded in the right context.
+// The markers are then resolved in the expanded text.
+//
+// String values are marked as well, so that {{#json}} can tell strings
+// from the surrounding text.
+const (
+	MARK = "\x00"
+	MARK_HELPER = MARK + "\x01"
+	MARK_NAME_END = MARK + "\x02"
+	MARK_HELPER_END = MARK + "\x03"
+	MARK_STRING = MARK + "\x04"
+	MARK_STRING_END = MARK + "\x05"
+	MARK_INDEX = MARK + "\x06"
+	MARK_DEFAULT = MARK + "\x07"
+	MARK_DEFAULT_END = MARK + "\x08"
+	MARK_OPEN_BRACE = MARK + "\x0e"
+	MARK_CLOSE_BRACE = MARK + "\x0f"
+)
+
+// A variable may be given a default, as in {{name|default:anonymous}},
+// which is used when it expands to nothing.  The variable is enclosed in
+// default markers, which hold the default until the markers are
+// resolved.
+var defaultTag = regexp.MustCompile(`\{\{(\{|&)?\s*([^{}|!#^/=&\s][^{}|\s]*)\s*\|\s*default:([^{}]*?)\s*\}?\}\}`)
+
+// markDefaults encloses variables with defaults in default markers.
+func markDefaults(src string) string {
+	return defaultTag.ReplaceAllStringFunc(src, func(tag string) string {
+		m := defaultTag.FindStringSubmatch(tag)
+		variable := "{{" + m[1] + m[2] + "}}"
+		if m[1] == "{" {
+			variable = "{{{" + m[2] + "}}}"
+		}
+		return MARK_DEFAULT + m[3] + MARK_NAME_END + variable + MARK_DEFAULT_END
+	})
+}
+
+// Mustache looks names up in objects, but cannot index arrays.  Numeric
+// parts of names, as in {{items.0.id}}, are joined to the part before
+// them with MARK_INDEX, and records are given matching fields which hold
+// the elements of their arrays.  These fields also reach objects with
+// numeric keys.
+var templateTag = regexp.MustCompile(`\{\{\{?[^{}]*\}?\}\}`)
+var indexPart = regexp.MustCompile(`\.(\d+)\b`)
+
+// markIndexes joins the numeric parts of the names in a template.
+func markIndexes(src string) string {
+	return templateTag.ReplaceAllStringFunc(src, func(tag string) string {
+		return indexPart.ReplaceAllString(tag, MARK_INDEX+"$1")
+	})
+}
+
+// addIndexes gives an object a field for each element of its arrays,
+// and for each numeric key of its objects.
+func addIndexes(m templateObject) {
+	for k, v := range m {
+		if strings.Contains(k, MARK) {
+			continue
+		}
+		addIndexed(m, k, v)
+	}
+}
+
+func addIndexed(m templateObject, prefix string, v interface{}) {
+	switch x := v.(type) {
+	case templateArray:
+		for i, e := range x {
+			name := fmt.Sprintf("%s%s%d", prefix, MARK_INDEX, i)
+			m[name] = e
+			addIndexed(m, name, e)
+		}
+	case templateObject:
+		for k, e := range x {
+			if k != "" && strings.Trim(k, "0123456789") == "" {
+				m[prefix+MARK_INDEX+k] = e
+			}
+		}
+	}
+}
+
+var templateHelpers = map[string]func(string) string{
+	"shq": shellQuote,
+	"urlencode": url.QueryEscape,
+	// Strings are encoded as they are resolved, and other values are
+	// already rendered as JSON.
+	"json": func(s string) string { return s },
+}
+
+var helperTag = regexp.MustCompile(`\{\{\s*([#/])\s*(shq|urlencode|json)\s*\}\}`)
+
+// parseMustache parses a template, including any template helpers.
+func parseMustache(src string) (*mustache.Template, error) {
+	open := []string{}
+	var err error
+	marked := helperTag.ReplaceAllStringFunc(src, func(tag string) string {
+		m := helperTag.FindStringSubmatch(tag)
+		if m[1] == "#" {
+			open = append(open, m[2])
+			return MARK_HELPER + m[2] + MARK_NAME_END
+		}
+		if len(open) == 0 || open[len(open)-1] != m[2] {
+			if err == nil {
+				err = fmt.Errorf("unexpected {{/%s}}", m[2])
+			}
+			return tag
+		}
+		open = open[:len(open)-1]
+		return MARK_HELPER_END
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("unclosed {{#%s}}", open[len(open)-1])
+	}
+	return mustache.ParseString(markIndexes(markDefaults(marked)))
+}
+
+// templateObject, templateArray, and templateString hold a record while
+// it is rendered.  Objects and arrays render as JSON rather than with Go
+// formatting, and strings are marked.
+type templateObject map[string]interface{}
+type templateArray []interface{}
+type templateString string
+
+func (o templateObject) String() string {
+	b, _ := json.Marshal(o)
+	return string(b)
+}
+
+// MarshalJSON leaves out the fields added for indexing.
+func (o templateObject) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range o {
+		if !strings.Contains(k, MARK) {
+			m[k] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+func (a templateArray) String() string {
+	b, _ := json.Marshal([]interface{}(a))
+	return string(b)
+}
+
+func (s templateString) String() string {
+	return MARK_STRING + string(s) + MARK_STRING_END
+}
+
+// templateValue converts a decoded JSON value for rendering.
+func templateValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		// Markers in the record itself cannot be told apart from ours.
+		return templateString(strings.Replace(x, MARK, "", -1))
+	case map[string]interface{}:
+		m := make(templateObject, len(x))
+		for k, e := range x {
+			m[k] = templateValue(e)
+		}
+		addIndexes(m)
+		return m
+	case []interface{}:
+		l := make(templateArray, len(x))
+		for i, e := range x {
+			l[i] = templateValue(e)
+		}
+		return l
+	}
+	return v
+}
+
+// resolveMarkers applies the template helpers in expanded text and
+// removes the markers.
+func resolveMarkers(s string) string {
+	if !strings.Contains(s, MARK) {
+		return s
+	}
+	// Each open helper collects its text until its end marker.
+	type frame struct {
+		helper string
+		text strings.Builder
+		// A default frame holds its default, and json is set when it is
+		// within the json helper.
+		isDefault bool
+		fallback string
+		json bool
+	}
+	stack := []*frame{{}}
+	for len(s) > 0 {
+		top := stack[len(stack)-1]
+		i := strings.Index(s, MARK)
+		if i < 0 || i+len(MARK_HELPER) > len(s) {
+			top.text.WriteString(s)
+			break
+		}
+		top.text.WriteString(s[:i])
+		s = s[i:]
+		switch s[:len(MARK_HELPER)] {
+		case MARK_HELPER:
+			s = s[len(MARK_HELPER):]
+			j := strings.Index(s, MARK_NAME_END)
+			stack = append(stack, &frame{helper: s[:j]})
+			s = s[j+len(MARK_NAME_END):]
+		case MARK_DEFAULT:
+			s = s[len(MARK_DEFAULT):]
+			j := strings.Index(s, MARK_NAME_END)
+			inJson := top.helper == "json" || top.json
+			stack = append(stack, &frame{isDefault: true, fallback: s[:j], json: inJson})
+			s = s[j+len(MARK_NAME_END):]
+		case MARK_DEFAULT_END:
+			s = s[len(MARK_DEFAULT_END):]
+			if len(stack) == 1 || !top.isDefault {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			text := top.text.String()
+			if text == "" && top.json {
+				b, _ := json.Marshal(top.fallback)
+				text = string(b)
+			} else if text == "" {
+				text = top.fallback
+			}
+			stack[len(stack)-1].text.WriteString(text)
+		case MARK_OPEN_BRACE:
+			s = s[len(MARK_OPEN_BRACE):]
+			top.text.WriteString("{")
+		case MARK_CLOSE_BRACE:
+			s = s[len(MARK_CLOSE_BRACE):]
+			top.text.WriteString("}")
+		case MARK_HELPER_END:
+			s = s[len(MARK_HELPER_END):]
+			if len(stack) == 1 {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].text.WriteString(templateHelpers[top.helper](top.text.String()))
+		case MARK_STRING:
+			s = s[len(MARK_STRING):]
+			j := strings.Index(s, MARK_STRING_END)
+			if top.helper == "json" || top.json {
+				b, _ := json.Marshal(s[:j])
+				top.text.Write(b)
+			} else {
+				top.text.WriteString(s[:j])
+			}
+			s = s[j+len(MARK_STRING_END):]
+		default:
+			top.text.WriteString(MARK)
+			s = s[len(MARK):]
+		}
+	}
+	// Helpers are left open when their end is skipped by a section.
+	for len(stack) > 1 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		stack[len(stack)-1].text.WriteString(top.text.String())
+	}
+	return stack[0].text.String()
+}
@@ -0,0 +1,78 @@
+package jpar
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTemplateHelpers(t *testing.T) {
+	var job interface{}
+	json.Unmarshal([]byte(`{"path":"it's here","q":"a b&c","n":3,"payload":{"k":["v"]},"items":["x","y"]}`), &job)
+	cases := map[string]string{
+		"{{path}}": "it's here",
+		"{{#shq}}{{path}}{{/shq}}": `'it'\''s here'`,
+		"{{#urlencode}}{{q}}{{/urlencode}}": "a+b%26c",
+		"{{payload}}": `{"k":["v"]}`,
+		"{{#json}}{{payload}}{{/json}}": `{"k":["v"]}`,
+		"{{#json}}{{path}}{{/json}}": `"it's here"`,
+		`{{#json}}{"p": {{path}}, "n": {{n}}}{{/json}}`: `{"p": "it's here", "n": 3}`,
+		"{{#items}}{{#shq}}{{.}}{{/shq}} {{/items}}": "'x' 'y' ",
+		"{{#shq}}{{#json}}{{path}}{{/json}}{{/shq}}": `'"it'\''s here"'`,
+	}
+	for src, want := range cases {
+		if got := render(parseTemplate(t, src), job, nil); got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestParseMustacheUnbalanced(t *testing.T) {
+	for _, src := range []string{"{{#shq}}{{x}}", "{{x}}{{/json}}", "{{#shq}}{{#json}}{{/shq}}{{/json}}"} {
+		if _, err := parseMustache(src); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestTemplateIndexes(t *testing.T) {
+	var job interface{}
+	json.Unmarshal([]byte(`{"user":{"address":{"city":"Oslo"}},"items":[{"id":"a","tags":["x","y"]},{"id":"b"}],"grid":[[1,2],[3,4]],"codes":{"200":"ok"}}`), &job)
+	cases := map[string]string{
+		"{{user.address.city}}": "Oslo",
+		"{{items.0.id}}": "a",
+		"{{ items.1.id }}": "b",
+		"{{items.0.tags.1}}": "y",
+		"{{grid.1.0}}": "3",
+		"{{codes.200}}": "ok",
+		"{{items.5.id}}": "",
+		"{{#items.0}}{{id}}{{/items.0}}": "a",
+		"{{#items}}{{tags.0}}{{/items}}": "x",
+		"{{items.0}}": `{"id":"a","tags":["x","y"]}`,
+		"{{user}}": `{"address":{"city":"Oslo"}}`,
+	}
+	for src, want := range cases {
+		if got := render(parseTemplate(t, src), job, nil); got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestTemplateDefaults(t *testing.T) {
+	var job interface{}
+	json.Unmarshal([]byte(`{"name":"ada","empty":"","user":{"id":7}}`), &job)
+	cases := map[string]string{
+		"{{name|default:anonymous}}": "ada",
+		"{{nobody|default:anonymous}}": "anonymous",
+		"{{ empty | default:none }}": "none",
+		"{{user.id|default:0}}": "7",
+		"{{user.name|default:guest user}}": "guest user",
+		"{{missing|default:}}": "",
+		"{{#json}}{{nobody|default:x}}{{/json}}": `"x"`,
+		"{{#json}}{{name|default:x}}{{/json}}": `"ada"`,
+	}
+	for src, want := range cases {
+		if got := render(parseTemplate(t, src), job, nil); got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TUI_REFRESH is how often the dashboard is redrawn.
+const TUI_REFRESH = 500 * time.Millisecond
+
+// TUI_FAILURES is how many recent failures the dashboard lists.
+const TUI_FAILURES = 5
+
+// TUI_RATE_WINDOW is how many seconds the throughput is averaged over.
+const TUI_RATE_WINDOW = 10
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboard is the live view of a run drawn by --tui.  Keys pause and
+// resume the run, change its parallelism, and kill the selected job.
+type dashboard struct {
+	o *Options
+	ctl *runControl
+	queue *queueStats
+	quit func()
+	start time.Time
+	mu sync.Mutex
+	completed int
+	failed int
+	cancelled int
+	// counts holds the number of jobs which finished in each second of
+	// the run.
+	counts []int
+	// failures describes the most recent failed jobs, oldest first.
+	failures []string
+	// selected is the position of the selected job among those running.
+	selected int
+}
+
+func newDashboard(o *Options, ctl *runControl, queue *queueStats, quit func()) *dashboard {
+	return &dashboard{o: o, ctl: ctl, queue: queue, quit: quit, start: time.Now()}
+}
+
+// add counts a result.
+func (d *dashboard) add(v interface{}, now time.Time) {
+	r, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completed = d.completed + 1
+	second := int(now.Sub(d.start) / time.Second)
+	for len(d.counts) <= second {
+		d.counts = append(d.counts, 0)
+	}
+	d.counts[second] = d.counts[second] + 1
+	switch {
+	case jobCancelled(r):
+		d.cancelled = d.cancelled + 1
+	case jobFailed(r):
+		d.failed = d.failed + 1
+		d.failures = append(d.failures, failureLine(r))
+		if len(d.failures) > TUI_FAILURES {
+			d.failures = d.failures[1:]
+		}
+	}
+}
+
+// failureLine describes a failed job.
+func failureLine(r map[string]interface{}) string {
+	what := fmt.Sprint(r["outcome"])
+	if key, ok := r["key"].(string); ok {
+		what = key + " " + what
+	}
+	if e, ok := r["error"].(string); ok {
+		return what + ": " + e
+	}
+	if code, ok := r["exit_code"]; ok {
+		return fmt.Sprintf("%s: exit %v", what, code)
+	}
+	return what
+}
+
+// key acts on a key pressed by the user.
+func (d *dashboard) key(k string) {
+	lg := logger(d.o)
+	switch k {
+	case "pause":
+		if d.ctl.gate.paused() {
+			d.ctl.gate.resume()
+		} else {
+			d.ctl.gate.pause()
+		}
+	case "more", "fewer":
+		delta := 1
+		if k == "fewer" {
+			delta = -1
+		}
+		if _, err := d.ctl.adjust(delta); err != nil {
+			lg.Warn("cannot change parallelism", "error", err.Error())
+		}
+	case "up", "down":
+		d.mu.Lock()
+		if k == "up" && d.selected > 0 {
+			d.selected = d.selected - 1
+		}
+		if k == "down" {
+			d.selected = d.selected + 1
+		}
+		d.mu.Unlock()
+	case "kill":
+		jobs := d.ctl.runningJobs()
+		d.mu.Lock()
+		selected := d.selected
+		d.mu.Unlock()
+		if selected < len(jobs) {
+			d.ctl.kill(jobs[selected].Job.Seq)
+		}
+	case "quit":
+		d.quit()
+	}
+}
+
+// parseKeys names the keys in what was read from the terminal.
+func parseKeys(b []byte) []string {
+	keys := []string{}
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case 'p', ' ':
+			keys = append(keys, "pause")
+		case '+', '=':
+			keys = append(keys, "more")
+		case '-':
+			keys = append(keys, "fewer")
+		case 'k':
+			keys = append(keys, "kill")
+		case 'q':
+			keys = append(keys, "quit")
+		case 0x1b:
+			// Arrow keys are sent as ESC [ A and ESC [ B.
+			if i+2 < len(b) && b[i+1] == '[' {
+				switch b[i+2] {
+				case 'A':
+					keys = append(keys, "up")
+				case 'B':
+					keys = append(keys, "down")
+				}
+				i = i + 2
+			}
+		}
+	}
+	return keys
+}
+
+// render draws the dashboard as it is at now, fitted to a terminal of
+// width columns and height lines.
+func (d *dashboard) render(now time.Time, width int, height int) string {
+	running := d.ctl.runningJobs()
+	status := d.ctl.status()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.selected >= len(running) && len(running) > 0 {
+		d.selected = len(running) - 1
+	}
+	lines := []string{}
+	state := "running"
+	if status["paused"] == true {
+		state = "PAUSED"
+	}
+	elapsed := now.Sub(d.start).Truncate(time.Second)
+	lines = append(lines, fmt.Sprintf("jpar  %s  %s  parallelism %d", state, elapsed, status["parallelism"]))
+	counts := fmt.Sprintf("completed %d  failed %d  cancelled %d  running %d", d.completed, d.failed, d.cancelled, len(running))
+	if d.queue != nil {
+		counts = counts + fmt.Sprintf("  queued %d", d.queue.current())
+	}
+	lines = append(lines, counts)
+	lines = append(lines, "")
+	rate, graph := d.throughput(now, width)
+	lines = append(lines, fmt.Sprintf("throughput %.1f/s", rate))
+	lines = append(lines, graph)
+	lines = append(lines, "")
+	failures := []string{"recent failures"}
+	for i := len(d.failures) - 1; i >= 0; i-- {
+		failures = append(failures, "  "+d.failures[i])
+	}
+	failures = append(failures, "")
+	help := "p pause/resume  +/- parallelism  up/down select  k kill  q quit"
+	// Running jobs get the lines left over.
+	room := height - len(lines) - len(failures) - 2
+	lines = append(lines, "running jobs")
+	first := 0
+	if d.selected >= room && room > 0 {
+		first = d.selected - room + 1
+	}
+	for i := first; i < len(running) && i-first < room; i++ {
+		j := running[i]
+		mark := "  "
+		if i == d.selected {
+			mark = "> "
+		}
+		age := now.Sub(j.Started).Truncate(100 * time.Millisecond)
+		lines = append(lines, fmt.Sprintf("%s%-6d %8s  %s", mark, j.Job.Seq, age, d.label(j.Job)))
+	}
+	for i := len(running); i-first < room; i++ {
+		lines = append(lines, "")
+	}
+	lines = append(lines, failures...)
+	lines = append(lines, help)
+	for i, line := range lines {
+		lines[i] = fitLine(line, width)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// throughput returns the jobs finished per second, averaged over the
+// last few seconds, and a graph of the jobs finished in each second.
+func (d *dashboard) throughput(now time.Time, width int) (float64, string) {
+	second := int(now.Sub(d.start) / time.Second)
+	count := func(s int) int {
+		if s < 0 || s >= len(d.counts) {
+			return 0
+		}
+		return d.counts[s]
+	}
+	recent := 0
+	window := TUI_RATE_WINDOW
+	if second+1 < window {
+		window = second + 1
+	}
+	for s := second - window + 1; s <= second; s++ {
+		recent = recent + count(s)
+	}
+	rate := float64(recent) / float64(window)
+	// The current second is still counting, so the graph ends before it.
+	values := []int{}
+	most := 0
+	for s := second - width; s < second; s++ {
+		if s < 0 {
+			continue
+		}
+		values = append(values, count(s))
+		if count(s) > most {
+			most = count(s)
+		}
+	}
+	graph := []rune{}
+	for _, v := range values {
+		if most == 0 {
+			graph = append(graph, sparks[0])
+			continue
+		}
+		graph = append(graph, sparks[v*(len(sparks)-1)/most])
+	}
+	return rate, string(graph)
+}
+
+// label describes a running job by its key, or else by its record, with
+// sensitive values masked.
+func (d *dashboard) label(job Job) string {
+	if job.Key != "" {
+		return d.o.redact.string(job.Key)
+	}
+	b, err := json.Marshal(job.Value)
+	if err != nil {
+		return ""
+	}
+	return d.o.redact.forRecord(d.o.RedactFields, job.Value).string(string(b))
+}
+
+// fitLine cuts a line to width columns and clears the rest of the line.
+func fitLine(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	return string(runes) + "\x1b[K"
+}
+
+// openDashboard draws the dashboard on the controlling terminal until the
+// returned function is called, reading keys as they are pressed.
+func openDashboard(lg *slog.Logger, d *dashboard) (func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the terminal for --tui: %s", err)
+	}
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("cannot set up the terminal for --tui: %s", err)
+	}
+	// Keys are read as they are pressed, without echoing them, while
+	// Ctrl-C still interrupts jpar.
+	if _, err := stty(tty, "-icanon", "-echo", "min", "1"); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("cannot set up the terminal for --tui: %s", err)
+	}
+	// The dashboard is drawn on the alternate screen, without a cursor.
+	tty.WriteString("\x1b[?1049h\x1b[?25l")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(TUI_REFRESH)
+		defer ticker.Stop()
+		for {
+			width, height := terminalSize(tty)
+			tty.WriteString("\x1b[H" + d.render(time.Now(), width, height) + "\x1b[J")
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, k := range parseKeys(buf[:n]) {
+				d.key(k)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		tty.WriteString("\x1b[?25h\x1b[?1049l")
+		if _, err := stty(tty, strings.TrimSpace(saved)); err != nil {
+			lg.Warn("cannot restore the terminal", "error", err.Error())
+		}
+		tty.Close()
+	}, nil
+}
+
+// stty runs stty on the terminal, returning its output.
+func stty(tty *os.File, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	cmd.Stdout = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// terminalSize returns the number of columns and lines of the terminal,
+// or 80 by 24 if they cannot be found.
+func terminalSize(tty *os.File) (int, int) {
+	out, err := stty(tty, "size")
+	if err != nil {
+		return 80, 24
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 80, 24
+	}
+	lines, err1 := strconv.Atoi(fields[0])
+	columns, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || lines <= 0 || columns <= 0 {
+		return 80, 24
+	}
+	return columns, lines
+}
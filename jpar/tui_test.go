@@ -0,0 +1,75 @@
+package jpar
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("p+-\x1b[A\x1b[Bkqx"))
+	want := []string{"pause", "more", "fewer", "up", "down", "kill", "quit"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	o := NewOptions()
+	ctl := newRunControl(2)
+	d := newDashboard(o, ctl, nil, func() {})
+	ctl.track(context.Background(), Job{Seq: 4, Key: "four"})
+	d.add(map[string]interface{}{"outcome": OUTCOME_SUCCESS}, d.start)
+	d.add(map[string]interface{}{"outcome": OUTCOME_FAILURE, "key": "three", "error": "exited with status 1"}, d.start.Add(time.Second))
+	d.add(map[string]interface{}{"outcome": OUTCOME_CANCELLED}, d.start.Add(time.Second))
+	screen := d.render(d.start.Add(2*time.Second), 80, 24)
+	for _, want := range []string{
+		"jpar  running  2s  parallelism 2",
+		"completed 3  failed 1  cancelled 1  running 1",
+		"throughput 1.0/s",
+		"▄█",
+		"> 4 ",
+		"four",
+		"three FAILURE: exited with status 1",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("expected the dashboard to show %q, got\n%s", want, screen)
+		}
+	}
+	if lines := strings.Count(screen, "\n") + 1; lines != 24 {
+		t.Errorf("expected the dashboard to fill 24 lines, got %d", lines)
+	}
+}
+
+func TestDashboardKeys(t *testing.T) {
+	o := NewOptions()
+	ctl := newRunControl(2)
+	quit := false
+	d := newDashboard(o, ctl, nil, func() { quit = true })
+	d.key("pause")
+	if !ctl.gate.paused() {
+		t.Error("expected p to pause the run")
+	}
+	d.key("pause")
+	if ctl.gate.paused() {
+		t.Error("expected p to resume the run")
+	}
+	d.key("more")
+	if ctl.status()["parallelism"] != 3 {
+		t.Errorf("expected + to add a worker, got %v", ctl.status())
+	}
+	ctx, untrack := ctl.track(context.Background(), Job{Seq: 1})
+	ctl.track(context.Background(), Job{Seq: 2})
+	d.key("down")
+	d.key("up")
+	d.key("kill")
+	if ctx.Err() == nil || !untrack() {
+		t.Error("expected k to kill the selected job")
+	}
+	d.key("quit")
+	if !quit {
+		t.Error("expected q to quit")
+	}
+}
@@ -0,0 +1,32 @@
+package jpar
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// jobUser returns the user a job runs as, by name or uid.  A user field
+// in the input record takes precedence over the global user.
+func jobUser(o *Options, job interface{}) (string, error) {
+	if o.UserField == "" {
+		return o.User, nil
+	}
+	m, ok := job.(map[string]interface{})
+	if !ok {
+		return o.User, nil
+	}
+	v, ok := m[o.UserField]
+	if !ok {
+		return o.User, nil
+	}
+	switch u := v.(type) {
+	case float64:
+		if u < 0 || u != float64(int64(u)) {
+			return "", fmt.Errorf("invalid uid in field %s: %v", o.UserField, u)
+		}
+		return strconv.FormatInt(int64(u), 10), nil
+	case string:
+		return u, nil
+	}
+	return "", fmt.Errorf("user field %s must be a user name or uid", o.UserField)
+}
@@ -0,0 +1,29 @@
+package jpar
+
+import (
+	"testing"
+)
+
+func TestJobUser(t *testing.T) {
+	o := &Options{User: "nobody", UserField: "owner"}
+	cases := []struct {
+		job interface{}
+		want string
+	}{
+		{map[string]interface{}{"owner": "alice"}, "alice"},
+		{map[string]interface{}{"owner": 1001.0}, "1001"},
+		{map[string]interface{}{}, "nobody"},
+		{"not a map", "nobody"},
+	}
+	for _, c := range cases {
+		got, err := jobUser(o, c.job)
+		if err != nil || got != c.want {
+			t.Errorf("jobUser(%v) = %s, %v, want %s", c.job, got, err, c.want)
+		}
+	}
+	for _, v := range []interface{}{true, -1.0, 1.5} {
+		if _, err := jobUser(o, map[string]interface{}{"owner": v}); err == nil {
+			t.Errorf("expected an error for user %v", v)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAs sets the credentials a command runs with when a user or group
+// is given.  Changing them requires jpar to run as root.
+func runAs(attr *syscall.SysProcAttr, name, group string) (*syscall.SysProcAttr, error) {
+	if name == "" && group == "" {
+		return attr, nil
+	}
+	cred, err := lookupCredential(name, group)
+	if err != nil {
+		return nil, err
+	}
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Credential = cred
+	return attr, nil
+}
+
+// lookupCredential finds the uid, gid, and supplementary groups of a
+// user, each given by name or number.  The group defaults to the user's
+// primary group.  A uid with no passwd entry needs the group as well.
+func lookupCredential(name, group string) (*syscall.Credential, error) {
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	// Only root can change the supplementary groups.
+	cred.NoSetGroups = os.Geteuid() != 0
+	if name != "" {
+		u, err := lookupUser(name)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			cred.Uid, _ = parseId(u.Uid)
+			cred.Gid, _ = parseId(u.Gid)
+			gids, _ := u.GroupIds()
+			cred.Groups = []uint32{}
+			for _, g := range gids {
+				if id, ok := parseId(g); ok {
+					cred.Groups = append(cred.Groups, id)
+				}
+			}
+		} else if group == "" {
+			return nil, fmt.Errorf("uid %s has no passwd entry, so a gid is required", name)
+		} else {
+			cred.Uid, _ = parseId(name)
+			cred.Groups = []uint32{}
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			g, err = user.LookupGroupId(group)
+		}
+		if err == nil {
+			cred.Gid, _ = parseId(g.Gid)
+		} else if id, ok := parseId(group); ok {
+			cred.Gid = id
+		} else {
+			return nil, fmt.Errorf("unknown group %s", group)
+		}
+	}
+	return cred, nil
+}
+
+// lookupUser finds a user by name or uid.  It returns nil for a uid
+// with no passwd entry.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, ok := parseId(name); !ok {
+		return nil, fmt.Errorf("unknown user %s", name)
+	}
+	u, err = user.LookupId(name)
+	if err != nil {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func parseId(s string) (uint32, bool) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err == nil
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package jpar
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestLookupCredential(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, name := range []string{me.Username, me.Uid} {
+		cred, err := lookupCredential(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strconv.Itoa(int(cred.Uid)) != me.Uid || strconv.Itoa(int(cred.Gid)) != me.Gid {
+			t.Errorf("expected %s:%s for %s, got %d:%d", me.Uid, me.Gid, name, cred.Uid, cred.Gid)
+		}
+	}
+	cred, err := lookupCredential(me.Username, "4242")
+	if err != nil || cred.Gid != 4242 {
+		t.Errorf("expected gid 4242, got %v: %v", cred, err)
+	}
+	if _, err := lookupCredential("no-such-user-jpar", ""); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+	if _, err := lookupCredential("4000000", ""); err == nil {
+		t.Error("expected an error for an unknown uid without a gid")
+	}
+	cred, err = lookupCredential("4000000", "4000000")
+	if err != nil || cred.Uid != 4000000 || cred.Gid != 4000000 || len(cred.Groups) != 0 {
+		t.Errorf("expected uid and gid 4000000 with no groups, got %v: %v", cred, err)
+	}
+}
+
+func TestRunJobAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running as another user requires root")
+	}
+	o := &Options{UserField: "uid", Group: "65534"}
+	r := runJob(context.Background(), o, parseCmd(t, "id", "-u"), map[string]interface{}{"uid": 65534.0}, nil)
+	if r["stdout"] != "65534\n" || r["user"] != "65534" {
+		t.Errorf("expected the command to run as uid 65534, got %v", r)
+	}
+}
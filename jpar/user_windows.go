@@ -0,0 +1,15 @@
+package jpar
+
+import (
+	"errors"
+	"syscall"
+)
+
+// runAs fails when a user or group is given, since commands cannot be
+// run as another user on Windows.
+func runAs(attr *syscall.SysProcAttr, name, group string) (*syscall.SysProcAttr, error) {
+	if name == "" && group == "" {
+		return attr, nil
+	}
+	return nil, errors.New("commands cannot be run as another user on Windows")
+}
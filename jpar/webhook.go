@@ -0,0 +1,114 @@
+package jpar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const WEBHOOK_ON_RESULT string = "result"
+const WEBHOOK_ON_FAILURE string = "failure"
+const WEBHOOK_ON_SUMMARY string = "summary"
+
+const DEFAULT_WEBHOOK_RETRIES = 3
+const DEFAULT_WEBHOOK_CONCURRENCY = 4
+const WEBHOOK_TIMEOUT = 30 * time.Second
+
+// webhook posts records as JSON to a URL.  At most a fixed number of
+// requests are made at once, and failed requests are retried with
+// backoff.
+type webhook struct {
+	url string
+	on string
+	retries int
+	delay time.Duration
+	slots chan struct{}
+	pending sync.WaitGroup
+	client *http.Client
+	lg *slog.Logger
+}
+
+// newWebhook returns nil when no webhook is configured.
+func newWebhook(o *Options) *webhook {
+	if o.Webhook == "" {
+		return nil
+	}
+	return &webhook{
+		url: o.Webhook,
+		on: o.WebhookOn,
+		retries: o.WebhookRetries,
+		delay: DEFAULT_RETRY_DELAY,
+		slots: make(chan struct{}, o.WebhookConcurrency),
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		lg: logger(o),
+	}
+}
+
+// result posts a result when results of its kind are wanted.
+func (h *webhook) result(v interface{}) {
+	if h == nil {
+		return
+	}
+	if h.on == WEBHOOK_ON_RESULT || (h.on == WEBHOOK_ON_FAILURE && jobFailed(v)) {
+		h.send(v)
+	}
+}
+
+// summary posts the summary when it is wanted.
+func (h *webhook) summary(v interface{}) {
+	if h != nil && h.on == WEBHOOK_ON_SUMMARY {
+		h.send(v)
+	}
+}
+
+// send posts a record in the background, waiting while the most
+// requests allowed are being made.
+func (h *webhook) send(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		h.lg.Warn("cannot encode webhook record", "error", err.Error())
+		return
+	}
+	h.slots <- struct{}{}
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		defer func() { <-h.slots }()
+		delay := h.delay
+		for attempt := 0; ; attempt++ {
+			err := h.post(body)
+			if err == nil {
+				return
+			}
+			if attempt >= h.retries {
+				h.lg.Warn("webhook failed", "url", h.url, "attempts", attempt+1, "error", err.Error())
+				return
+			}
+			time.Sleep(delay)
+			delay = delay * 2
+		}
+	}()
+}
+
+func (h *webhook) post(body []byte) error {
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// wait blocks until every record has been posted or given up on.
+func (h *webhook) wait() {
+	if h != nil {
+		h.pending.Wait()
+	}
+}
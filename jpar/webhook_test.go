@@ -0,0 +1,56 @@
+package jpar
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	posted := []map[string]interface{}{}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = calls + 1
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var v map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&v)
+		posted = append(posted, v)
+	}))
+	defer srv.Close()
+	tests := []struct {
+		on string
+		want int
+	}{
+		{WEBHOOK_ON_RESULT, 2},
+		{WEBHOOK_ON_FAILURE, 1},
+		{WEBHOOK_ON_SUMMARY, 1},
+	}
+	for _, tc := range tests {
+		posted = nil
+		calls = 0
+		o := NewOptions()
+		o.Webhook = srv.URL
+		o.WebhookOn = tc.on
+		h := newWebhook(o)
+		h.delay = time.Millisecond
+		h.result(map[string]interface{}{"outcome": OUTCOME_SUCCESS})
+		h.result(map[string]interface{}{"outcome": OUTCOME_FAILURE})
+		h.summary(map[string]interface{}{"summary": map[string]interface{}{}})
+		h.wait()
+		if len(posted) != tc.want || calls != tc.want+1 {
+			t.Errorf("%s: expected %d posts after one retry, got %v in %d calls", tc.on, tc.want, posted, calls)
+		}
+	}
+	var h *webhook
+	h.result(map[string]interface{}{})
+	h.wait()
+}
@@ -1,53 +1,102 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
-
-	"github.com/jmyounker/mustache"
-	"os/exec"
-	"io/ioutil"
+	"strings"
 	"syscall"
-	"errors"
+	"time"
+
+	"github.com/jmyounker/jpar/jpar"
 )
 
 var version string
-var Debug bool = false
-
-const OUTCOME_SUCCESS string = "SUCCESS"
-const OUTCOME_FAILURE string = "FAILURE"
-const OUTCOME_TIMEOUT string = "TIMEOUT"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == jpar.SANDBOX_INIT {
+		// jpar runs itself this way to start sandboxed commands.
+		jpar.SandboxInit(os.Args[2:])
+	}
 	err := NewApp().Run(os.Args)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		var exitErr *jpar.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }
 
+// EXIT_INTERRUPTED is the exit status after a graceful shutdown.
+const EXIT_INTERRUPTED = 130
+
+// SUBCOMMANDS are the words which, given before any option, change what
+// jpar does instead of running the command.
+var SUBCOMMANDS = []string{"check", "plan", "serve", "replay"}
+
 type App struct {
 	Prog string
-	Parallelism int
-	Args []string
+	// Check validates the templates instead of running anything.
+	Check bool
+	// Plan reports what a run would do instead of running anything.
+	Plan bool
+	// Failures is where failed jobs are written, if anywhere.
+	Failures string
+	// LogLevel enables logging to stderr at the given level.
+	LogLevel string
+	// Serve accepts records from connections to Listen instead of
+	// reading them from the input.
+	Serve bool
+	Listen string
+	// Grpc is where the gRPC API is served.
+	Grpc string
+	// Output is where results are written, stdout when empty.
+	Output string
+	*jpar.Options
 }
 
-const DEFAULT_PARALLELISM = 8
-
 func NewApp() *App{
 	return &App{
-		Parallelism: DEFAULT_PARALLELISM,
+		Options: jpar.NewOptions(),
 	}
 }
 
 func (a *App)Run(argv []string) error {
+	argv, command, err := withConfig(argv)
+	if err != nil {
+		return err
+	}
 	args := []string{}
 	a.Prog = argv[0]
 	i := 1
+	if len(argv) > 1 && argv[1] == "check" {
+		a.Check = true
+		i = 2
+	}
+	if len(argv) > 1 && argv[1] == "plan" {
+		a.Plan = true
+		i = 2
+	}
+	if len(argv) > 1 && argv[1] == "serve" {
+		a.Serve = true
+		i = 2
+	}
+	if len(argv) > 1 && argv[1] == "replay" {
+		a.Replay = true
+		i = 2
+	}
+	// Options end at the first word which is not one, so the command's
+	// own flags are passed to it untouched.
+options:
 	for i < len(argv) {
 		x := argv[i]
 		switch x {
@@ -59,251 +108,940 @@ func (a *App)Run(argv []string) error {
 			}
 			a.Parallelism = p
 			i = i + 1
+		case "-t", "--timeout":
+			i = i + 1
+			t, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Timeout = t
+			i = i + 1
+		case "--timeout-field":
+			i = i + 1
+			a.TimeoutField = argv[i]
+			i = i + 1
+		case "--inactivity-timeout":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.InactivityTimeout = d
+			i = i + 1
+		case "--input":
+			i = i + 1
+			a.Inputs = append(a.Inputs, argv[i])
+			i = i + 1
+		case "--input-format":
+			i = i + 1
+			a.InputFormat = argv[i]
+			i = i + 1
+		case "--head":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Head = n
+			i = i + 1
+		case "--skip":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Skip = n
+			i = i + 1
+		case "--sample":
+			i = i + 1
+			f, err := jpar.ParseSample(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Sample = f
+			i = i + 1
+		case "--seed":
+			i = i + 1
+			n, err := strconv.ParseInt(argv[i], 10, 64)
+			if err != nil {
+				return err
+			}
+			a.Seed = n
+			i = i + 1
+		case "--strict-input":
+			i = i + 1
+			a.StrictInput = true
+		case "-0", "--null":
+			i = i + 1
+			a.InputFormat = jpar.INPUT_FORMAT_LINES
+			a.NullSeparated = true
+		case "--line-key":
+			i = i + 1
+			a.LineKey = argv[i]
+			i = i + 1
+		case "--delimiter":
+			i = i + 1
+			d := []rune(argv[i])
+			if len(d) != 1 {
+				return fmt.Errorf("delimiter %s must be a single character", argv[i])
+			}
+			a.Delimiter = d[0]
+			i = i + 1
+		case "--no-header":
+			i = i + 1
+			a.NoHeader = true
+		case "--quoting":
+			i = i + 1
+			a.Quoting = argv[i]
+			i = i + 1
+		case "--output-format":
+			i = i + 1
+			a.OutputFormat = argv[i]
+			i = i + 1
+		case "--output-fields":
+			i = i + 1
+			a.OutputFields = strings.Split(argv[i], ",")
+			i = i + 1
+		case "--echo-input":
+			i = i + 1
+			a.EchoInput = argv[i]
+			i = i + 1
+		case "--echo-fields":
+			i = i + 1
+			a.EchoFields = strings.Split(argv[i], ",")
+			i = i + 1
+		case "--rename":
+			i = i + 1
+			a.Rename = append(a.Rename, argv[i])
+			i = i + 1
+		case "--timings":
+			i = i + 1
+			a.Timings = true
+		case "-r", "--retries":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Retries = n
+			i = i + 1
+		case "--retry-delay":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.RetryDelay = d
+			i = i + 1
+		case "--retry-backoff":
+			i = i + 1
+			f, err := strconv.ParseFloat(argv[i], 64)
+			if err != nil {
+				return err
+			}
+			a.RetryBackoff = f
+			i = i + 1
+		case "--repeat":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Repeat = n
+			i = i + 1
+		case "--requeue-failures":
+			i = i + 1
+			a.RequeueFailures = true
+		case "--attempt-history":
+			i = i + 1
+			a.AttemptHistory = true
+		case "--then":
+			i = i + 1
+			a.Then = append(a.Then, argv[i])
+			i = i + 1
+		case "--stdin-json":
+			i = i + 1
+			a.StdinJson = true
+		case "--stdin-field":
+			i = i + 1
+			a.StdinField = argv[i]
+			i = i + 1
+		case "--stdin-file":
+			i = i + 1
+			a.StdinFile = argv[i]
+			i = i + 1
+		case "-k", "--keep-order":
+			i = i + 1
+			a.KeepOrder = true
+		case "--reorder-buffer":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.ReorderBuffer = n
+			i = i + 1
+		case "--grace-period":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.GracePeriod = d
+			i = i + 1
+		case "--kill-signal":
+			i = i + 1
+			sig, err := jpar.ParseSignal(argv[i])
+			if err != nil {
+				return err
+			}
+			a.KillSignal = sig
+			i = i + 1
+		case "--kill-after":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.KillAfter = d
+			i = i + 1
+		case "--no-pgroup":
+			i = i + 1
+			a.NoProcessGroup = true
+		case "--nice":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Nice = n
+			i = i + 1
+		case "--ionice":
+			i = i + 1
+			a.IoNice = argv[i]
+			i = i + 1
+		case "--cgroup-mem":
+			i = i + 1
+			a.CgroupMem = argv[i]
+			i = i + 1
+		case "--cgroup-cpu":
+			i = i + 1
+			a.CgroupCpu = argv[i]
+			i = i + 1
+		case "--user":
+			i = i + 1
+			a.User = argv[i]
+			i = i + 1
+		case "--uid":
+			i = i + 1
+			if _, err := strconv.ParseUint(argv[i], 10, 32); err != nil {
+				return fmt.Errorf("uid %s must be a number", argv[i])
+			}
+			a.User = argv[i]
+			i = i + 1
+		case "--gid":
+			i = i + 1
+			if _, err := strconv.ParseUint(argv[i], 10, 32); err != nil {
+				return fmt.Errorf("gid %s must be a number", argv[i])
+			}
+			a.Group = argv[i]
+			i = i + 1
+		case "--user-field":
+			i = i + 1
+			a.UserField = argv[i]
+			i = i + 1
+		case "--sandbox":
+			i = i + 1
+			a.Sandbox = argv[i]
+			i = i + 1
+		case "--sandbox-bind":
+			i = i + 1
+			a.SandboxBinds = append(a.SandboxBinds, argv[i])
+			i = i + 1
+		case "--secret":
+			i = i + 1
+			a.Secrets = append(a.Secrets, argv[i])
+			i = i + 1
+		case "--redact":
+			i = i + 1
+			a.Redact = append(a.Redact, argv[i])
+			i = i + 1
+		case "--redact-field":
+			i = i + 1
+			a.RedactFields = append(a.RedactFields, argv[i])
+			i = i + 1
+		case "--allow-cmd":
+			i = i + 1
+			for _, c := range strings.Split(argv[i], ",") {
+				if c != "" {
+					a.AllowCmds = append(a.AllowCmds, c)
+				}
+			}
+			i = i + 1
+		case "--coprocess":
+			i = i + 1
+			a.Coprocess = true
+		case "--output":
+			i = i + 1
+			a.Output = argv[i]
+			i = i + 1
+		case "--passthrough":
+			i = i + 1
+			a.Passthrough = true
+		case "--tee":
+			i = i + 1
+			a.Tee = true
+		case "--heartbeat":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Heartbeat = d
+			i = i + 1
+		case "--stream-output":
+			i = i + 1
+			a.StreamOutput = true
+		case "-f", "--filter":
+			i = i + 1
+			a.Filter = argv[i]
+			i = i + 1
+		case "--output-filter":
+			i = i + 1
+			a.OutputFilter = argv[i]
+			i = i + 1
+		case "--left-delim":
+			i = i + 1
+			a.LeftDelim = argv[i]
+			i = i + 1
+		case "--right-delim":
+			i = i + 1
+			a.RightDelim = argv[i]
+			i = i + 1
+		case "-I", "--replace":
+			i = i + 1
+			a.Replace = argv[i]
+			i = i + 1
+		case "--replace-field":
+			i = i + 1
+			a.ReplaceField = argv[i]
+			i = i + 1
+		case "--grpc":
+			i = i + 1
+			a.Grpc = argv[i]
+			i = i + 1
+		case "--listen":
+			i = i + 1
+			a.Listen = argv[i]
+			i = i + 1
+		case "--missing-var":
+			i = i + 1
+			a.MissingVar = argv[i]
+			i = i + 1
+		case "--escape":
+			i = i + 1
+			a.Escape = argv[i]
+			i = i + 1
+		case "--matrix":
+			i = i + 1
+			a.Matrix = true
+		case "--when":
+			i = i + 1
+			a.When = argv[i]
+			i = i + 1
+		case "--emit-skipped":
+			i = i + 1
+			a.EmitSkipped = true
+		case "-s", "--shell":
+			i = i + 1
+			a.Shell = true
+		case "--shell-path":
+			i = i + 1
+			a.Shell = true
+			a.ShellPath = argv[i]
+			i = i + 1
+		case "-e", "--env":
+			i = i + 1
+			a.Env = append(a.Env, argv[i])
+			i = i + 1
+		case "--env-from-object":
+			i = i + 1
+			a.EnvFromObject = argv[i]
+			i = i + 1
+		case "-n", "--dry-run":
+			i = i + 1
+			a.DryRun = true
+		case "--halt-on-error":
+			i = i + 1
+			a.HaltOnError = true
+		case "--exit-status":
+			i = i + 1
+			a.ExitStatus = argv[i]
+			i = i + 1
+		case "--success-exit-codes":
+			i = i + 1
+			codes := []int{}
+			for _, f := range strings.Split(argv[i], ",") {
+				n, err := strconv.Atoi(f)
+				if err != nil {
+					return err
+				}
+				codes = append(codes, n)
+			}
+			a.SuccessExitCodes = codes
+			i = i + 1
+		case "--rate":
+			i = i + 1
+			r, err := jpar.ParseRate(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Rate = r
+			i = i + 1
+		case "--rate-burst":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.RateBurst = n
+			i = i + 1
+		case "-C", "--cwd":
+			i = i + 1
+			a.Cwd = argv[i]
+			i = i + 1
+		case "--stdout-file":
+			i = i + 1
+			a.StdoutFile = argv[i]
+			i = i + 1
+		case "--stderr-file":
+			i = i + 1
+			a.StderrFile = argv[i]
+			i = i + 1
+		case "--parse-stdout":
+			i = i + 1
+			a.ParseStdout = argv[i]
+			i = i + 1
+		case "--max-output-bytes":
+			i = i + 1
+			n, err := strconv.ParseInt(argv[i], 10, 64)
+			if err != nil {
+				return err
+			}
+			a.MaxOutputBytes = n
+			i = i + 1
+		case "--key":
+			i = i + 1
+			a.Key = argv[i]
+			i = i + 1
+		case "--tag":
+			i = i + 1
+			a.Tags = append(a.Tags, argv[i])
+			i = i + 1
+		case "--state-file":
+			i = i + 1
+			a.StateFile = argv[i]
+			i = i + 1
+		case "--batch":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.Batch = n
+			i = i + 1
+		case "--batch-timeout":
+			i = i + 1
+			d, err := time.ParseDuration(argv[i])
+			if err != nil {
+				return err
+			}
+			a.BatchTimeout = d
+			i = i + 1
+		case "--dedupe":
+			i = i + 1
+			a.Dedupe = true
+		case "--dedupe-key":
+			i = i + 1
+			a.Dedupe = true
+			a.DedupeKey = argv[i]
+			i = i + 1
+		case "--queue-size":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.QueueSize = n
+			i = i + 1
+		case "--priority-field":
+			i = i + 1
+			a.PriorityField = argv[i]
+			i = i + 1
+		case "--dag":
+			i = i + 1
+			a.Dag = true
+		case "--id-field":
+			i = i + 1
+			a.IdField = argv[i]
+			i = i + 1
+		case "--depends-on-field":
+			i = i + 1
+			a.DependsOnField = argv[i]
+			i = i + 1
+		case "--group-by":
+			i = i + 1
+			a.GroupBy = argv[i]
+			i = i + 1
+		case "--group-parallelism":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.GroupParallelism = n
+			i = i + 1
+		case "--docker-image":
+			i = i + 1
+			a.DockerImage = argv[i]
+			i = i + 1
+		case "--docker-volume":
+			i = i + 1
+			a.DockerVolumes = append(a.DockerVolumes, argv[i])
+			i = i + 1
+		case "--docker-network":
+			i = i + 1
+			a.DockerNetwork = argv[i]
+			i = i + 1
+		case "--docker-env":
+			i = i + 1
+			a.DockerEnv = append(a.DockerEnv, argv[i])
+			i = i + 1
+		case "--docker-path":
+			i = i + 1
+			a.DockerPath = argv[i]
+			i = i + 1
+		case "--http":
+			i = i + 1
+			a.HTTP = true
+		case "-H", "--header":
+			i = i + 1
+			a.Headers = append(a.Headers, argv[i])
+			i = i + 1
+		case "--body":
+			i = i + 1
+			a.Body = argv[i]
+			i = i + 1
+		case "--render-to":
+			i = i + 1
+			a.RenderTo = argv[i]
+			i = i + 1
+		case "--k8s":
+			i = i + 1
+			a.K8s = true
+		case "--k8s-image":
+			i = i + 1
+			a.K8sImage = argv[i]
+			i = i + 1
+		case "--k8s-namespace":
+			i = i + 1
+			a.K8sNamespace = argv[i]
+			i = i + 1
+		case "--k8s-label":
+			i = i + 1
+			a.K8sLabels = append(a.K8sLabels, argv[i])
+			i = i + 1
+		case "--k8s-cpu":
+			i = i + 1
+			a.K8sCpu = argv[i]
+			i = i + 1
+		case "--k8s-memory":
+			i = i + 1
+			a.K8sMemory = argv[i]
+			i = i + 1
+		case "--kubectl-path":
+			i = i + 1
+			a.KubectlPath = argv[i]
+			i = i + 1
+		case "--only":
+			i = i + 1
+			a.Only = argv[i]
+			i = i + 1
+		case "--failures":
+			i = i + 1
+			a.Failures = argv[i]
+			i = i + 1
+		case "--failures-format":
+			i = i + 1
+			a.FailuresFormat = argv[i]
+			i = i + 1
+		case "--summary":
+			i = i + 1
+			a.Summary = true
+		case "--report":
+			i = i + 1
+			a.Reports = append(a.Reports, argv[i])
+			i = i + 1
+		case "--log-results":
+			i = i + 1
+			a.LogResults = argv[i]
+			i = i + 1
+		case "--otel-endpoint":
+			i = i + 1
+			a.OtelEndpoint = argv[i]
+			i = i + 1
+		case "--statsd":
+			i = i + 1
+			a.Statsd = argv[i]
+			i = i + 1
+		case "--tui":
+			i = i + 1
+			a.Tui = true
+		case "--control":
+			i = i + 1
+			a.Control = argv[i]
+			i = i + 1
+		case "--webhook":
+			i = i + 1
+			a.Webhook = argv[i]
+			i = i + 1
+		case "--webhook-on":
+			i = i + 1
+			a.WebhookOn = argv[i]
+			i = i + 1
+		case "--webhook-retries":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.WebhookRetries = n
+			i = i + 1
+		case "--webhook-concurrency":
+			i = i + 1
+			n, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			a.WebhookConcurrency = n
+			i = i + 1
+		case "--summary-fd":
+			i = i + 1
+			fd, err := strconv.Atoi(argv[i])
+			if err != nil {
+				return err
+			}
+			if fd < 0 {
+				return fmt.Errorf("invalid file descriptor %d", fd)
+			}
+			a.Summary = true
+			a.SummaryOutput = os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+			i = i + 1
+		case "--log-level":
+			i = i + 1
+			a.LogLevel = argv[i]
+			i = i + 1
+		case "--check":
+			i = i + 1
+			a.Check = true
+		case "--failed":
+			i = i + 1
+			a.ReplayFailures = true
+		case "--config", "--profile":
+			// Config files have already been read by withConfig.
+			i = i + 2
 		case "-d", "--debug":
 			i = i + 1
-			Debug = true
+			a.Debug = true
 		case "-v", "--version":
 			i = i + 1
 			fmt.Println(version)
 			return nil
 		case "-h", "--help":
 			i = i + 1
-			fmt.Printf("usage: %s [--parallelism N] [--debug] CMD\n", a.Prog)
+			fmt.Printf(USAGE, a.Prog)
 			return nil
+		case "--":
+			args = append(args, argv[i+1:]...)
+			break options
 		default:
-			args = append(args, argv[i])
-			i = i + 1
+			args = append(args, argv[i:]...)
+			break options
 		}
 	}
+	if a.Replay {
+		// The arguments are the results to replay.
+		a.Inputs = append(a.Inputs, args...)
+		return ActionCmd(a)
+	}
+	if len(args) == 0 {
+		args = command
+	}
 	a.Args = args
 	return ActionCmd(a)
 }
 
-const RETURNCODE_FAILURE = -4242
+const USAGE = `usage: %s [OPTIONS] [--] CMD
+       %[1]s check [OPTIONS] CMD
+       %[1]s plan [OPTIONS] CMD
+       %[1]s replay [OPTIONS] [RESULTS...]
+       %[1]s serve [--listen ADDR] [--grpc ADDR] [OPTIONS] CMD
 
-func ActionCmd(a *App) error {
-	if a.Parallelism < 1 {
-		return errors.New("at least one worker required")
+options:
+  -p, --parallelism N          number of concurrent workers
+  -t, --timeout DURATION       kill commands running longer than DURATION
+  --timeout-field FIELD        per-record timeout read from FIELD
+  --inactivity-timeout DURATION
+                               kill commands which write no output for DURATION
+  --input PATH                 read records from files or globs, - for stdin
+  --input-format FORMAT        json, jsonl, lines, csv, or tsv
+  --strict-input               abort the run on input which cannot be parsed
+  --head N                     use only the first N records
+  --skip N                     ignore the first N records
+  --sample P%%                  use a random P percent of the records
+  --seed N                     seed for --sample, to choose the same records again
+  -0, --null                   read NUL-separated lines, like xargs -0
+  --line-key KEY               field holding each line (default line)
+  --delimiter CHAR             field delimiter for csv and tsv input
+  --no-header                  csv and tsv columns are named col1, col2, ...
+  --quoting DIALECT            standard, lazy, or none for csv and tsv input
+  --output-format FORMAT       ndjson, concat, pretty, csv, or tap
+  --output-fields F1,F2,...    write only these result fields
+  --rename OLD=NEW             rename a result field (repeatable)
+  --output-filter EXPR         transform or select results with a jq expression
+  --echo-input POLICY          include the input record: never, always, or on-failure
+  --echo-fields F1,F2,...      include only these fields of the input record
+  --timings                    record started_at and finished_at for each job
+  -r, --retries N              retry failed commands up to N times
+  --retry-delay DURATION       delay before the first retry
+  --retry-backoff FACTOR       multiplier applied to the delay after each retry
+  --requeue-failures           retry by sending failed jobs back to the queue
+  --attempt-history            record every attempt under attempt_history
+  --repeat N                   run each command N times and report timing statistics
+  --then TEMPLATE              run another command once the last succeeds (repeatable)
+  --stdin-json                 write the input record as JSON to stdin
+  --stdin-field FIELD          write the value of FIELD to stdin
+  --stdin-file TEMPLATE        send the contents of a file to stdin
+  -k, --keep-order             emit results in input order
+  --reorder-buffer N           maximum results held back by --keep-order
+  --grace-period DURATION      time allowed for commands to exit on shutdown
+  --no-pgroup                  do not run commands in their own process groups
+  --nice N                     run commands with nice value N
+  --ionice CLASS[:LEVEL]       run commands in an I/O scheduling class (Linux)
+  --cgroup-mem SIZE            limit each command's memory to SIZE, like 512M (Linux)
+  --cgroup-cpu CPUS            limit each command to CPUS, like 0.5 (Linux)
+  --user NAME                  run commands as user NAME (requires root)
+  --uid N                      run commands as uid N (requires root)
+  --gid N                      run commands with gid N instead of the user's group
+  --user-field FIELD           per-record user read from FIELD
+  --sandbox DIR                run commands with DIR as their root (Linux)
+  --sandbox-bind SRC[:DST][:ro]
+                               make SRC visible in the sandbox (repeatable)
+  --allow-cmd LIST             only run the comma-separated commands (repeatable)
+  --secret NAME=env:VAR|file:PATH
+                               expose a secret as {{_secrets.NAME}} and $NAME (repeatable)
+  --redact REGEX               mask text matching REGEX in results and logs (repeatable)
+  --redact-field FIELD         mask the value of a record's FIELD in its result (repeatable)
+  --coprocess                  send records to one long-running command per worker
+  --output DEST                write results to a file, unix://PATH, or tcp://HOST:PORT
+  --passthrough                copy command output to stdout and stderr
+  --tee                        copy command output to stderr, tagged by job
+  --stream-output              write each line of output as an event as it arrives
+  --heartbeat DURATION         write an event for each job still running every DURATION
+  --kill-signal SIGNAL         signal sent to commands which time out (default KILL)
+  --kill-after DURATION        kill timed out commands still running after DURATION
+  -f, --filter EXPR            transform or select records with a jq expression
+  --left-delim DELIM           start template tags with DELIM instead of {{
+  --right-delim DELIM          end template tags with DELIM instead of }}
+  -I, --replace STR            replace STR in the command with the record, without templates
+  --replace-field FIELD        replace STR with FIELD instead of the whole record
+  --listen ADDR                serve on unix:///path/to/socket or tcp://host:port
+  --grpc ADDR                  serve the gRPC API on unix:///path/to/socket or tcp://host:port
+  --missing-var POLICY         records missing template variables: error, empty, or skip
+  --escape POLICY              make record values safe: none, shell, or strict
+  --matrix                     run every combination of the values in array fields
+  --when EXPR                  skip records for which a jq expression is false
+  --emit-skipped               write SKIPPED results for records not run
+  -s, --shell                  run the command through the shell
+  --shell-path PATH            shell used by --shell (default /bin/sh or cmd.exe)
+  -e, --env KEY=TEMPLATE       set an environment variable for each command
+  --env-from-object FIELD      export the fields of object FIELD (. for the record)
+  --check                      check the templates against a sample record on stdin
+  --failed                     replay only the commands of failed jobs
+  -n, --dry-run                show the expanded commands without running them
+  --halt-on-error              stop everything after the first failed job
+  --exit-status POLICY         any-failure, all-failure, or never
+  --success-exit-codes C1,...  exit codes counted as success (default 0)
+  --rate N/UNIT                launch at most N jobs per UNIT (s, m, h)
+  --rate-burst N               jobs which may launch at once under --rate
+  -C, --cwd TEMPLATE           working directory for each command
+  --stdout-file TEMPLATE       write each command's stdout to a file
+  --stderr-file TEMPLATE       write each command's stderr to a file
+  --max-output-bytes N         keep at most N bytes of stdout and stderr
+  --parse-stdout FORMAT        json to decode stdout into result, or none
+  --key TEMPLATE               identify records by an expanded template
+  --tag NAME=TEMPLATE          add an expanded label to each result (repeatable)
+  --state-file PATH            skip records completed by an earlier run
+  --batch N                    run each command with a batch of up to N records
+  --batch-timeout DURATION     run a partial batch after waiting DURATION
+  --dedupe                     skip records identical to an earlier record
+  --dedupe-key TEMPLATE        skip records whose expanded key was already seen
+  --queue-size N               read up to N records ahead of the workers
+  --priority-field FIELD       run records with higher values in FIELD first
+  --dag                        run records only after those they depend on succeed
+  --id-field FIELD             field identifying records for --dag (default id)
+  --depends-on-field FIELD     field listing the ids a record needs (default depends_on)
+  --group-by TEMPLATE          limit concurrency among records with the same key
+  --group-parallelism N        running jobs allowed per group (default 1)
+  --docker-image TEMPLATE      run each command in a container of this image
+  --docker-volume TEMPLATE     mount a volume in the container (repeatable)
+  --docker-network TEMPLATE    network mode for the container
+  --docker-env NAME            pass a host environment variable to the container
+  --docker-path PATH           docker client used by --docker-image
+  --only CLASS                 write only failures or successes
+  --failures DEST              write the input records of failed jobs to DEST
+  --failures-format FORMAT     original or result (default original)
+  --summary                    write a record of totals after the results
+  --summary-fd N               write the summary to file descriptor N instead
+  --report FORMAT=PATH         write a junit or html report once the run ends (repeatable)
+  --log-results LOG            record every job's outcome in syslog or journald
+  --otel-endpoint URL          export a trace span for each job with OTLP over HTTP
+  --statsd HOST:PORT           send job counts and timings to a StatsD server
+  --tui                        show a live dashboard of the run on the terminal
+  --control PATH               steer the run with commands sent to a unix socket
+  --webhook URL                post results to URL as JSON
+  --webhook-on WHEN            post every result, only failures, or only the summary
+  --webhook-retries N          retry failed posts up to N times (default 3)
+  --webhook-concurrency N      make at most N posts at once (default 4)
+  --log-level LEVEL            log to stderr at debug, info, warn, or error
+  --http                       make an HTTP request: CMD is METHOD URL
+  -H, --header NAME:TEMPLATE   add a header to HTTP requests (repeatable)
+  --body TEMPLATE              body of HTTP requests or rendered files
+  --render-to TEMPLATE         write the body to this path instead of running a command
+  --k8s                        run each command as a Kubernetes Job
+  --k8s-image TEMPLATE         container image for Kubernetes Jobs
+  --k8s-namespace TEMPLATE     namespace for Kubernetes Jobs
+  --k8s-label KEY=TEMPLATE     label Kubernetes Jobs (repeatable)
+  --k8s-cpu TEMPLATE           cpu request and limit, such as 500m
+  --k8s-memory TEMPLATE        memory request and limit, such as 1Gi
+  --kubectl-path PATH          kubectl used by --k8s
+  --config FILE                read options from a YAML file (repeatable)
+  --profile NAME               use the options and command of a config profile
+  -d, --debug                  add debugging fields to results
+  -v, --version                print the version
+  -h, --help                   print this message
+`
+
+// CheckCmd parses the templates, and checks them against the first
+// record on stdin unless stdin is a terminal.
+func CheckCmd(a *App) error {
+	var input io.Reader = os.Stdin
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		input = nil
+	}
+	problems, err := jpar.Check(a.Options, input)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) == 1 {
+		return &jpar.ExitError{Code: 1, Message: "1 problem found"}
+	}
+	if len(problems) > 1 {
+		return &jpar.ExitError{Code: 1, Message: fmt.Sprintf("%d problems found", len(problems))}
 	}
-	cmd := []*mustache.Template{}
-	for _, arg := range(a.Args) {
-		t, err := mustache.ParseString(arg)
-		if err != nil {
-			return nil
-		}
-		cmd = append(cmd, t)
-	}
-	jobs := make(chan Job)
-	results := make(chan Output)
-	inputDone := make(chan struct{})
-	workerDone := make(chan struct{})
-	outputDone := make(chan struct{})
-	// Launch workers
-	for i := 0; i < a.Parallelism; i++ {
-		go worker(i, cmd, jobs, results, workerDone)
-	}
-	// Display results from workers
-	go func() {
-		// Feed input to workers
-		j := ReadJsonStream(os.Stdin)
-		for x := range j {
-			if x.Err == nil {
-				jobs <- Job{Value: x.Value}
-			} else {
-				r := map[string]interface{}{}
-				r["cmd"] = []string{}
-				r["error"] = fmt.Sprintf("parse error: string(x.Err)")
-				r["returncode"] = RETURNCODE_FAILURE
-				r["stdout"] = ""
-				r["stderr"] = ""
-				r["outcome"] = OUTCOME_FAILURE
-				results <- Output{Value: r}
-			}
-		}
-		inputDone <- struct{}{}
-	}()
-	// Wait for input to complete.
-	go func() {
-		for x := range results {
-			if x.Done {
-				break
-			} else {
-				out, err := json.Marshal(x.Value)
-				if err != nil {
-					log.Panicf("Cannot marshal: %s", x)
-				}
-				os.Stdout.Write(out)
-			}
-		}
-		outputDone <- struct{}{}
-	}()
-	waitForTermination(inputDone, 1)
-	// Tell workers that there is no more work.  Workers will
-	// now quit.
-	for i := 0; i < a.Parallelism; i++ {
-		jobs <- Job{Done: true}
-	}
-	// Wait for workers to complete their current tasks.
-	waitForTermination(workerDone, a.Parallelism)
-	// Tell output routine that there is nothing left. Output
-	// routine will now quit.
-	results <- Output{Done: true}
 	return nil
 }
 
-
-func logf(format string, a ...interface{}) {
-	msg, _ := json.Marshal(map[string]string{"message": fmt.Sprintf(format, a)})
-	fmt.Print(string(msg))
-}
-
-func waitForTermination(done chan struct{}, count int) {
-	completed := 0
-	for _ = range done {
-		completed = completed + 1
-		if completed == count {
-			return
-		}
+// PlanCmd reads the whole input and reports what a run would do.
+func PlanCmd(a *App) error {
+	plan, problems, err := jpar.Plan(a.Options, os.Stdin)
+	if err != nil {
+		return err
 	}
-}
-
-func worker(
-	id int,
-	cmd []*mustache.Template,
-	jobs chan Job,
-	completed chan Output,
-	done chan struct{}) {
-	for job := range(jobs) {
-		if job.Done {
-			done <- struct{}{}
-			return
-		}
-		r := runJob(cmd, job.Value)
-		if Debug {
-			r["worker-id"] = id
-		}
-		completed <- Output{Value: r}
+	if err := json.NewEncoder(os.Stdout).Encode(plan); err != nil {
+		return err
+	}
+	if problems == 1 {
+		return &jpar.ExitError{Code: 1, Message: "1 problem found"}
+	}
+	if problems > 1 {
+		return &jpar.ExitError{Code: 1, Message: fmt.Sprintf("%d problems found", problems)}
 	}
+	return nil
 }
 
-func runJob(cmd []*mustache.Template, job interface{}) map[string]interface{} {
-	r := map[string]interface{}{}
-	r["e"] = job
-	args := instantiateArgs(cmd, job)
-	r["command"] = args
-	r["returncode"] = RETURNCODE_FAILURE
-	r["stdout"] = ""
-	r["stderr"] = ""
-	r["outcome"] = OUTCOME_FAILURE
-	prog, err := exec.LookPath(args[0])
-	if err != nil {
-		r["error"] = fmt.Sprintf("cannot locate command %s: %s", args[0], err)
-		return r
+func ActionCmd(a *App) error {
+	// The first SIGINT or SIGTERM cancels ctx.  Input stops being read,
+	// running commands receive SIGTERM, and they are killed if they are
+	// still running after the grace period.
+	if a.Passthrough && (a.Output == "" || a.Output == "-") {
+		return errors.New("--passthrough requires --output")
 	}
-	if Debug {
-		r["prog"] = prog
+	if a.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(a.LogLevel)); err != nil {
+			return fmt.Errorf("unknown log level %s", a.LogLevel)
+		}
+		a.Logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 	}
-	c := exec.Cmd{
-		Path: prog,
-		Args: args,
+	if a.Check {
+		return CheckCmd(a)
 	}
-	outRdr, err := c.StdoutPipe()
-	if err != nil {
-		r["error"] = fmt.Sprintf("cannot construct stdout: %s", err)
-		return r
+	if a.Plan {
+		return PlanCmd(a)
 	}
-	errRdr, err := c.StderrPipe()
-	if err != nil {
-		r["error"] = fmt.Sprintf("cannot construct stderr: %s", err)
-		return r
+	var output io.Writer = os.Stdout
+	if a.Output != "" {
+		w, err := jpar.OpenOutput(a.Output)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		output = w
 	}
-	err = c.Start()
-	if err != nil {
-		r["error"] = fmt.Sprintf("failed to launch cmd: %s", err)
-		return r
-	}
-	stdout := make(chan StringWithError)
-	stderr := make(chan StringWithError)
-	go func() {
-		out, err := ioutil.ReadAll(outRdr)
-		stdout <- StringWithError{string(out), err}
-		close(stdout)
-	}()
-	go func() {
-		out, err := ioutil.ReadAll(errRdr)
-		stderr <- StringWithError{string(out), err}
-		close(stderr)
-	}()
-	sout := <- stdout
-	serr := <- stderr
-	r["stdout"] = sout.Value
-	r["stderr"] = serr.Value
-	if sout.Err != nil {
-		r["error"] = fmt.Sprintf("stdout: %s", sout.Err.Error())
-	}
-	if serr.Err != nil {
-		msg := fmt.Sprintf("stderr: %s", serr.Err.Error())
-		err, ok := r["error"]
-		if ok {
-			r["error"] = fmt.Sprintf("%s; %s", err, msg)
-		} else {
-			r["error"] = msg
+	if a.Failures != "" {
+		w, err := jpar.OpenOutput(a.Failures)
+		if err != nil {
+			return err
 		}
+		defer w.Close()
+		a.FailuresOutput = w
 	}
-	c.Wait()
-	stat := c.ProcessState.Sys().(syscall.WaitStatus)
-	r["returncode"] = uint32(stat)
-	r["outcome"] = OUTCOME_SUCCESS
-	return r
-}
-
-
-func instantiateArgs(cmd []*mustache.Template, params interface{}) []string {
-	r := []string{}
-	for _, t := range(cmd) {
-		r = append(r, t.Render(false, params))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if a.Serve {
+		return ServeCmd(ctx, a)
 	}
-	return r
+	err := jpar.NewRunner(a.Options).Run(ctx, os.Stdin, output)
+	if ctx.Err() != nil {
+		return &jpar.ExitError{Code: EXIT_INTERRUPTED, Message: "interrupted"}
+	}
+	return err
 }
 
-func ReadJsonStream(stream *os.File) chan JsonRead {
-	dec := json.NewDecoder(stream)
-	out := make(chan JsonRead)
-	var j interface{}
-	go func() {
-		for {
-			if err := dec.Decode(&j); err != nil {
-				if err == io.EOF {
-					close(out)
-					return
-				} else {
-					out <- JsonRead{nil, err}
-					close(out)
-					return
-				}
-			}
-			out <- JsonRead{j, nil}
+// ServeCmd runs the server until it is interrupted.
+func ServeCmd(ctx context.Context, a *App) error {
+	if a.Listen == "" && a.Grpc == "" {
+		return errors.New("serve requires --listen or --grpc")
+	}
+	var l, g net.Listener
+	var err error
+	if a.Listen != "" {
+		if l, err = jpar.Listen(a.Listen); err != nil {
+			return err
 		}
-	}()
-	return out
-}
-
-type JsonRead struct {
-	Value interface{}
-	Err   error
-}
-
-type StringWithError struct {
-	Value string
-	Err error
-}
-
-type Job struct {
-	Value interface{}
-	Done bool
+	}
+	if a.Grpc != "" {
+		if g, err = jpar.Listen(a.Grpc); err != nil {
+			return err
+		}
+	}
+	err = jpar.NewRunner(a.Options).Serve(ctx, l, g)
+	if ctx.Err() != nil {
+		return &jpar.ExitError{Code: EXIT_INTERRUPTED, Message: "interrupted"}
+	}
+	return err
 }
-
-type Output struct {
-	Value interface{}
-	Done bool
-}
\ No newline at end of file
@@ -1 +1,84 @@
 package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withStdin makes input the process's stdin until the test ends.
+func withStdin(t *testing.T, input string) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = saved
+		f.Close()
+	})
+}
+
+// runResult runs jpar on one record, returning its result.
+func runResult(t *testing.T, input string, argv ...string) map[string]interface{} {
+	withStdin(t, input)
+	out := filepath.Join(t.TempDir(), "out.json")
+	// Failed jobs are reported in the result as well as the error.
+	NewApp().Run(append([]string{"jpar", "--output", out}, argv...))
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := map[string]interface{}{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("cannot read result %q: %s", b, err)
+	}
+	return r
+}
+
+// expectCommand checks that a result ran the given command.
+func expectCommand(t *testing.T, r map[string]interface{}, want ...string) {
+	got := []string{}
+	words, _ := r["command"].([]interface{})
+	for _, w := range words {
+		got = append(got, w.(string))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the command %q, got %v", want, r)
+	}
+}
+
+func TestRunOptionsEndAtCommand(t *testing.T) {
+	r := runResult(t, `{"a":"x"}`, "echo", "-n", "{{a}}")
+	expectCommand(t, r, "echo", "-n", "x")
+	if r["outcome"] != "SUCCESS" || r["stdout"] != "x" {
+		t.Errorf("expected -n to reach echo rather than start a dry run, got %v", r)
+	}
+	r = runResult(t, `{"a":"x"}`, "-p", "2", "--", "echo", "{{a}}")
+	expectCommand(t, r, "echo", "x")
+}
+
+func TestRunShortFlagsPassThrough(t *testing.T) {
+	// Each of jpar's short flags is also a common flag of other tools.
+	for _, flag := range []string{
+		"-t",
+		"-r",
+		"-k",
+		"-f",
+		"-s",
+		"-e",
+		"-C",
+		"-H",
+		"-I",
+	} {
+		r := runResult(t, `{"a":"x"}`, "echo", flag, "{{a}}")
+		expectCommand(t, r, "echo", flag, "x")
+	}
+}